@@ -0,0 +1,11 @@
+data "foxops_template_versions" "example" {
+  template_repository = "https://github.com/my-org/my-template"
+  current_version     = foxops_incarnation.example.template_repository_version
+}
+
+check "template_up_to_date" {
+  assert {
+    condition     = data.foxops_template_versions.example.versions_behind < 3
+    error_message = "The incarnation is more than two versions behind ${data.foxops_template_versions.example.latest_version}."
+  }
+}
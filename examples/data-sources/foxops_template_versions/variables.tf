@@ -0,0 +1,11 @@
+variable "foxops_endpoint" {
+  type        = string
+  description = "Endpoint of the Foxops API"
+  default     = null
+}
+
+variable "foxops_token" {
+  type        = string
+  description = "Authentication token for the Foxops API"
+  default     = null
+}
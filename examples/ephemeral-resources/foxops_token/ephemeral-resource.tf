@@ -0,0 +1,17 @@
+provider "foxops" {
+  alias          = "exchange"
+  endpoint       = var.foxops_endpoint
+  ephemeral_only = true
+}
+
+ephemeral "foxops_token" "ci" {
+  provider       = foxops.exchange
+  token_endpoint = "https://sso.example.com/oauth2/token"
+  oidc_token     = var.ci_job_jwt
+  audience       = "foxops"
+}
+
+provider "foxops" {
+  endpoint = var.foxops_endpoint
+  token    = ephemeral.foxops_token.ci.token
+}
@@ -0,0 +1,8 @@
+terraform {
+  required_providers {
+    foxops = {
+      source = "Roche/foxops"
+    }
+  }
+}
+
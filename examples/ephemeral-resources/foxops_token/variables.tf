@@ -0,0 +1,11 @@
+variable "foxops_endpoint" {
+  type        = string
+  description = "Endpoint of the Foxops API"
+  default     = null
+}
+
+variable "ci_job_jwt" {
+  type        = string
+  description = "OIDC token of the CI job"
+  ephemeral   = true
+}
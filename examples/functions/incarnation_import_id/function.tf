@@ -0,0 +1,4 @@
+import {
+  to = foxops_incarnation.example
+  id = provider::foxops::incarnation_import_id("group/project", "some-folder")
+}
@@ -0,0 +1,10 @@
+resource "foxops_incarnation" "example" {
+  incarnation_repository      = "group/project"
+  template_repository         = "group/template"
+  template_repository_version = "v1.0.0"
+
+  template_data_json = jsonencode(provider::foxops::merge_template_data(
+    yamldecode(file("defaults.yaml")),
+    { replicas = 3, database = { host = "db.example.com" } },
+  ))
+}
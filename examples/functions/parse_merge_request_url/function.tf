@@ -0,0 +1,3 @@
+output "merge_request_number" {
+  value = provider::foxops::parse_merge_request_url(foxops_incarnation.example.merge_request_url).number
+}
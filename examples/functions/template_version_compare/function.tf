@@ -0,0 +1,3 @@
+output "outdated" {
+  value = provider::foxops::template_version_compare(foxops_incarnation.example.template_repository_version, "v2.0.0") < 0
+}
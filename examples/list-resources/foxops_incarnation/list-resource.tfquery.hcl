@@ -0,0 +1,10 @@
+# Generate the configuration of the incarnations of a template with:
+#   terraform query -generate-config-out=incarnations.tf
+list "foxops_incarnation" "example" {
+  provider         = foxops
+  include_resource = true
+
+  config {
+    template_repository = "https://github.com/my-org/my-template"
+  }
+}
@@ -0,0 +1,6 @@
+import {
+  to = foxops_incarnation.example
+  identity = {
+    id = "<incarnation_id>"
+  }
+}
@@ -1 +1,5 @@
 terraform import foxops_incarnation.example "<edge_cluster_id>"
+
+# An incarnation can also be imported by its incarnation repository and
+# target directory, see the incarnation_import_id function.
+terraform import foxops_incarnation.example "<incarnation_repository>:<target_directory>"
@@ -0,0 +1 @@
+terraform import foxops_incarnation_template_data.example "<incarnation_id>:<key>[,<key>...]"
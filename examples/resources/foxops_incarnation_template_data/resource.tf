@@ -0,0 +1,7 @@
+resource "foxops_incarnation_template_data" "example" {
+  incarnation_id = foxops_incarnation.example.id
+
+  template_data = {
+    owner = "team-a"
+  }
+}
@@ -0,0 +1,7 @@
+resource "foxops_template_rollout" "example" {
+  template_repository = "https://github.com/my-org/my-template"
+  target_version      = "v2.0.0"
+  batch_size          = 20
+  failure_threshold   = 2
+  merge_timeout       = "1h"
+}
@@ -1,22 +1,27 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	e "errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	client_v1 "github.com/Roche/terraform-provider-foxops/internal/client/gen"
 	"github.com/Roche/terraform-provider-foxops/internal/helpers"
 	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
 	"github.com/deepmap/oapi-codegen/pkg/securityprovider"
 	"github.com/hashicorp/go-retryablehttp"
-	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/logging"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:generate oapi-codegen -generate types,client -package client_v1 -o ./gen/client.gen.go ./openapi.json
@@ -24,10 +29,30 @@ import (
 
 type client struct {
 	impl client_v1.ClientInterface
+
+	etagsMu sync.Mutex
+	etags   map[provider.IncarnationId]etagEntry
+}
+
+// etagEntry keeps the last representation of an incarnation returned along
+// with an ETag so that it can be reused when the server answers a
+// conditional request with 304 Not Modified.
+type etagEntry struct {
+	etag string
+	body []byte
 }
 
+// The ETag cache keeps at most maxETagEntries bodies of at most
+// maxETagBodySize bytes, the larger bodies are not cached.
+const (
+	maxETagEntries  = 1000
+	maxETagBodySize = 256 << 10
+)
+
 type clientOptions struct {
-	Transport http.RoundTripper
+	Transport                 http.RoundTripper
+	Headers                   map[string]string
+	SensitiveTemplateDataKeys []string
 }
 
 type ClientOption interface {
@@ -46,6 +71,30 @@ func (o clientTransportOption) apply(opts *clientOptions) {
 	opts.Transport = o.transport
 }
 
+type clientHeadersOption map[string]string
+
+// ClientHeaders adds headers to every request sent to Foxops. Their values
+// are redacted from the logs.
+func ClientHeaders(headers map[string]string) clientHeadersOption {
+	return clientHeadersOption(headers)
+}
+
+func (o clientHeadersOption) apply(opts *clientOptions) {
+	opts.Headers = o
+}
+
+type clientSensitiveTemplateDataKeysOption []string
+
+// ClientSensitiveTemplateDataKeys redacts the values of the given template
+// data keys from the logs.
+func ClientSensitiveTemplateDataKeys(keys []string) clientSensitiveTemplateDataKeysOption {
+	return clientSensitiveTemplateDataKeysOption(keys)
+}
+
+func (o clientSensitiveTemplateDataKeysOption) apply(opts *clientOptions) {
+	opts.SensitiveTemplateDataKeys = o
+}
+
 func New(
 	endpoint provider.ClientEndpoint,
 	token provider.ClientToken,
@@ -60,14 +109,25 @@ func New(
 		opt.apply(opts)
 	}
 
-	provider, err := securityprovider.NewSecurityProviderBearerToken(string(token))
+	bearerToken, err := securityprovider.NewSecurityProviderBearerToken(string(token))
 	if err != nil {
 		panic(err)
 	}
 
+	headerNames := make([]string, 0, len(opts.Headers))
+	for name := range opts.Headers {
+		headerNames = append(headerNames, name)
+	}
+
 	c, err := client_v1.NewClient(
 		string(endpoint),
-		client_v1.WithRequestEditorFn(provider.Intercept),
+		client_v1.WithRequestEditorFn(bearerToken.Intercept),
+		client_v1.WithRequestEditorFn(func(_ context.Context, req *http.Request) error {
+			for name, value := range opts.Headers {
+				req.Header.Set(name, value)
+			}
+			return nil
+		}),
 	)
 	if err != nil {
 		panic(err)
@@ -75,12 +135,45 @@ func New(
 
 	retryableHttpClient := retryablehttp.NewClient()
 	retryableHttpClient.HTTPClient = &http.Client{
-		Transport: helpers.NewTransport(string(version), logging.NewLoggingHTTPTransport(opts.Transport)),
-		Timeout:   5 * time.Minute,
+		Transport: helpers.NewTransport(
+			string(version),
+			helpers.NewLoggingTransport(
+				opts.Transport,
+				helpers.Redactor{
+					Headers:          headerNames,
+					TemplateDataKeys: opts.SensitiveTemplateDataKeys,
+				},
+				os.Getenv(helpers.LogHTTPBodiesEnvVar) == "true",
+			),
+		),
+		Timeout: 5 * time.Minute,
 	}
 	c.Client = retryableHttpClient.StandardClient()
 
-	return &client{c}
+	return &client{
+		impl:  c,
+		etags: make(map[provider.IncarnationId]etagEntry),
+	}
+}
+
+// startOperation tags the context with a new request id and starts a span for
+// the client operation. The returned function ends the span and adds the
+// request id to the error, so that it can be matched with the Foxops logs.
+func startOperation(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, func(error) error) {
+	ctx, requestID := helpers.WithRequestID(ctx)
+	attrs = append(attrs, attribute.String("foxops.request_id", requestID))
+	ctx, span := tracing.Start(ctx, "foxops.client."+name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) error {
+		if err != nil {
+			err = errors.WithMessagef(err, "request %s", requestID)
+		}
+		tracing.End(span, err)
+		return err
+	}
 }
 
 func (c *client) checkResponseStatus(_ context.Context, expected int, resp *http.Response) (err error) {
@@ -108,7 +201,57 @@ func (c *client) checkResponseStatus(_ context.Context, expected int, resp *http
 	return
 }
 
+func (c *client) ListIncarnations(
+	ctx context.Context,
+	req provider.ListIncarnationsRequest,
+) (incs []provider.IncarnationBasic, err error) {
+	ctx, end := startOperation(ctx, "ListIncarnations")
+	defer func() { err = end(err) }()
+
+	var resp *http.Response
+	resp, err = c.impl.ListIncarnationsApiIncarnationsGet(
+		ctx,
+		&client_v1.ListIncarnationsApiIncarnationsGetParams{
+			IncarnationRepository: req.IncarnationRepository,
+			TargetDirectory:       req.TargetDirectory,
+		},
+	)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	err = errors.WithStack(c.checkResponseStatus(ctx, http.StatusOK, resp))
+	if err != nil {
+		return
+	}
+
+	var data []client_v1.IncarnationBasic
+	err = errors.WithStack(json.NewDecoder(resp.Body).Decode(&data))
+	if err != nil {
+		return
+	}
+
+	incs = make([]provider.IncarnationBasic, 0, len(data))
+	for _, item := range data {
+		incs = append(incs, provider.IncarnationBasic{
+			Id:                    provider.IncarnationId(fmt.Sprintf("%d", item.Id)),
+			IncarnationRepository: item.IncarnationRepository,
+			TargetDirectory:       item.TargetDirectory,
+			CommitSha:             item.CommitSha,
+			CommitUrl:             item.CommitUrl,
+			MergeRequestUrl:       item.MergeRequestUrl,
+			MergeRequestId:        item.MergeRequestId,
+		})
+	}
+
+	return
+}
+
 func (c *client) GetIncarnation(ctx context.Context, id provider.IncarnationId) (inc provider.Incarnation, err error) {
+	ctx, end := startOperation(ctx, "GetIncarnation", attribute.String("foxops.incarnation.id", string(id)))
+	defer func() { err = end(err) }()
+
 	var resp *http.Response
 	idInt, err := strconv.Atoi(string(id))
 	if err != nil {
@@ -116,41 +259,129 @@ func (c *client) GetIncarnation(ctx context.Context, id provider.IncarnationId)
 		return
 	}
 
-	resp, err = c.impl.ReadIncarnationApiIncarnationsIncarnationIdGet(ctx, idInt)
+	c.etagsMu.Lock()
+	cached, hasETag := c.etags[id]
+	c.etagsMu.Unlock()
+
+	resp, err = c.impl.ReadIncarnationApiIncarnationsIncarnationIdGet(
+		ctx,
+		idInt,
+		func(_ context.Context, req *http.Request) error {
+			if hasETag {
+				req.Header.Set("If-None-Match", cached.etag)
+			}
+			return nil
+		},
+	)
 	if err != nil {
 		err = errors.WithStack(err)
 		return
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
+		c.forgetETag(id)
 		err = provider.ErrNotFound
 		return
 	}
 
+	if resp.StatusCode == http.StatusNotModified && hasETag {
+		inc, err = mapIncarnation(bytes.NewReader(cached.body))
+		return
+	}
+
 	err = errors.WithStack(c.checkResponseStatus(ctx, http.StatusOK, resp))
 	if err != nil {
 		return
 	}
 
-	inc, err = mapIncarnation(resp.Body)
+	var body []byte
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.storeETag(id, etagEntry{etag: etag, body: body})
+	}
+
+	inc, err = mapIncarnation(bytes.NewReader(body))
 
 	return
 }
 
+// storeETag keeps the body of an incarnation for conditional requests. When
+// the cache is full, an arbitrary entry is evicted.
+func (c *client) storeETag(id provider.IncarnationId, entry etagEntry) {
+	if len(entry.body) > maxETagBodySize {
+		c.forgetETag(id)
+		return
+	}
+
+	c.etagsMu.Lock()
+	defer c.etagsMu.Unlock()
+
+	if _, ok := c.etags[id]; !ok && len(c.etags) >= maxETagEntries {
+		for evicted := range c.etags {
+			delete(c.etags, evicted)
+			break
+		}
+	}
+	c.etags[id] = entry
+}
+
+func (c *client) forgetETag(id provider.IncarnationId) {
+	c.etagsMu.Lock()
+	delete(c.etags, id)
+	c.etagsMu.Unlock()
+}
+
 func (c *client) GetIncarnationWithMergeRequestStatus(
 	ctx context.Context,
 	id provider.IncarnationId,
 	status string,
 ) (inc provider.Incarnation, err error) {
-	for inc, err = c.GetIncarnation(ctx, id); err == nil; inc, err = c.GetIncarnation(ctx, id) {
+	ctx, span := tracing.Start(
+		ctx,
+		"foxops.client.GetIncarnationWithMergeRequestStatus",
+		trace.WithAttributes(
+			attribute.String("foxops.incarnation.id", string(id)),
+			attribute.String("foxops.merge_request.expected_status", status),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	for attempt := 1; ; attempt++ {
+		inc, err = c.pollMergeRequestStatus(ctx, id, attempt)
+		if err != nil {
+			return
+		}
 		if inc.MergeRequestId == nil {
 			return
 		}
-		if inc.MergeRequestStatus != nil && *inc.MergeRequestStatus == status {
+		if inc.MergeRequestSettled(status) {
 			return
 		}
 		time.Sleep(time.Second)
 	}
+}
+
+func (c *client) pollMergeRequestStatus(
+	ctx context.Context,
+	id provider.IncarnationId,
+	attempt int,
+) (inc provider.Incarnation, err error) {
+	ctx, span := tracing.Start(
+		ctx,
+		"foxops.client.PollMergeRequestStatus",
+		trace.WithAttributes(attribute.Int("foxops.poll.attempt", attempt)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	inc, err = c.GetIncarnation(ctx, id)
+	if inc.MergeRequestStatus != nil {
+		span.SetAttributes(attribute.String("foxops.merge_request.status", *inc.MergeRequestStatus))
+	}
 	return
 }
 
@@ -158,6 +389,9 @@ func (c *client) CreateIncarnation(
 	ctx context.Context,
 	req provider.CreateIncarnationRequest,
 ) (inc provider.Incarnation, err error) {
+	ctx, end := startOperation(ctx, "CreateIncarnation")
+	defer func() { err = end(err) }()
+
 	var resp *http.Response
 	body := client_v1.CreateIncarnationApiIncarnationsPostJSONRequestBody{
 		IncarnationRepository:     req.IncarnationRepository,
@@ -208,6 +442,9 @@ func (c *client) UpdateIncarnation(
 	id provider.IncarnationId,
 	req provider.UpdateIncarnationRequest,
 ) (inc provider.Incarnation, err error) {
+	ctx, end := startOperation(ctx, "UpdateIncarnation", attribute.String("foxops.incarnation.id", string(id)))
+	defer func() { err = end(err) }()
+
 	var resp *http.Response
 	body := client_v1.UpdateIncarnationApiIncarnationsIncarnationIdPutJSONRequestBody{
 		Automerge:                 req.AutoMerge,
@@ -236,6 +473,7 @@ func (c *client) UpdateIncarnation(
 		err = errors.WithStack(err)
 		return
 	}
+	c.forgetETag(id)
 	resp, err = c.impl.UpdateIncarnationApiIncarnationsIncarnationIdPut(
 		ctx,
 		idInt,
@@ -260,6 +498,9 @@ func (c *client) DeleteIncarnation(
 	ctx context.Context,
 	id provider.IncarnationId,
 ) (err error) {
+	ctx, end := startOperation(ctx, "DeleteIncarnation", attribute.String("foxops.incarnation.id", string(id)))
+	defer func() { err = end(err) }()
+
 	var resp *http.Response
 	idInt, err := strconv.Atoi(string(id))
 	if err != nil {
@@ -267,6 +508,7 @@ func (c *client) DeleteIncarnation(
 		return
 	}
 
+	c.forgetETag(id)
 	resp, err = c.impl.DeleteIncarnationApiIncarnationsIncarnationIdDelete(
 		ctx,
 		idInt,
@@ -283,6 +525,74 @@ func (c *client) DeleteIncarnation(
 	return
 }
 
+func (c *client) ResetIncarnation(
+	ctx context.Context,
+	id provider.IncarnationId,
+	req provider.ResetIncarnationRequest,
+) (result provider.ResetIncarnationResult, err error) {
+	ctx, end := startOperation(ctx, "ResetIncarnation", attribute.String("foxops.incarnation.id", string(id)))
+	defer func() { err = end(err) }()
+
+	body := client_v1.ResetIncarnationApiIncarnationsIncarnationIdResetPostJSONRequestBody{
+		OverrideVersion: req.OverrideVersion,
+	}
+
+	if req.OverrideTemplateData != nil {
+		templateData := map[string]client_v1.IncarnationResetRequest_OverrideTemplateData_AdditionalProperties{}
+		for key, ivalue := range req.OverrideTemplateData {
+			data := &client_v1.IncarnationResetRequest_OverrideTemplateData_AdditionalProperties{}
+			if value, ok := ivalue.(client_v1.IncarnationResetRequestOverrideTemplateData0); ok {
+				err = e.Join(err, data.FromIncarnationResetRequestOverrideTemplateData0(value))
+			} else if value, ok := ivalue.(client_v1.IncarnationResetRequestOverrideTemplateData1); ok {
+				err = e.Join(err, data.FromIncarnationResetRequestOverrideTemplateData1(value))
+			} else if value, ok := ivalue.(client_v1.IncarnationResetRequestOverrideTemplateData2); ok {
+				err = e.Join(err, data.FromIncarnationResetRequestOverrideTemplateData2(value))
+			}
+			templateData[key] = *data
+		}
+		body.OverrideTemplateData = &templateData
+	}
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	var resp *http.Response
+	idInt, err := strconv.Atoi(string(id))
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	c.forgetETag(id)
+	resp, err = c.impl.ResetIncarnationApiIncarnationsIncarnationIdResetPost(
+		ctx,
+		idInt,
+		body,
+	)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	err = errors.WithStack(c.checkResponseStatus(ctx, http.StatusOK, resp))
+	if err != nil {
+		return
+	}
+
+	var data client_v1.IncarnationResetResponse
+	err = errors.WithStack(json.NewDecoder(resp.Body).Decode(&data))
+	if err != nil {
+		return
+	}
+
+	result = provider.ResetIncarnationResult{
+		MergeRequestId:  data.MergeRequestId,
+		MergeRequestUrl: data.MergeRequestUrl,
+	}
+	return
+}
+
 func mapIncarnation(body io.Reader) (inc provider.Incarnation, err error) {
 	var data client_v1.IncarnationWithDetails
 	err = errors.WithStack(json.NewDecoder(body).Decode(&data))
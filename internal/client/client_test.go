@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Roche/terraform-provider-foxops/internal/client"
 	client_v1 "github.com/Roche/terraform-provider-foxops/internal/client/gen"
@@ -15,6 +18,11 @@ import (
 	"github.com/Roche/terraform-provider-foxops/internal/helpers"
 	"github.com/Roche/terraform-provider-foxops/internal/provider"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/mock/gomock"
 )
 
@@ -305,6 +313,52 @@ func TestClient_GetIncarnationWithMergeRequestStatus_ShouldRetryWhenReceivingOkA
 	require.Equal(t, want, got)
 }
 
+func TestClient_GetIncarnationWithMergeRequestStatus_ShouldStopWhenTheMergeRequestIsClosed(
+	t *testing.T,
+) {
+	id := 1234
+
+	polls := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, fmt.Sprintf("/api/incarnations/%d", id), r.URL.Path)
+		status := "open"
+		if polls.Add(1) > 1 {
+			status = "closed"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		require.NoError(t, json.NewEncoder(w).Encode(client_v1.IncarnationWithDetails{
+			Id:                        id,
+			IncarnationRepository:     "inc/repo",
+			TemplateRepository:        helpers.Addr("template/repo"),
+			TemplateRepositoryVersion: helpers.Addr("v1.0.0"),
+			TargetDirectory:           ".",
+			TemplateData:              &map[string]client_v1.IncarnationWithDetails_TemplateData_AdditionalProperties{},
+			CommitSha:                 "12345678",
+			CommitUrl:                 "template/repo/commit",
+			MergeRequestId:            helpers.Addr("1"),
+			MergeRequestStatus:        helpers.Addr[interface{}](status),
+		}))
+	}))
+	defer server.Close()
+
+	c := client.New(
+		provider.ClientEndpoint(server.URL),
+		provider.ClientToken("dev-token"),
+		"testing",
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	got, err := c.GetIncarnationWithMergeRequestStatus(ctx, provider.IncarnationId(fmt.Sprintf("%d", id)), "merged")
+
+	// The closed merge request will never be merged: the wait ends right away
+	// instead of when the context expires.
+	require.NoError(t, err)
+	require.Equal(t, helpers.Addr("closed"), got.MergeRequestStatus)
+	require.Equal(t, int32(2), polls.Load())
+}
+
 func TestClient_CreateIncarnation_ShouldSucceedWhenReceivingCreated(
 	t *testing.T,
 ) {
@@ -469,3 +523,263 @@ func TestClient_DeleteIncarnation_ShouldSucceedWhenReceivingOk(
 
 	require.NoError(t, err)
 }
+
+func TestClient_ResetIncarnation_ShouldSucceedWhenReceivingOk(
+	t *testing.T,
+) {
+	setup := setupClientTest(t)
+
+	ctx := context.Background()
+
+	id := provider.IncarnationId("1234")
+
+	body, err := json.Marshal(client_v1.IncarnationResetResponse{
+		IncarnationId:   1234,
+		MergeRequestId:  "7",
+		MergeRequestUrl: "inc/repo/mr!7",
+	})
+	require.NoError(t, err)
+
+	setup.MockRoundTripper.EXPECT().
+		RoundTrip(
+			client_mocks.NewRequestMatcher(
+				client_mocks.RequestMethod(http.MethodPost),
+				client_mocks.RequestPathf("/api/incarnations/%s/reset", id),
+				setup.AuthorizationHeader,
+			),
+		).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			var sent map[string]interface{}
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&sent))
+			require.Equal(t, map[string]interface{}{
+				"override_version":       "v1.0.0",
+				"override_template_data": map[string]interface{}{"hello": "World!"},
+			}, sent)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBuffer(body)),
+				Header:     make(http.Header),
+			}, nil
+		})
+
+	got, err := setup.Client.ResetIncarnation(ctx, id, provider.ResetIncarnationRequest{
+		OverrideVersion:      helpers.Addr("v1.0.0"),
+		OverrideTemplateData: map[string]interface{}{"hello": "World!"},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, provider.ResetIncarnationResult{MergeRequestId: "7", MergeRequestUrl: "inc/repo/mr!7"}, got)
+}
+
+func TestClient_GetIncarnation_ShouldReuseCachedBodyWhenReceivingNotModified(t *testing.T) {
+	setup := setupClientTest(t)
+
+	ctx := context.Background()
+
+	id := 1234
+
+	want := provider.Incarnation{
+		Id:                        provider.IncarnationId(fmt.Sprintf("%d", id)),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "template/repo/version",
+		TargetDirectory:           ".",
+		TemplateData:              map[string]interface{}{},
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+	}
+
+	body, err := json.Marshal(
+		client_v1.IncarnationWithDetails{
+			Id:                        id,
+			IncarnationRepository:     want.IncarnationRepository,
+			TemplateRepository:        &want.TemplateRepository,
+			TemplateRepositoryVersion: &want.TemplateRepositoryVersion,
+			TargetDirectory:           want.TargetDirectory,
+			TemplateData:              &map[string]client_v1.IncarnationWithDetails_TemplateData_AdditionalProperties{},
+			CommitSha:                 want.CommitSha,
+			CommitUrl:                 want.CommitUrl,
+		},
+	)
+	require.NoError(t, err)
+
+	response1 := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewBuffer(body)),
+		Header:     http.Header{"Etag": []string{`"v1"`}},
+	}
+
+	setup.MockRoundTripper.EXPECT().
+		RoundTrip(
+			client_mocks.NewRequestMatcher(
+				client_mocks.RequestMethod(http.MethodGet),
+				client_mocks.RequestPathf("/api/incarnations/%d", id),
+				setup.AuthorizationHeader,
+			),
+		).
+		Return(response1, nil)
+
+	response2 := &http.Response{
+		StatusCode: http.StatusNotModified,
+		Body:       io.NopCloser(bytes.NewBuffer(nil)),
+		Header:     make(http.Header),
+	}
+
+	setup.MockRoundTripper.EXPECT().
+		RoundTrip(
+			client_mocks.NewRequestMatcher(
+				client_mocks.RequestMethod(http.MethodGet),
+				client_mocks.RequestPathf("/api/incarnations/%d", id),
+				client_mocks.RequestHeader("if-none-match", `"v1"`),
+				setup.AuthorizationHeader,
+			),
+		).
+		Return(response2, nil)
+
+	got, err := setup.Client.GetIncarnation(ctx, want.Id)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	got, err = setup.Client.GetIncarnation(ctx, want.Id)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestClient_ListIncarnations_ShouldSucceedWhenReceivingOk(t *testing.T) {
+	setup := setupClientTest(t)
+
+	ctx := context.Background()
+
+	want := []provider.IncarnationBasic{
+		{
+			Id:                    provider.IncarnationId("1234"),
+			IncarnationRepository: "inc/repo",
+			TargetDirectory:       ".",
+			CommitSha:             "12345678",
+			CommitUrl:             "template/repo/commit",
+			MergeRequestId:        helpers.Addr("1"),
+			MergeRequestUrl:       helpers.Addr("inc/repo/mr!1"),
+		},
+	}
+
+	body, err := json.Marshal(
+		[]client_v1.IncarnationBasic{
+			{
+				Id:                    1234,
+				IncarnationRepository: want[0].IncarnationRepository,
+				TargetDirectory:       want[0].TargetDirectory,
+				CommitSha:             want[0].CommitSha,
+				CommitUrl:             want[0].CommitUrl,
+				MergeRequestId:        want[0].MergeRequestId,
+				MergeRequestUrl:       want[0].MergeRequestUrl,
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(bytes.NewBuffer(body)),
+		Header:     make(http.Header),
+	}
+
+	setup.MockRoundTripper.EXPECT().
+		RoundTrip(
+			client_mocks.NewRequestMatcher(
+				client_mocks.RequestMethod(http.MethodGet),
+				client_mocks.RequestPath("/api/incarnations"),
+				setup.AuthorizationHeader,
+			),
+		).
+		Return(response, nil)
+
+	got, err := setup.Client.ListIncarnations(ctx, provider.ListIncarnationsRequest{})
+
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+}
+
+func TestClient_DeleteIncarnation_ShouldIncludeRequestIdInErrors(
+	t *testing.T,
+) {
+	setup := setupClientTest(t)
+
+	ctx := context.Background()
+
+	id := provider.IncarnationId("1234")
+
+	body, err := json.Marshal(client_v1.ApiError{Message: "something went wrong"})
+	require.NoError(t, err)
+
+	var requestID string
+	setup.MockRoundTripper.EXPECT().
+		RoundTrip(
+			client_mocks.NewRequestMatcher(
+				client_mocks.RequestMethod(http.MethodDelete),
+				client_mocks.RequestPathf("/api/incarnations/%s", id),
+				setup.AuthorizationHeader,
+			),
+		).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			requestID = req.Header.Get("X-Request-ID")
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(bytes.NewBuffer(body)),
+				Header:     make(http.Header),
+			}, nil
+		})
+
+	err = setup.Client.DeleteIncarnation(ctx, id)
+
+	require.Error(t, err)
+	require.NotEmpty(t, requestID)
+	require.Contains(t, err.Error(), requestID)
+	require.Contains(t, err.Error(), "something went wrong")
+}
+
+func TestClient_GetIncarnation_ShouldRecordSpans(
+	t *testing.T,
+) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	setup := setupClientTest(t)
+
+	ctx := context.Background()
+
+	id := provider.IncarnationId("1234")
+
+	var requestID string
+	setup.MockRoundTripper.EXPECT().
+		RoundTrip(
+			client_mocks.NewRequestMatcher(
+				client_mocks.RequestMethod(http.MethodGet),
+				client_mocks.RequestPathf("/api/incarnations/%s", id),
+				setup.AuthorizationHeader,
+			),
+		).
+		DoAndReturn(func(req *http.Request) (*http.Response, error) {
+			requestID = req.Header.Get("X-Request-ID")
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Body:       io.NopCloser(bytes.NewBuffer([]byte{})),
+				Header:     make(http.Header),
+			}, nil
+		})
+
+	_, err := setup.Client.GetIncarnation(ctx, id)
+	require.ErrorIs(t, err, provider.ErrNotFound)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	httpSpan, clientSpan := spans[0], spans[1]
+	require.Equal(t, "HTTP GET", httpSpan.Name())
+	require.Equal(t, "foxops.client.GetIncarnation", clientSpan.Name())
+	require.Equal(t, clientSpan.SpanContext().SpanID(), httpSpan.Parent().SpanID())
+	require.Contains(t, clientSpan.Attributes(), attribute.String("foxops.request_id", requestID))
+	require.Contains(t, httpSpan.Attributes(), attribute.Int("http.response.status_code", http.StatusNotFound))
+	require.Equal(t, codes.Error, clientSpan.Status().Code)
+}
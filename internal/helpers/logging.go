@@ -0,0 +1,223 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	// LogHTTPBodiesEnvVar enables the logging of redacted request and
+	// response bodies at the TRACE level.
+	LogHTTPBodiesEnvVar = "FOXOPS_LOG_HTTP_BODIES"
+
+	redacted = "[REDACTED]"
+)
+
+// templateDataFields are the fields of the Foxops API payloads holding
+// template variables.
+var templateDataFields = []string{
+	"template_data",
+	"override_template_data",
+	"requested_data",
+}
+
+type sensitiveTemplateDataKeysKey struct{}
+
+// WithSensitiveTemplateDataKeys returns a context marking the given template
+// data keys as sensitive for the requests made with it.
+func WithSensitiveTemplateDataKeys(ctx context.Context, keys ...string) context.Context {
+	if len(keys) == 0 {
+		return ctx
+	}
+	existing, _ := ctx.Value(sensitiveTemplateDataKeysKey{}).([]string)
+	merged := append(append([]string{}, existing...), keys...)
+	return context.WithValue(ctx, sensitiveTemplateDataKeysKey{}, merged)
+}
+
+// Redactor describes the values hidden from the HTTP logs.
+type Redactor struct {
+	// Headers are the names of the headers whose values are redacted, in
+	// addition to the Authorization header.
+	Headers []string
+	// TemplateDataKeys are the template data keys whose values are redacted,
+	// in addition to the ones marked sensitive in the request context.
+	TemplateDataKeys []string
+}
+
+type loggingTransport struct {
+	next      http.RoundTripper
+	redactor  Redactor
+	logBodies bool
+}
+
+// NewLoggingTransport returns a transport logging a structured summary of each
+// request. When logBodies is set, the request and response bodies are also
+// logged at the TRACE level with their secrets redacted.
+func NewLoggingTransport(next http.RoundTripper, redactor Redactor, logBodies bool) http.RoundTripper {
+	return &loggingTransport{next, redactor, logBodies}
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := t.maskSecrets(req.Context(), req.Header)
+
+	fields := map[string]interface{}{
+		"method":     req.Method,
+		"path":       req.URL.Path,
+		"request_id": req.Header.Get(RequestIDHeader),
+	}
+
+	if t.logBodies {
+		var body []byte
+		body, req.Body = drainBody(req.Body)
+		tflog.Trace(ctx, "Sending Foxops API request", map[string]interface{}{
+			"method":  req.Method,
+			"path":    req.URL.Path,
+			"headers": t.redactHeaders(req.Header),
+			"body":    t.redactBody(req.Context(), body),
+		})
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	fields["duration_ms"] = time.Since(start).Milliseconds()
+
+	if err != nil {
+		fields["error"] = err.Error()
+		tflog.Debug(ctx, "Foxops API request failed", fields)
+		return resp, err
+	}
+
+	fields["status"] = resp.StatusCode
+	tflog.Debug(ctx, "Foxops API request", fields)
+
+	if t.logBodies {
+		var body []byte
+		body, resp.Body = drainBody(resp.Body)
+		tflog.Trace(ctx, "Received Foxops API response", map[string]interface{}{
+			"status":  resp.StatusCode,
+			"headers": t.redactHeaders(resp.Header),
+			"body":    t.redactBody(req.Context(), body),
+		})
+	}
+
+	return resp, err
+}
+
+// maskSecrets masks the values of the redacted headers wherever they could
+// appear in the logs.
+func (t *loggingTransport) maskSecrets(ctx context.Context, header http.Header) context.Context {
+	var secrets []string
+	for _, name := range t.redactedHeaders() {
+		for _, value := range header.Values(name) {
+			if value == "" {
+				continue
+			}
+			secrets = append(secrets, value)
+			if _, token, ok := strings.Cut(value, " "); ok && token != "" {
+				secrets = append(secrets, token)
+			}
+		}
+	}
+	if len(secrets) == 0 {
+		return ctx
+	}
+	ctx = tflog.MaskAllFieldValuesStrings(ctx, secrets...)
+	return tflog.MaskMessageStrings(ctx, secrets...)
+}
+
+func (t *loggingTransport) redactedHeaders() []string {
+	return append([]string{"Authorization"}, t.redactor.Headers...)
+}
+
+func (t *loggingTransport) redactHeaders(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for name, values := range header {
+		result[name] = strings.Join(values, ", ")
+	}
+	for _, name := range t.redactedHeaders() {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := result[name]; ok {
+			result[name] = redacted
+		}
+	}
+	return result
+}
+
+func (t *loggingTransport) redactBody(ctx context.Context, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	// A body which is not JSON cannot be redacted, it could hold secrets in
+	// any form.
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Sprintf("%s (%d bytes, not JSON)", redacted, len(body))
+	}
+
+	keys := map[string]bool{}
+	for _, key := range t.redactor.TemplateDataKeys {
+		keys[key] = true
+	}
+	contextKeys, _ := ctx.Value(sensitiveTemplateDataKeysKey{}).([]string)
+	for _, key := range contextKeys {
+		keys[key] = true
+	}
+
+	redactTemplateData(payload, keys)
+
+	result, err := json.Marshal(payload)
+	if err != nil {
+		return redacted
+	}
+	return string(result)
+}
+
+func redactTemplateData(payload interface{}, keys map[string]bool) {
+	switch payload := payload.(type) {
+	case map[string]interface{}:
+		for field, value := range payload {
+			if data, ok := value.(map[string]interface{}); ok && isTemplateDataField(field) {
+				for key := range data {
+					if keys[key] {
+						data[key] = redacted
+					}
+				}
+				continue
+			}
+			redactTemplateData(value, keys)
+		}
+	case []interface{}:
+		for _, item := range payload {
+			redactTemplateData(item, keys)
+		}
+	}
+}
+
+func isTemplateDataField(field string) bool {
+	for _, f := range templateDataFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func drainBody(body io.ReadCloser) ([]byte, io.ReadCloser) {
+	if body == nil || body == http.NoBody {
+		return nil, body
+	}
+	// On read errors, the partial body is passed on so that the caller
+	// observes a truncated payload rather than a silently swallowed one.
+	data, _ := io.ReadAll(body)
+	_ = body.Close()
+	return data, io.NopCloser(bytes.NewReader(data))
+}
@@ -0,0 +1,116 @@
+package helpers_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func sendLoggedRequest(t *testing.T, ctx context.Context, logBodies bool) string {
+	var output bytes.Buffer
+	ctx = tflogtest.RootLogger(ctx, &output)
+
+	transport := helpers.NewLoggingTransport(
+		roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), "super-secret")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"id":1,"template_data":{"password":"super-secret","name":"app"}}`)),
+			}, nil
+		}),
+		helpers.Redactor{
+			Headers:          []string{"X-Gateway-Key"},
+			TemplateDataKeys: []string{"password"},
+		},
+		logBodies,
+	)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPut,
+		"http://localhost/api/incarnations/1",
+		strings.NewReader(`{"template_data":{"password":"super-secret","api_key":"other-secret","name":"app"}}`),
+	)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer dev-token")
+	req.Header.Set("X-Gateway-Key", "gateway-secret")
+
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "super-secret")
+
+	return output.String()
+}
+
+func TestLoggingTransport_ShouldOnlyLogSummariesByDefault(t *testing.T) {
+	output := sendLoggedRequest(t, context.Background(), false)
+
+	entries, err := tflogtest.MultilineJSONDecode(strings.NewReader(output))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "PUT", entries[0]["method"])
+	require.Equal(t, "/api/incarnations/1", entries[0]["path"])
+	require.Equal(t, float64(http.StatusOK), entries[0]["status"])
+	require.Contains(t, entries[0], "duration_ms")
+	require.NotContains(t, output, "super-secret")
+	require.NotContains(t, output, "dev-token")
+}
+
+func TestLoggingTransport_ShouldRedactSecretsFromBodies(t *testing.T) {
+	ctx := helpers.WithSensitiveTemplateDataKeys(context.Background(), "api_key")
+
+	output := sendLoggedRequest(t, ctx, true)
+
+	require.Contains(t, output, `\"name\":\"app\"`)
+	require.NotContains(t, output, "super-secret")
+	require.NotContains(t, output, "other-secret")
+	require.NotContains(t, output, "dev-token")
+	require.NotContains(t, output, "gateway-secret")
+}
+
+func TestLoggingTransport_ShouldNotLogBodiesWhichAreNotJSON(t *testing.T) {
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+
+	transport := helpers.NewLoggingTransport(
+		roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Header:     http.Header{"Content-Type": []string{"text/plain"}},
+				Body:       io.NopCloser(strings.NewReader(`failed to render template_data password=super-secret`)),
+			}, nil
+		}),
+		helpers.Redactor{TemplateDataKeys: []string{"password"}},
+		true,
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/api/incarnations", strings.NewReader(`password=other-secret`))
+	require.NoError(t, err)
+
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	require.Contains(t, output.String(), "not JSON")
+	require.NotContains(t, output.String(), "super-secret")
+	require.NotContains(t, output.String(), "other-secret")
+}
@@ -0,0 +1,24 @@
+package helpers
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying a newly generated request id,
+// which is sent in the X-Request-ID header of the requests made with it.
+func WithRequestID(ctx context.Context) (context.Context, string) {
+	id := uuid.NewString()
+	return context.WithValue(ctx, requestIDKey{}, id), id
+}
+
+// RequestID returns the request id carried by the context, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -4,6 +4,13 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type transport struct {
@@ -16,6 +23,32 @@ func NewTransport(version string, httpClient http.RoundTripper) http.RoundTrippe
 }
 
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestID := RequestID(req.Context())
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+
+	ctx, span := tracing.Start(
+		req.Context(),
+		fmt.Sprintf("HTTP %s", req.Method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+			attribute.String("http.request.header.x-request-id", requestID),
+		),
+	)
+
+	req = req.WithContext(ctx)
 	req.Header.Add("User-Agent", fmt.Sprintf("Foxops Terraform Provider/%s (%s; %s)", t.version, runtime.GOOS, runtime.GOARCH))
-	return t.HTTPClient.RoundTrip(req)
+	req.Header.Set(RequestIDHeader, requestID)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.HTTPClient.RoundTrip(req)
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	}
+	tracing.End(span, err)
+
+	return resp, err
 }
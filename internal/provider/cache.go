@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/sync/singleflight"
+)
+
+// refreshCacheBatchSize is the maximum number of incarnation details fetched
+// concurrently by the cache.
+const refreshCacheBatchSize = 25
+
+type cachedIncarnation struct {
+	inc       Incarnation
+	fetchedAt time.Time
+}
+
+type cachedList struct {
+	incs      []IncarnationBasic
+	fetchedAt time.Time
+}
+
+// cachingClient is a FoxopsClient which keeps the incarnations it has read for
+// the duration of ttl. On the first cache miss, it lists every incarnation
+// known to Foxops and fetches their details in the background, at most
+// refreshCacheBatchSize at a time, so that the reads issued by the following
+// resources are served from memory. The concurrent reads of the same
+// incarnation, or of the same list of incarnations, are merged into a single
+// request.
+type cachingClient struct {
+	FoxopsClient
+
+	ttl time.Duration
+
+	group   singleflight.Group
+	fetches chan struct{}
+
+	mu           sync.Mutex
+	entries      map[IncarnationId]cachedIncarnation
+	lists        map[string]cachedList
+	prefetchedAt time.Time
+}
+
+var _ FoxopsClient = (*cachingClient)(nil)
+
+// NewCachingClient wraps client with a read cache whose entries expire after ttl.
+func NewCachingClient(client FoxopsClient, ttl time.Duration) FoxopsClient {
+	return &cachingClient{
+		FoxopsClient: client,
+		ttl:          ttl,
+		fetches:      make(chan struct{}, refreshCacheBatchSize),
+		entries:      make(map[IncarnationId]cachedIncarnation),
+		lists:        make(map[string]cachedList),
+	}
+}
+
+func (c *cachingClient) GetIncarnation(ctx context.Context, id IncarnationId) (Incarnation, error) {
+	if inc, ok := c.lookup(id); ok {
+		return inc, nil
+	}
+
+	c.prefetch(ctx)
+	return c.fetch(ctx, id)
+}
+
+// fetch reads an incarnation, unless it was stored by a concurrent read.
+func (c *cachingClient) fetch(ctx context.Context, id IncarnationId) (Incarnation, error) {
+	v, err := c.do(ctx, "incarnation/"+string(id), func(ctx context.Context) (interface{}, error) {
+		if inc, ok := c.lookup(id); ok {
+			return inc, nil
+		}
+
+		c.fetches <- struct{}{}
+		defer func() { <-c.fetches }()
+
+		inc, err := c.FoxopsClient.GetIncarnation(ctx, id)
+		if err != nil {
+			return Incarnation{}, err
+		}
+		c.store(inc)
+		return inc, nil
+	})
+	inc, _ := v.(Incarnation)
+	return inc, err
+}
+
+// prefetch starts filling the cache from the list endpoint when it has not
+// been done within the last ttl. The details are fetched in the background in
+// batches of refreshCacheBatchSize, the caller only waits for the incarnation
+// it reads. Failures are only
+// logged: the incarnations which are not prefetched are fetched when read.
+func (c *cachingClient) prefetch(ctx context.Context) {
+	c.mu.Lock()
+	if !c.prefetchedAt.IsZero() && time.Since(c.prefetchedAt) <= c.ttl {
+		c.mu.Unlock()
+		return
+	}
+	c.prefetchedAt = time.Now()
+	c.mu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		incs, err := c.ListIncarnations(ctx, ListIncarnationsRequest{})
+		if err != nil {
+			tflog.Warn(ctx, "failed to prefetch incarnations", map[string]interface{}{"error": err.Error()})
+			return
+		}
+
+		tflog.Debug(ctx, "prefetching incarnations", map[string]interface{}{"count": len(incs)})
+
+		for start := 0; start < len(incs); start += refreshCacheBatchSize {
+			var wg sync.WaitGroup
+			for _, basic := range incs[start:min(start+refreshCacheBatchSize, len(incs))] {
+				if _, ok := c.lookup(basic.Id); ok {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.fetch(ctx, basic.Id); err != nil && !errors.Is(err, ErrNotFound) {
+						tflog.Debug(ctx, "failed to prefetch incarnation", map[string]interface{}{"id": basic.Id, "error": err.Error()})
+					}
+				}()
+			}
+			wg.Wait()
+		}
+	}()
+}
+
+func (c *cachingClient) ListIncarnations(ctx context.Context, req ListIncarnationsRequest) ([]IncarnationBasic, error) {
+	key := "list"
+	if req.IncarnationRepository != nil {
+		key += "/repository=" + *req.IncarnationRepository
+	}
+	if req.TargetDirectory != nil {
+		key += "/directory=" + *req.TargetDirectory
+	}
+
+	c.mu.Lock()
+	list, ok := c.lists[key]
+	c.mu.Unlock()
+	if ok && time.Since(list.fetchedAt) <= c.ttl {
+		return list.incs, nil
+	}
+
+	v, err := c.do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		incs, err := c.FoxopsClient.ListIncarnations(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.lists[key] = cachedList{incs: incs, fetchedAt: time.Now()}
+		c.mu.Unlock()
+		return incs, nil
+	})
+	incs, _ := v.([]IncarnationBasic)
+	return incs, err
+}
+
+func (c *cachingClient) GetIncarnationWithMergeRequestStatus(
+	ctx context.Context,
+	id IncarnationId,
+	status string,
+) (inc Incarnation, err error) {
+	inc, err = c.FoxopsClient.GetIncarnationWithMergeRequestStatus(ctx, id, status)
+	if err == nil {
+		c.store(inc)
+	}
+	return
+}
+
+func (c *cachingClient) CreateIncarnation(ctx context.Context, req CreateIncarnationRequest) (Incarnation, error) {
+	c.forgetLists()
+	return c.FoxopsClient.CreateIncarnation(ctx, req)
+}
+
+func (c *cachingClient) UpdateIncarnation(
+	ctx context.Context,
+	id IncarnationId,
+	req UpdateIncarnationRequest,
+) (inc Incarnation, err error) {
+	c.forget(id)
+	return c.FoxopsClient.UpdateIncarnation(ctx, id, req)
+}
+
+func (c *cachingClient) DeleteIncarnation(ctx context.Context, id IncarnationId) error {
+	c.forget(id)
+	c.forgetLists()
+	return c.FoxopsClient.DeleteIncarnation(ctx, id)
+}
+
+func (c *cachingClient) ResetIncarnation(
+	ctx context.Context,
+	id IncarnationId,
+	req ResetIncarnationRequest,
+) (ResetIncarnationResult, error) {
+	c.forget(id)
+	return c.FoxopsClient.ResetIncarnation(ctx, id, req)
+}
+
+// do merges the concurrent calls with the same key into a single call of fn.
+// fn runs with a context which is not cancelled along with the first caller,
+// so that the cancellation of one caller does not fail the others; each
+// caller still stops waiting when its own context is done.
+func (c *cachingClient) do(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	detached := context.WithoutCancel(ctx)
+	result := c.group.DoChan(key, func() (interface{}, error) {
+		return fn(detached)
+	})
+	select {
+	case r := <-result:
+		return r.Val, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *cachingClient) lookup(id IncarnationId) (Incarnation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || time.Since(entry.fetchedAt) > c.ttl {
+		return Incarnation{}, false
+	}
+	return entry.inc, true
+}
+
+func (c *cachingClient) store(inc Incarnation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[inc.Id] = cachedIncarnation{inc: inc, fetchedAt: time.Now()}
+}
+
+func (c *cachingClient) forget(id IncarnationId) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
+
+func (c *cachingClient) forgetLists() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.lists)
+}
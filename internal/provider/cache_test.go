@@ -0,0 +1,263 @@
+package provider_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	mock_provider "github.com/Roche/terraform-provider-foxops/internal/provider/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func cacheTestIncarnations(count int) ([]provider.IncarnationBasic, map[provider.IncarnationId]provider.Incarnation) {
+	basics := make([]provider.IncarnationBasic, 0, count)
+	incs := make(map[provider.IncarnationId]provider.Incarnation, count)
+	for i := 1; i <= count; i++ {
+		id := provider.IncarnationId(fmt.Sprintf("%d", i))
+		basics = append(basics, provider.IncarnationBasic{
+			Id:                    id,
+			IncarnationRepository: "inc/repo",
+			TargetDirectory:       fmt.Sprintf("dir-%d", i),
+		})
+		incs[id] = provider.Incarnation{
+			Id:                        id,
+			IncarnationRepository:     "inc/repo",
+			TargetDirectory:           fmt.Sprintf("dir-%d", i),
+			TemplateRepository:        "template/repo",
+			TemplateRepositoryVersion: "v1.0.0",
+			TemplateData:              map[string]interface{}{},
+		}
+	}
+	return basics, incs
+}
+
+// expectNoListedIncarnations lets the cache prefetch an empty list of
+// incarnations.
+func expectNoListedIncarnations(client *mock_provider.MockFoxopsClient) {
+	client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{}).
+		Return([]provider.IncarnationBasic{}, nil).
+		AnyTimes()
+}
+
+func TestCachingClient_GetIncarnation_ShouldFillFromTheListEndpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+
+	basics, incs := cacheTestIncarnations(60)
+
+	listed := make(chan struct{})
+	client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{}).
+		DoAndReturn(func(context.Context, provider.ListIncarnationsRequest) ([]provider.IncarnationBasic, error) {
+			close(listed)
+			return basics, nil
+		}).
+		Times(1)
+
+	// Every incarnation is fetched once, by the prefetch or by its first read.
+	for _, basic := range basics {
+		client.EXPECT().
+			GetIncarnation(gomock.Any(), basic.Id).
+			Return(incs[basic.Id], nil).
+			Times(1)
+	}
+
+	cache := provider.NewCachingClient(client, time.Minute)
+
+	var wg sync.WaitGroup
+	for _, basic := range basics {
+		wg.Add(1)
+		go func(id provider.IncarnationId) {
+			defer wg.Done()
+			got, err := cache.GetIncarnation(context.Background(), id)
+			assert.NoError(t, err)
+			assert.Equal(t, incs[id], got)
+		}(basic.Id)
+	}
+	wg.Wait()
+
+	got, err := cache.GetIncarnation(context.Background(), "1")
+	require.NoError(t, err)
+	require.Equal(t, incs["1"], got)
+
+	// The list runs in the background and may start after the reads.
+	select {
+	case <-listed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the incarnations were not listed")
+	}
+}
+
+func TestCachingClient_GetIncarnation_CancelledCallerShouldNotFailTheOthers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+	expectNoListedIncarnations(client)
+
+	_, incs := cacheTestIncarnations(1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	client.EXPECT().
+		GetIncarnation(gomock.Any(), provider.IncarnationId("1")).
+		DoAndReturn(func(ctx context.Context, id provider.IncarnationId) (provider.Incarnation, error) {
+			close(started)
+			<-release
+			return incs[id], ctx.Err()
+		}).
+		Times(1)
+
+	cache := provider.NewCachingClient(client, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := cache.GetIncarnation(ctx, "1")
+		first <- err
+	}()
+	<-started
+
+	second := make(chan error)
+	go func() {
+		got, err := cache.GetIncarnation(context.Background(), "1")
+		assert.Equal(t, incs["1"], got)
+		second <- err
+	}()
+
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+	close(release)
+	assert.NoError(t, <-second)
+}
+
+func TestCachingClient_ListIncarnations_ShouldBeCachedUntilAnIncarnationIsCreated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+
+	basics, incs := cacheTestIncarnations(2)
+	repository := "inc/repo"
+
+	client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{IncarnationRepository: &repository}).
+		Return(basics[:1], nil).
+		Times(1)
+	client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(incs["2"], nil)
+	client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{IncarnationRepository: &repository}).
+		Return(basics, nil).
+		Times(1)
+
+	cache := provider.NewCachingClient(client, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		got, err := cache.ListIncarnations(context.Background(), provider.ListIncarnationsRequest{IncarnationRepository: &repository})
+		require.NoError(t, err)
+		require.Equal(t, basics[:1], got)
+	}
+
+	_, err := cache.CreateIncarnation(context.Background(), provider.CreateIncarnationRequest{})
+	require.NoError(t, err)
+
+	got, err := cache.ListIncarnations(context.Background(), provider.ListIncarnationsRequest{IncarnationRepository: &repository})
+	require.NoError(t, err)
+	require.Equal(t, basics, got)
+}
+
+func TestCachingClient_UpdateIncarnation_ShouldInvalidateTheEntry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+	expectNoListedIncarnations(client)
+
+	_, incs := cacheTestIncarnations(1)
+
+	client.EXPECT().
+		GetIncarnation(gomock.Any(), provider.IncarnationId("1")).
+		Return(incs["1"], nil).
+		Times(2)
+
+	client.EXPECT().
+		UpdateIncarnation(gomock.Any(), provider.IncarnationId("1"), gomock.Any()).
+		Return(incs["1"], nil)
+
+	cache := provider.NewCachingClient(client, time.Minute)
+
+	_, err := cache.GetIncarnation(context.Background(), "1")
+	require.NoError(t, err)
+
+	_, err = cache.UpdateIncarnation(context.Background(), "1", provider.UpdateIncarnationRequest{})
+	require.NoError(t, err)
+
+	_, err = cache.GetIncarnation(context.Background(), "1")
+	require.NoError(t, err)
+}
+
+// slowClient simulates the round trip latency of a Foxops instance.
+type slowClient struct {
+	provider.FoxopsClient
+	latency time.Duration
+	basics  []provider.IncarnationBasic
+	incs    map[provider.IncarnationId]provider.Incarnation
+}
+
+func (c *slowClient) ListIncarnations(context.Context, provider.ListIncarnationsRequest) ([]provider.IncarnationBasic, error) {
+	time.Sleep(c.latency)
+	return c.basics, nil
+}
+
+func (c *slowClient) GetIncarnation(_ context.Context, id provider.IncarnationId) (provider.Incarnation, error) {
+	time.Sleep(c.latency)
+	return c.incs[id], nil
+}
+
+// BenchmarkIncarnationRefresh reads 800 incarnations once, like the resources
+// of a refresh, with the parallelism used by Terraform, with and without the
+// read cache.
+func BenchmarkIncarnationRefresh(b *testing.B) {
+	const count = 800
+	const parallelism = 10
+
+	basics, incs := cacheTestIncarnations(count)
+
+	refresh := func(client provider.FoxopsClient) {
+		ids := make(chan provider.IncarnationId)
+		var wg sync.WaitGroup
+		for i := 0; i < parallelism; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for id := range ids {
+					if _, err := client.GetIncarnation(context.Background(), id); err != nil {
+						b.Error(err)
+					}
+				}
+			}()
+		}
+		for _, basic := range basics {
+			ids <- basic.Id
+		}
+		close(ids)
+		wg.Wait()
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			refresh(&slowClient{latency: time.Millisecond, basics: basics, incs: incs})
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			refresh(provider.NewCachingClient(
+				&slowClient{latency: time.Millisecond, basics: basics, incs: incs},
+				time.Minute,
+			))
+		}
+	})
+}
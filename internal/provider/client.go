@@ -23,6 +23,35 @@ type Incarnation struct {
 	MergeRequestId            *string
 }
 
+// MergeRequestSettled tells whether waiting for the merge request of the
+// incarnation to reach the status is over: either it reached it, or it is
+// merged or closed and will not reach it anymore.
+func (inc Incarnation) MergeRequestSettled(status string) bool {
+	if inc.MergeRequestStatus == nil {
+		return false
+	}
+	switch *inc.MergeRequestStatus {
+	case status, "merged", "closed":
+		return true
+	}
+	return false
+}
+
+type IncarnationBasic struct {
+	Id                    IncarnationId
+	IncarnationRepository string
+	TargetDirectory       string
+	CommitSha             string
+	CommitUrl             string
+	MergeRequestUrl       *string
+	MergeRequestId        *string
+}
+
+type ListIncarnationsRequest struct {
+	IncarnationRepository *string
+	TargetDirectory       *string
+}
+
 type UpdateIncarnationRequest struct {
 	AutoMerge                 bool
 	TemplateData              map[string]interface{}
@@ -36,11 +65,26 @@ type CreateIncarnationRequest struct {
 	TemplateRepository    string
 }
 
+// ResetIncarnationRequest resets an incarnation to a previous state through a
+// merge request. The current version and template data are used when no
+// override is given.
+type ResetIncarnationRequest struct {
+	OverrideVersion      *string
+	OverrideTemplateData map[string]interface{}
+}
+
+type ResetIncarnationResult struct {
+	MergeRequestId  string
+	MergeRequestUrl string
+}
+
 //go:generate mockgen -destination ./mocks/client_mock.go . FoxopsClient
 type FoxopsClient interface {
+	ListIncarnations(context.Context, ListIncarnationsRequest) ([]IncarnationBasic, error)
 	GetIncarnation(context.Context, IncarnationId) (Incarnation, error)
 	GetIncarnationWithMergeRequestStatus(context.Context, IncarnationId, string) (Incarnation, error)
 	CreateIncarnation(context.Context, CreateIncarnationRequest) (Incarnation, error)
 	UpdateIncarnation(context.Context, IncarnationId, UpdateIncarnationRequest) (Incarnation, error)
 	DeleteIncarnation(context.Context, IncarnationId) error
+	ResetIncarnation(context.Context, IncarnationId, ResetIncarnationRequest) (ResetIncarnationResult, error)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
 	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
@@ -28,38 +29,47 @@ type waitForStatusMRModel struct {
 	Timeout types.String `tfsdk:"timeout"`
 }
 
+// durationValidator validates the durations given as a sequence of numbers
+// followed by a unit suffix, like `1m30s`.
+var durationValidator = stringvalidator.RegexMatches(
+	regexp.MustCompile(`^(\d+[smh])+$`),
+	`must be a sequence of numbers with a unit suffix. Valid unit suffixes are "s", "m" and "h". Example: "1m30s"`,
+)
+
+// waitForSchema is the schema of wait_for_mr_status. The resource and the
+// provider defaults build their own merge request waits from its attributes.
 var waitForSchema = schema.SingleNestedAttribute{
 	MarkdownDescription: "Wait for the status of the last merge request to reach a status before completing the current operation. " +
 		"This field only affects incarnation that have been updated as it requires a merge request to exist.",
 	Optional: true,
 	Attributes: map[string]schema.Attribute{
-		"status": schema.StringAttribute{
-			MarkdownDescription: "The expected status for the merge request. Can be one of `open`, `merge`, `closed` or `unknown`.",
-			Required:            true,
-			Validators: []validator.String{
-				stringvalidator.OneOf(
-					"open",
-					"merged",
-					"closed",
-					"unknown",
-				),
-			},
-		},
-		"timeout": schema.StringAttribute{
-			MarkdownDescription: "The amount of time to wait for the expected status to be reached. " +
-				"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). " +
-				"Example: `1m30s`. Default: `10s`.",
-			Optional: true,
-			Validators: []validator.String{
-				stringvalidator.RegexMatches(
-					regexp.MustCompile(`(\d+[smh])+`),
-					`must be a sequence of numbers with a unit suffix. Valid unit suffixes are "s", "m" and "h". Example: "1m30s"`,
-				),
-			},
-		},
+		"status":  waitForStatusSchema,
+		"timeout": waitForTimeoutSchema,
 	},
 }
 
+var waitForStatusSchema = schema.StringAttribute{
+	MarkdownDescription: "The expected status for the merge request. Can be one of `open`, `merge`, `closed` or `unknown`.",
+	Required:            true,
+	Validators: []validator.String{
+		stringvalidator.OneOf(
+			"open",
+			"merged",
+			"closed",
+			"unknown",
+		),
+	},
+}
+
+var waitForTimeoutSchema = schema.StringAttribute{
+	MarkdownDescription: "The amount of time to wait for the expected status to be reached. " +
+		"The wait fails right away when the merge request is merged or closed without reaching it. " +
+		"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). " +
+		"Example: `1m30s`. Default: `10s`.",
+	Optional:   true,
+	Validators: []validator.String{durationValidator},
+}
+
 type incarnationDatasourceModel struct {
 	Id                        types.String          `tfsdk:"id"`
 	IncarnationRepository     types.String          `tfsdk:"incarnation_repository"`
@@ -84,16 +94,16 @@ func (ds *incarnationDataSource) Configure(ctx context.Context, req datasource.C
 		return
 	}
 
-	client, ok := req.ProviderData.(FoxopsClient)
+	data, ok := req.ProviderData.(*resourceProviderData)
 	if !ok {
 		resp.Diagnostics.AddError(
 			"Unexpected Data Source Configure Type",
-			fmt.Sprintf("Expected provider.FoxopsClient, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+			fmt.Sprintf("Expected *provider.resourceProviderData, got: %T. Please report this issue to the provider developers.", req.ProviderData),
 		)
 		return
 	}
 
-	ds.client = client
+	ds.client = data.client
 }
 
 func (ds *incarnationDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
@@ -165,6 +175,9 @@ func (ds *incarnationDataSource) Schema(ctx context.Context, req datasource.Sche
 }
 
 func (ds *incarnationDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	ctx, span := tracing.Start(ctx, "data.foxops_incarnation.Read")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
 	var data incarnationDatasourceModel
 	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
@@ -175,7 +188,7 @@ func (ds *incarnationDataSource) Read(ctx context.Context, req datasource.ReadRe
 
 	var inc Incarnation
 	var diags diag.Diagnostics
-	inc, diags = getIncarnation(
+	inc, _, diags = getIncarnation(
 		ctx,
 		ds.client,
 		IncarnationId(id),
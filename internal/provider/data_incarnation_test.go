@@ -2,6 +2,7 @@ package provider_test
 
 import (
 	"fmt"
+	"regexp"
 	"testing"
 
 	"github.com/Roche/terraform-provider-foxops/internal/helpers"
@@ -33,7 +34,7 @@ func TestAcc_IncarnationDataSource(t *testing.T) {
 	setup.client.EXPECT().
 		GetIncarnation(gomock.Any(), incarnation.Id).
 		Return(incarnation, nil).
-		Times(5)
+		Times(3)
 
 	hello, ok := incarnation.TemplateData["hello"].(string)
 	require.True(t, ok)
@@ -93,7 +94,7 @@ func TestAcc_IncarnationDataSource_WithWaitForMRStatus(t *testing.T) {
 	setup.client.EXPECT().
 		GetIncarnationWithMergeRequestStatus(gomock.Any(), incarnation.Id, *incarnation.MergeRequestStatus).
 		Return(incarnation, nil).
-		Times(5)
+		Times(3)
 
 	resource.Test(t, resource.TestCase{
 		IsUnitTest:               true,
@@ -127,3 +128,24 @@ func TestAcc_IncarnationDataSource_WithWaitForMRStatus(t *testing.T) {
 		},
 	})
 }
+
+func TestAcc_IncarnationDataSource_ShouldRejectInvalidTimeout(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + `data "foxops_incarnation" "test" {
+  id   = "1234"
+  wait_for_mr_status = {
+	status  = "merged"
+	timeout = "10mins"
+  }
+}`,
+				ExpectError: regexp.MustCompile(`must be a sequence of numbers with a unit suffix`),
+			},
+		},
+	})
+}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+type templateVersionsDataSource struct {
+	versions       *templateVersions
+	templatePolicy *repositoryPolicy
+}
+
+var _ datasource.DataSourceWithConfigure = (*templateVersionsDataSource)(nil)
+
+func NewTemplateVersionsDataSource() datasource.DataSource {
+	return &templateVersionsDataSource{}
+}
+
+type templateVersionsDatasourceModel struct {
+	TemplateRepository types.String `tfsdk:"template_repository"`
+	CurrentVersion     types.String `tfsdk:"current_version"`
+	Versions           types.List   `tfsdk:"versions"`
+	Tags               types.List   `tfsdk:"tags"`
+	Branches           types.List   `tfsdk:"branches"`
+	LatestVersion      types.String `tfsdk:"latest_version"`
+	VersionsBehind     types.Int64  `tfsdk:"versions_behind"`
+}
+
+var templateVersionType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"tag":        types.StringType,
+		"version":    types.StringType,
+		"prerelease": types.BoolType,
+	},
+}
+
+func (ds *templateVersionsDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_template_versions"
+}
+
+func (ds *templateVersionsDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	data, ok := req.ProviderData.(*resourceProviderData)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Data Source Configure Type",
+			fmt.Sprintf("Expected *provider.resourceProviderData, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+		return
+	}
+
+	ds.versions = data.versions
+	ds.templatePolicy = data.templatePolicy
+}
+
+func (ds *templateVersionsDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Use this data source to list the versions of a template repository.",
+		MarkdownDescription: "Use this data source to list the versions of a template repository. " +
+			"The tags and branches are listed with `git ls-remote`, using the git credentials of the machine running Terraform.",
+		Attributes: map[string]schema.Attribute{
+			"template_repository": schema.StringAttribute{
+				MarkdownDescription: "The url of the template repository. It must be an http(s), ssh or scp-like url, like `git@example.com:group/template.git`, or, with the provider `allow_local_template_repositories` setting, an absolute path or a `file://` url.",
+				Required:            true,
+			},
+			"current_version": schema.StringAttribute{
+				MarkdownDescription: "The version currently used by an incarnation, like its `template_repository_version`. " +
+					"Used to compute `versions_behind`.",
+				Optional: true,
+			},
+			"versions": schema.ListNestedAttribute{
+				MarkdownDescription: "The tags of the repository which are semantic versions, sorted from the oldest to the newest.",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"tag": schema.StringAttribute{
+							MarkdownDescription: "The name of the tag, like `v1.2.3`.",
+							Computed:            true,
+						},
+						"version": schema.StringAttribute{
+							MarkdownDescription: "The semantic version of the tag, like `1.2.3`.",
+							Computed:            true,
+						},
+						"prerelease": schema.BoolAttribute{
+							MarkdownDescription: "Whether the version is a pre-release, like `1.3.0-rc.1`.",
+							Computed:            true,
+						},
+					},
+				},
+			},
+			"tags": schema.ListAttribute{
+				MarkdownDescription: "The names of all the tags of the repository, sorted alphabetically.",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+			"branches": schema.ListAttribute{
+				MarkdownDescription: "The names of the branches of the repository, sorted alphabetically.",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+			"latest_version": schema.StringAttribute{
+				MarkdownDescription: "The tag of the newest version which is not a pre-release. " +
+					"`null` when the repository has no such version.",
+				Computed: true,
+			},
+			"versions_behind": schema.Int64Attribute{
+				MarkdownDescription: "The number of versions which are not pre-releases and are newer than `current_version`. " +
+					"`null` when `current_version` is not set or is not a semantic version.",
+				Computed: true,
+			},
+		},
+	}
+}
+
+func (ds *templateVersionsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	ctx, span := tracing.Start(ctx, "data.foxops_template_versions.Read")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data templateVersionsDatasourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(checkRepositoryPolicy(
+		ds.templatePolicy,
+		path.Root("template_repository"),
+		"Template repository not allowed",
+		data.TemplateRepository,
+	)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// The tags are shared with the incarnations resolving a version
+	// constraint of the same repository.
+	refs, err := ds.versions.refs(ctx, data.TemplateRepository.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(
+			path.Root("template_repository"),
+			"Unable to list the template versions",
+			err.Error(),
+		)
+		return
+	}
+
+	versions := refs.versions()
+
+	values := []attr.Value{}
+	data.LatestVersion = types.StringNull()
+	for _, v := range versions {
+		prerelease := v.version.Prerelease() != ""
+		values = append(values, types.ObjectValueMust(templateVersionType.AttrTypes, map[string]attr.Value{
+			"tag":        types.StringValue(v.tag),
+			"version":    types.StringValue(v.version.String()),
+			"prerelease": types.BoolValue(prerelease),
+		}))
+		if !prerelease {
+			data.LatestVersion = types.StringValue(v.tag)
+		}
+	}
+	data.Versions = types.ListValueMust(templateVersionType, values)
+
+	var diags diag.Diagnostics
+	data.Tags, diags = types.ListValueFrom(ctx, types.StringType, refs.tags)
+	resp.Diagnostics.Append(diags...)
+	data.Branches, diags = types.ListValueFrom(ctx, types.StringType, refs.branches)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.VersionsBehind = types.Int64Null()
+	if current, err := version.NewVersion(data.CurrentVersion.ValueString()); err == nil && !data.CurrentVersion.IsNull() {
+		behind := int64(0)
+		for _, v := range versions {
+			if v.version.Prerelease() == "" && v.version.GreaterThan(current) {
+				behind += 1
+			}
+		}
+		data.VersionsBehind = types.Int64Value(behind)
+	}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,138 @@
+package provider_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcc_TemplateVersionsDataSource(t *testing.T) {
+	source, _ := newTemplateRepository(t, "v1.0.0", "v1.1.0", "v1.10.0", "v1.2.0", "v2.0.0-rc.1", "latest")
+
+	// Template repositories are usually served as bare repositories.
+	bare := filepath.Join(t.TempDir(), "template.git")
+	output, err := exec.Command("git", "clone", "--quiet", "--bare", source, bare).CombinedOutput()
+	require.NoError(t, err, string(output))
+	output, err = exec.Command("git", "-C", bare, "branch", "feature").CombinedOutput()
+	require.NoError(t, err, string(output))
+	repository := serveGitRepository(t, bare)
+
+	setup := newTestProviderSetup(t)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "` + repository + `"
+  current_version     = "v1.1.0"
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.#", "5"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.0.tag", "v1.0.0"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.0.version", "1.0.0"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.2.tag", "v1.2.0"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.3.tag", "v1.10.0"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.4.tag", "v2.0.0-rc.1"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.4.prerelease", "true"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "tags.#", "6"),
+					resource.TestCheckTypeSetElemAttr("data.foxops_template_versions.test", "tags.*", "latest"),
+					resource.TestCheckTypeSetElemAttr("data.foxops_template_versions.test", "branches.*", "feature"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "latest_version", "v1.10.0"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions_behind", "2"),
+				),
+			},
+			{
+				Config: providerConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "` + repository + `"
+  current_version     = "main"
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckNoResourceAttr("data.foxops_template_versions.test", "versions_behind"),
+				),
+			},
+			{
+				Config: providerConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "` + strings.TrimSuffix(repository, "/template.git") + `/missing.git"
+}
+`,
+				ExpectError: regexp.MustCompile("Unable to list the template versions"),
+			},
+			{
+				Config: providerConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "--upload-pack=touch ` + filepath.Join(t.TempDir(), "pwned") + `"
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)Unable to list the template versions.*unsupported template repository`),
+			},
+			{
+				Config: providerConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "file://` + bare + `"
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)Unable to list the template versions.*unsupported template repository`),
+			},
+			{
+				Config: localTemplateRepositoriesProviderConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "file://` + bare + `"
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "latest_version", "v1.10.0"),
+					resource.TestCheckTypeSetElemAttr("data.foxops_template_versions.test", "branches.*", "feature"),
+				),
+			},
+			{
+				// Local bare repositories are listed by their path as well.
+				Config: localTemplateRepositoriesProviderConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "` + bare + `"
+  current_version     = "v1.0.0"
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions.#", "5"),
+					resource.TestCheckResourceAttr("data.foxops_template_versions.test", "versions_behind", "3"),
+				),
+			},
+			{
+				Config: providerConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "` + bare + `"
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)Unable to list the template versions.*unsupported template repository`),
+			},
+			{
+				Config: localTemplateRepositoriesProviderConfig + `
+data "foxops_template_versions" "test" {
+  template_repository = "ext::sh -c touch% ` + filepath.Join(t.TempDir(), "pwned") + `"
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)Unable to list the template versions.*unsupported template repository`),
+			},
+		},
+	})
+}
+
+const localTemplateRepositoriesProviderConfig = `
+provider "foxops" {
+  endpoint                          = "http://localhost:9876"
+  token                             = "fake-token"
+  allow_local_template_repositories = true
+}
+`
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// incarnationDefaultsModel holds the values of the provider `defaults` block,
+// applied to the incarnations which do not set them.
+type incarnationDefaultsModel struct {
+	TemplateRepository normalizedStringValue `tfsdk:"template_repository"`
+	TargetDirectory    normalizedStringValue `tfsdk:"target_directory"`
+	TemplateData       types.Map             `tfsdk:"template_data"`
+	WaitForMRStatus    *waitForStatusMRModel `tfsdk:"wait_for_mr_status_on_update"`
+}
+
+// resourceProviderData is the data shared by the provider with its resources
+// and data sources.
+type resourceProviderData struct {
+	client   FoxopsClient
+	defaults incarnationDefaultsModel
+	slots    *incarnationSlots
+	locks    *incarnationLocks
+	versions *templateVersions
+	forges   []forgeClient
+
+	templatePolicy    *repositoryPolicy
+	incarnationPolicy *repositoryPolicy
+}
+
+var defaultsSchema = schema.SingleNestedAttribute{
+	MarkdownDescription: "Default values applied to every `foxops_incarnation` resource. " +
+		"Values set on a resource take precedence over these defaults and `template_data` is merged key by key. " +
+		"The merged values are shown in the plan.",
+	Optional: true,
+	Attributes: map[string]schema.Attribute{
+		"template_repository": schema.StringAttribute{
+			CustomType:          templateRepositoryType,
+			MarkdownDescription: "The repository containing the template used by incarnations which do not set `template_repository`.",
+			Optional:            true,
+		},
+		"target_directory": schema.StringAttribute{
+			CustomType:          targetDirectoryType,
+			MarkdownDescription: "The folder used by incarnations which do not set `target_directory`.",
+			Optional:            true,
+		},
+		"template_data": schema.MapAttribute{
+			MarkdownDescription: "Variables merged into the `template_data` of every incarnation. " +
+				"Keys set by an incarnation override these values.",
+			ElementType: types.StringType,
+			Optional:    true,
+		},
+		"wait_for_mr_status_on_update": schema.SingleNestedAttribute{
+			MarkdownDescription: "The `wait_for_mr_status_on_update` settings used by incarnations which do not set them.",
+			Optional:            true,
+			Attributes: map[string]schema.Attribute{
+				"status": schema.StringAttribute{
+					MarkdownDescription: waitForStatusSchema.MarkdownDescription,
+					Required:            true,
+					Validators:          waitForStatusSchema.Validators,
+				},
+				"timeout": schema.StringAttribute{
+					MarkdownDescription: waitForTimeoutSchema.MarkdownDescription,
+					Optional:            true,
+					Validators:          waitForTimeoutSchema.Validators,
+				},
+			},
+		},
+	},
+}
+
+// mergeTemplateData returns the template data of an incarnation merged with
+// the default template data. The default keys set in one of the excluded maps,
+// like the sensitive template data, are left out.
+func mergeTemplateData(defaults types.Map, configured types.Map, excluded ...types.Map) types.Map {
+	if defaults.IsNull() {
+		if configured.IsNull() {
+			return types.MapValueMust(types.StringType, map[string]attr.Value{})
+		}
+		return configured
+	}
+	if defaults.IsUnknown() || configured.IsUnknown() {
+		return types.MapUnknown(types.StringType)
+	}
+
+	elements := map[string]attr.Value{}
+	for key, value := range defaults.Elements() {
+		elements[key] = value
+	}
+	for _, m := range excluded {
+		if m.IsUnknown() {
+			return types.MapUnknown(types.StringType)
+		}
+		for key := range m.Elements() {
+			delete(elements, key)
+		}
+	}
+	for key, value := range configured.Elements() {
+		elements[key] = value
+	}
+
+	return types.MapValueMust(types.StringType, elements)
+}
+
+// selectTemplateData returns the values of the template data keys set in keys,
+// so that the keys added by the defaults are only stored in template_data_all.
+func selectTemplateData(ctx context.Context, values map[string]string, keys types.Map) (types.Map, diag.Diagnostics) {
+	if keys.IsNull() {
+		return types.MapNull(types.StringType), nil
+	}
+	result := map[string]string{}
+	for key := range keys.Elements() {
+		if value, ok := values[key]; ok {
+			result[key] = value
+		}
+	}
+	return types.MapValueFrom(ctx, types.StringType, result)
+}
+
+// defaultTemplateData returns the template data merged from the defaults,
+// which is the template data not set by the incarnation itself.
+func defaultTemplateData(merged types.Map, configured types.Map) types.Map {
+	if merged.IsUnknown() || configured.IsUnknown() {
+		return types.MapUnknown(types.StringType)
+	}
+	elements := map[string]attr.Value{}
+	for key, value := range merged.Elements() {
+		if _, ok := configured.Elements()[key]; !ok {
+			elements[key] = value
+		}
+	}
+	return types.MapValueMust(types.StringType, elements)
+}
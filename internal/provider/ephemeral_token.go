@@ -0,0 +1,371 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// The OAuth 2.0 token exchange grant (RFC 8693) and the types of the tokens
+// exchanged.
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+	idTokenType            = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// tokenPrivateKey is the key of the private data of a foxops_token, which is
+// kept by Terraform while the token is open and never written to a file.
+const tokenPrivateKey = "token"
+
+type tokenEphemeralResource struct {
+	httpClient *http.Client
+}
+
+var _ ephemeral.EphemeralResourceWithConfigure = (*tokenEphemeralResource)(nil)
+var _ ephemeral.EphemeralResourceWithRenew = (*tokenEphemeralResource)(nil)
+var _ ephemeral.EphemeralResourceWithClose = (*tokenEphemeralResource)(nil)
+
+func NewTokenEphemeralResource() ephemeral.EphemeralResource {
+	return &tokenEphemeralResource{}
+}
+
+type tokenEphemeralResourceModel struct {
+	TokenEndpoint      types.String `tfsdk:"token_endpoint"`
+	RevocationEndpoint types.String `tfsdk:"revocation_endpoint"`
+	Credential         types.String `tfsdk:"credential"`
+	OIDCToken          types.String `tfsdk:"oidc_token"`
+	Audience           types.String `tfsdk:"audience"`
+	Token              types.String `tfsdk:"token"`
+	ExpiresAt          types.String `tfsdk:"expires_at"`
+}
+
+// tokenPrivateData is what Renew and Close need to know about an open token,
+// as they are not given its configuration.
+type tokenPrivateData struct {
+	TokenEndpoint      string `json:"token_endpoint"`
+	RevocationEndpoint string `json:"revocation_endpoint,omitempty"`
+	AccessToken        string `json:"access_token"`
+	RefreshToken       string `json:"refresh_token,omitempty"`
+}
+
+// tokenResponse is the successful response of a token endpoint (RFC 6749).
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *tokenEphemeralResource) Metadata(ctx context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_token"
+}
+
+func (r *tokenEphemeralResource) Configure(ctx context.Context, req ephemeral.ConfigureRequest, resp *ephemeral.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	httpClient, ok := req.ProviderData.(*http.Client)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Ephemeral Resource Configure Type",
+			fmt.Sprintf("Expected *http.Client, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+		return
+	}
+
+	r.httpClient = httpClient
+}
+
+func (r *tokenEphemeralResource) Schema(_ context.Context, _ ephemeral.SchemaRequest, resp *ephemeral.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Use this ephemeral resource to exchange a long-lived credential, or the OIDC token of a CI job, " +
+			"for a short-lived token of your Foxops instance or of the gateway in front of it, with Terraform 1.10 or later. " +
+			"The token is never written to the state or plan files and can be passed to the `token` (or `headers`) of another configuration of the provider. " +
+			"The credential is exchanged with an OAuth 2.0 token exchange request (RFC 8693). " +
+			"The token passed to the configuration never changes, so it must outlive the Terraform run. " +
+			"When the token endpoint returns a refresh token, it is refreshed for as long as Terraform uses the token, " +
+			"only to keep the refresh token valid so that it can be revoked afterwards when a `revocation_endpoint` is set.",
+		Attributes: map[string]schema.Attribute{
+			"token_endpoint": schema.StringAttribute{
+				MarkdownDescription: "The url of the token endpoint issuing the short-lived tokens.",
+				Required:            true,
+			},
+			"revocation_endpoint": schema.StringAttribute{
+				MarkdownDescription: "The url of the endpoint revoking the tokens (RFC 7009) once Terraform does not use them anymore. " +
+					"Default: the tokens expire on their own.",
+				Optional: true,
+			},
+			"credential": schema.StringAttribute{
+				MarkdownDescription: "The long-lived credential exchanged for the token. Exactly one of `credential` and `oidc_token` must be set.",
+				Optional:            true,
+				Sensitive:           true,
+				Validators: []validator.String{
+					stringvalidator.ExactlyOneOf(path.MatchRoot("oidc_token")),
+				},
+			},
+			"oidc_token": schema.StringAttribute{
+				MarkdownDescription: "The OIDC token exchanged for the token, like the ID token of a GitLab CI job or of a GitHub Actions workflow.",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"audience": schema.StringAttribute{
+				MarkdownDescription: "The audience of the token, sent to the token endpoint when set.",
+				Optional:            true,
+			},
+			"token": schema.StringAttribute{
+				MarkdownDescription: "The short-lived token.",
+				Computed:            true,
+				Sensitive:           true,
+			},
+			"expires_at": schema.StringAttribute{
+				MarkdownDescription: "When the token expires, in RFC 3339 format. Refreshing does not extend it. Null when the token endpoint does not tell.",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (r *tokenEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_token.Open")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data tokenEphemeralResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	form := url.Values{"grant_type": {tokenExchangeGrantType}}
+	if !data.Credential.IsNull() {
+		form.Set("subject_token", data.Credential.ValueString())
+		form.Set("subject_token_type", accessTokenType)
+	} else {
+		form.Set("subject_token", data.OIDCToken.ValueString())
+		form.Set("subject_token_type", idTokenType)
+	}
+	if !data.Audience.IsNull() {
+		form.Set("audience", data.Audience.ValueString())
+	}
+
+	issuedAt := time.Now()
+	token, err := r.requestToken(ctx, data.TokenEndpoint.ValueString(), form)
+	if err != nil {
+		resp.Diagnostics.AddError("Unable to exchange the credential for a token", err.Error())
+		return
+	}
+
+	data.Token = types.StringValue(token.AccessToken)
+	data.ExpiresAt = types.StringNull()
+	if token.ExpiresIn > 0 {
+		data.ExpiresAt = types.StringValue(issuedAt.Add(time.Duration(token.ExpiresIn) * time.Second).UTC().Format(time.RFC3339))
+	}
+	resp.Diagnostics.Append(resp.Result.Set(ctx, data)...)
+
+	resp.RenewAt = tokenRenewAt(issuedAt, token)
+	resp.Diagnostics.Append(setTokenPrivateData(ctx, resp.Private, tokenPrivateData{
+		TokenEndpoint:      data.TokenEndpoint.ValueString(),
+		RevocationEndpoint: data.RevocationEndpoint.ValueString(),
+		AccessToken:        token.AccessToken,
+		RefreshToken:       token.RefreshToken,
+	})...)
+}
+
+func (r *tokenEphemeralResource) Renew(ctx context.Context, req ephemeral.RenewRequest, resp *ephemeral.RenewResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_token.Renew")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	private, diags := getTokenPrivateData(ctx, req.Private)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() || private.RefreshToken == "" {
+		return
+	}
+
+	issuedAt := time.Now()
+	token, err := r.requestToken(ctx, private.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {private.RefreshToken},
+	})
+	if err != nil {
+		resp.Diagnostics.AddError("Unable to refresh the token", err.Error())
+		return
+	}
+
+	// The token endpoint may rotate the refresh token, which is then used by
+	// the next renewal. The token passed to the configuration cannot change:
+	// refreshing only keeps the refresh token valid for its revocation.
+	if token.RefreshToken == "" {
+		token.RefreshToken = private.RefreshToken
+	}
+	if token.AccessToken != "" {
+		private.AccessToken = token.AccessToken
+	}
+	private.RefreshToken = token.RefreshToken
+
+	resp.RenewAt = tokenRenewAt(issuedAt, token)
+	resp.Diagnostics.Append(setTokenPrivateData(ctx, resp.Private, private)...)
+}
+
+func (r *tokenEphemeralResource) Close(ctx context.Context, req ephemeral.CloseRequest, resp *ephemeral.CloseResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_token.Close")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	private, diags := getTokenPrivateData(ctx, req.Private)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() || private.RevocationEndpoint == "" {
+		return
+	}
+
+	// Revoking the refresh token also revokes the access tokens issued with
+	// it (RFC 7009, section 2.1).
+	form := url.Values{"token": {private.AccessToken}, "token_type_hint": {"access_token"}}
+	if private.RefreshToken != "" {
+		form = url.Values{"token": {private.RefreshToken}, "token_type_hint": {"refresh_token"}}
+	}
+	if _, err := r.postForm(ctx, private.RevocationEndpoint, form); err != nil {
+		// The token expires anyway, there is nothing to do about it.
+		resp.Diagnostics.AddWarning("Unable to revoke the token", err.Error())
+		return
+	}
+	tflog.Debug(ctx, "token revoked")
+}
+
+// requestToken sends a request to a token endpoint and decodes its response.
+func (r *tokenEphemeralResource) requestToken(ctx context.Context, endpoint string, form url.Values) (tokenResponse, error) {
+	var token tokenResponse
+	body, err := r.postForm(ctx, endpoint, form)
+	if err != nil {
+		return token, err
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return token, fmt.Errorf("invalid response from %s: %w", endpoint, err)
+	}
+	if token.AccessToken == "" && form.Get("grant_type") == tokenExchangeGrantType {
+		return token, fmt.Errorf("the response from %s holds no access_token", endpoint)
+	}
+	return token, nil
+}
+
+// postForm sends a form to an OAuth 2.0 endpoint and returns the body of its
+// response. The error responses hold no secret and are returned as errors.
+func (r *tokenEphemeralResource) postForm(ctx context.Context, endpoint string, form url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := r.httpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > 1024 {
+			body = body[:1024]
+		}
+		return nil, fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, req.URL.Redacted(), strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// tokenRenewAt returns when a token is refreshed, once three quarters of its
+// lifetime have passed. Tokens without a refresh token are not renewed.
+func tokenRenewAt(issuedAt time.Time, token tokenResponse) time.Time {
+	if token.RefreshToken == "" || token.ExpiresIn <= 0 {
+		return time.Time{}
+	}
+	return issuedAt.Add(time.Duration(token.ExpiresIn) * time.Second * 3 / 4)
+}
+
+func getTokenPrivateData(ctx context.Context, private privateState) (tokenPrivateData, diag.Diagnostics) {
+	var data tokenPrivateData
+	content, diags := private.GetKey(ctx, tokenPrivateKey)
+	if diags.HasError() || content == nil {
+		return data, diags
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		diags.AddError("Invalid private data of the token", err.Error())
+	}
+	return data, diags
+}
+
+func setTokenPrivateData(ctx context.Context, private privateState, data tokenPrivateData) diag.Diagnostics {
+	content, err := json.Marshal(data)
+	if err != nil {
+		var diags diag.Diagnostics
+		diags.AddError("Unable to store the private data of the token", err.Error())
+		return diags
+	}
+	return private.SetKey(ctx, tokenPrivateKey, content)
+}
+
+// missingTokenClient is the FoxopsClient of a provider configured without a
+// token with ephemeral_only, which is only used to open foxops_token ephemeral
+// resources.
+// Every call fails with ErrMissingToken.
+type missingTokenClient struct{}
+
+var _ FoxopsClient = missingTokenClient{}
+
+// ErrMissingToken is returned by the calls of a provider configured without a
+// token.
+var ErrMissingToken = fmt.Errorf(
+	"the provider cannot call the Foxops API as there is a missing configuration value for the API token, "+
+		"set the token value in the configuration or use the %s environment variable",
+	token_env_var,
+)
+
+func (missingTokenClient) ListIncarnations(context.Context, ListIncarnationsRequest) ([]IncarnationBasic, error) {
+	return nil, ErrMissingToken
+}
+
+func (missingTokenClient) GetIncarnation(context.Context, IncarnationId) (Incarnation, error) {
+	return Incarnation{}, ErrMissingToken
+}
+
+func (missingTokenClient) GetIncarnationWithMergeRequestStatus(context.Context, IncarnationId, string) (Incarnation, error) {
+	return Incarnation{}, ErrMissingToken
+}
+
+func (missingTokenClient) CreateIncarnation(context.Context, CreateIncarnationRequest) (Incarnation, error) {
+	return Incarnation{}, ErrMissingToken
+}
+
+func (missingTokenClient) UpdateIncarnation(context.Context, IncarnationId, UpdateIncarnationRequest) (Incarnation, error) {
+	return Incarnation{}, ErrMissingToken
+}
+
+func (missingTokenClient) DeleteIncarnation(context.Context, IncarnationId) error {
+	return ErrMissingToken
+}
+
+func (missingTokenClient) ResetIncarnation(context.Context, IncarnationId, ResetIncarnationRequest) (ResetIncarnationResult, error) {
+	return ResetIncarnationResult{}, ErrMissingToken
+}
@@ -0,0 +1,255 @@
+package provider_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	mock_provider "github.com/Roche/terraform-provider-foxops/internal/provider/mocks"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+// tokenServer is a stand-in for a token endpoint exchanging the credentials
+// for short-lived tokens.
+type tokenServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	forms     []map[string]string
+	revoked   []string
+	refreshed chan struct{}
+}
+
+func newTokenServer(t *testing.T, expiresIn int) *tokenServer {
+	s := &tokenServer{refreshed: make(chan struct{})}
+	refreshOnce := sync.Once{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		s.mu.Lock()
+		form := map[string]string{}
+		for key := range r.PostForm {
+			form[key] = r.PostForm.Get(key)
+		}
+		s.forms = append(s.forms, form)
+		s.mu.Unlock()
+
+		switch form["grant_type"] {
+		case "urn:ietf:params:oauth:grant-type:token-exchange":
+			if form["subject_token"] != "job-token" && form["subject_token"] != "long-lived" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error": "invalid_grant"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token": "short-lived", "token_type": "Bearer", "expires_in": %d, "refresh_token": "refresh-1"}`, expiresIn)
+		case "refresh_token":
+			refreshOnce.Do(func() { close(s.refreshed) })
+			fmt.Fprintf(w, `{"access_token": "short-lived-2", "token_type": "Bearer", "expires_in": %d, "refresh_token": "refresh-2"}`, expiresIn)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": "unsupported_grant_type"}`)
+		}
+	})
+	mux.HandleFunc("/revoke", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		s.mu.Lock()
+		s.revoked = append(s.revoked, r.PostForm.Get("token"))
+		s.mu.Unlock()
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newTokenTestProviderFactories returns a test provider recording the tokens
+// it is configured with.
+func newTokenTestProviderFactories(client *mock_provider.MockFoxopsClient, tokens *[]provider.ClientToken) map[string]func() (tfprotov6.ProviderServer, error) {
+	var mu sync.Mutex
+	return map[string]func() (tfprotov6.ProviderServer, error){
+		"foxops": providerserver.NewProtocol6WithError(
+			provider.New(
+				"test",
+				func(_ provider.ClientEndpoint, token provider.ClientToken, _ provider.Version, _ provider.ClientConfig) provider.FoxopsClient {
+					mu.Lock()
+					defer mu.Unlock()
+					*tokens = append(*tokens, token)
+					return client
+				},
+				[]func() datasource.DataSource{provider.NewIncarnationDataSource},
+				nil,
+				nil,
+				[]func() ephemeral.EphemeralResource{provider.NewTokenEphemeralResource},
+				nil,
+			)(),
+		),
+	}
+}
+
+func tokenTestConfig(server *tokenServer, credential string) string {
+	return fmt.Sprintf(`
+provider "foxops" {
+  alias          = "exchange"
+  endpoint       = "http://localhost:9876"
+  ephemeral_only = true
+}
+
+ephemeral "foxops_token" "test" {
+  provider            = foxops.exchange
+  token_endpoint      = "%[1]s/token"
+  revocation_endpoint = "%[1]s/revoke"
+  %[2]s
+  audience            = "foxops"
+}
+
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = ephemeral.foxops_token.test.token
+}
+
+data "foxops_incarnation" "test" {
+  id = "1234"
+}
+`, server.URL, credential)
+}
+
+func TestAccTokenEphemeralResource_ShouldConfigureTheProvider(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+	server := newTokenServer(t, 2)
+
+	// The reads last until the token is refreshed, which happens while the
+	// provider using it is configured.
+	client.EXPECT().
+		GetIncarnation(gomock.Any(), provider.IncarnationId("1234")).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			select {
+			case <-server.refreshed:
+			case <-time.After(10 * time.Second):
+			}
+			return provider.Incarnation{
+				Id:                    "1234",
+				IncarnationRepository: "inc/repo",
+				TargetDirectory:       ".",
+			}, nil
+		}).
+		AnyTimes()
+
+	var tokens []provider.ClientToken
+	resource.Test(t, resource.TestCase{
+		IsUnitTest: true,
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_10_0),
+		},
+		ProtoV6ProviderFactories: newTokenTestProviderFactories(client, &tokens),
+		Steps: []resource.TestStep{
+			{
+				Config: tokenTestConfig(server, `oidc_token = "job-token"`),
+				Check:  resource.TestCheckResourceAttr("data.foxops_incarnation.test", "incarnation_repository", "inc/repo"),
+			},
+		},
+	})
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	assert.NotEmpty(t, tokens)
+	for _, token := range tokens {
+		assert.Equal(t, provider.ClientToken("short-lived"), token)
+	}
+	assert.Equal(t, map[string]string{
+		"grant_type":         "urn:ietf:params:oauth:grant-type:token-exchange",
+		"subject_token":      "job-token",
+		"subject_token_type": "urn:ietf:params:oauth:token-type:id_token",
+		"audience":           "foxops",
+	}, server.forms[0])
+	assert.Contains(t, server.forms, map[string]string{"grant_type": "refresh_token", "refresh_token": "refresh-1"})
+
+	// Every token opened is revoked once Terraform is done with it.
+	exchanges := 0
+	for _, form := range server.forms {
+		if form["grant_type"] != "refresh_token" {
+			exchanges++
+		}
+	}
+	assert.Len(t, server.revoked, exchanges)
+}
+
+func TestAccTokenEphemeralResource_RejectedCredentialShouldFail(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+	server := newTokenServer(t, 300)
+
+	var tokens []provider.ClientToken
+	resource.Test(t, resource.TestCase{
+		IsUnitTest: true,
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_10_0),
+		},
+		ProtoV6ProviderFactories: newTokenTestProviderFactories(client, &tokens),
+		Steps: []resource.TestStep{
+			{
+				Config:      tokenTestConfig(server, `credential = "expired"`),
+				ExpectError: regexp.MustCompile(`(?s)Unable\s+to\s+exchange\s+the\s+credential.*unexpected\s+status\s+code\s+401.*invalid_grant`),
+			},
+			{
+				Config:      tokenTestConfig(server, `credential = "long-lived"`+"\n"+`  oidc_token = "job-token"`),
+				ExpectError: regexp.MustCompile(`(?s)Invalid\s+Attribute\s+Combination`),
+			},
+		},
+	})
+	assert.Empty(t, tokens)
+}
+
+func TestAccProvider_MissingTokenShouldFailUnlessEphemeralOnly(t *testing.T) {
+	t.Setenv("FOXOPS_TOKEN", "")
+	ctrl := gomock.NewController(t)
+	client := mock_provider.NewMockFoxopsClient(ctrl)
+
+	var tokens []provider.ClientToken
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: newTokenTestProviderFactories(client, &tokens),
+		Steps: []resource.TestStep{
+			{
+				Config: `
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+}
+
+data "foxops_incarnation" "test" {
+  id = "1234"
+}
+`,
+				ExpectError: regexp.MustCompile(`Missing Foxops API token`),
+			},
+			{
+				Config: `
+provider "foxops" {
+  endpoint       = "http://localhost:9876"
+  ephemeral_only = true
+}
+
+data "foxops_incarnation" "test" {
+  id = "1234"
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)missing\s+configuration\s+value\s+for\s+the\s+API\s+token`),
+			},
+		},
+	})
+	assert.Empty(t, tokens)
+}
@@ -0,0 +1,428 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const (
+	gitlabTokenEnvVar = "GITLAB_TOKEN"
+	githubTokenEnvVar = "GITHUB_TOKEN"
+
+	defaultGitlabBaseURL = "https://gitlab.com"
+	defaultGithubBaseURL = "https://api.github.com"
+
+	defaultPipelineTimeout = 30 * time.Minute
+)
+
+// pipelinePollInterval is the delay between two reads of the status of a
+// pipeline.
+var pipelinePollInterval = 10 * time.Second
+
+// Pipeline states, as reported by every forge.
+const (
+	pipelinePending = "pending"
+	pipelineSuccess = "success"
+	pipelineFailed  = "failed"
+	// Nothing is reported for the commit yet, which is waited for like a
+	// pending pipeline.
+	pipelineMissing = "missing"
+)
+
+// pipelineStatus is the aggregated status of the pipelines of a commit.
+type pipelineStatus struct {
+	State string
+	// Url points at the failed pipeline, when known.
+	Url string
+}
+
+// forgeClient reads the pipeline statuses of the commits of a forge, like
+// GitLab or GitHub. The forge hosting an incarnation repository is told by
+// the url of the merge requests Foxops opens.
+type forgeClient interface {
+	// Handles tells whether the merge request belongs to the forge.
+	Handles(mergeRequestUrl string) bool
+	// PipelineStatus returns the status of the pipelines of a commit of the
+	// repository of the merge request.
+	PipelineStatus(ctx context.Context, mergeRequestUrl string, commitSha string) (pipelineStatus, error)
+}
+
+// forgeModel holds the values of the provider `gitlab` and `github` blocks.
+type forgeModel struct {
+	BaseURL types.String `tfsdk:"base_url"`
+	Token   types.String `tfsdk:"token"`
+}
+
+func forgeSchema(name string, baseURLDescription string, baseURL string, tokenEnvVar string) schema.SingleNestedAttribute {
+	return schema.SingleNestedAttribute{
+		MarkdownDescription: fmt.Sprintf("The %s instance hosting the incarnation repositories. ", name) +
+			"It is used to wait for the pipelines of the incarnations setting `wait_for_pipeline`.",
+		Optional: true,
+		Attributes: map[string]schema.Attribute{
+			"base_url": schema.StringAttribute{
+				MarkdownDescription: fmt.Sprintf("The base url of the %s. Default: `%s`.", baseURLDescription, baseURL),
+				Optional:            true,
+			},
+			"token": schema.StringAttribute{
+				MarkdownDescription: fmt.Sprintf("The token used to read the commit statuses. Default: the `%s` environment variable.", tokenEnvVar),
+				Optional:            true,
+				Sensitive:           true,
+			},
+		},
+	}
+}
+
+// newForgeClients returns the clients of the forges configured in the
+// provider.
+func newForgeClients(version Version, gitlab *forgeModel, github *forgeModel) ([]forgeClient, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var forges []forgeClient
+
+	httpClient := &http.Client{
+		Transport: helpers.NewTransport(string(version), http.DefaultTransport),
+		Timeout:   30 * time.Second,
+	}
+
+	if gitlab != nil {
+		baseURL, token, d := forgeSettings(path.Root("gitlab"), gitlab, defaultGitlabBaseURL, gitlabTokenEnvVar)
+		diags.Append(d...)
+		forges = append(forges, &gitlabClient{httpClient: httpClient, baseURL: baseURL, token: token})
+	}
+	if github != nil {
+		baseURL, token, d := forgeSettings(path.Root("github"), github, defaultGithubBaseURL, githubTokenEnvVar)
+		diags.Append(d...)
+		forges = append(forges, &githubClient{httpClient: httpClient, baseURL: baseURL, token: token})
+	}
+
+	return forges, diags
+}
+
+func forgeSettings(attribute path.Path, data *forgeModel, defaultBaseURL string, tokenEnvVar string) (*url.URL, string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	rawBaseURL := defaultBaseURL
+	if !data.BaseURL.IsNull() {
+		rawBaseURL = data.BaseURL.ValueString()
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(rawBaseURL, "/"))
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		diags.AddAttributeError(attribute.AtName("base_url"), "Invalid forge base url", fmt.Sprintf("%q is not an absolute url.", rawBaseURL))
+	}
+
+	token := os.Getenv(tokenEnvVar)
+	if !data.Token.IsNull() {
+		token = data.Token.ValueString()
+	}
+
+	return baseURL, token, diags
+}
+
+// forgeFor returns the forge hosting the repository of the merge request.
+func forgeFor(forges []forgeClient, mergeRequestUrl string) forgeClient {
+	for _, forge := range forges {
+		if forge.Handles(mergeRequestUrl) {
+			return forge
+		}
+	}
+	return nil
+}
+
+// waitForPipeline waits until the pipelines of the commit of an incarnation
+// succeed or fail.
+func waitForPipeline(
+	ctx context.Context,
+	forges []forgeClient,
+	inc Incarnation,
+	timeout time.Duration,
+) (diags diag.Diagnostics) {
+	if inc.MergeRequestUrl == nil || inc.CommitSha == "" {
+		tflog.Info(
+			ctx,
+			"No merge request in progress",
+			map[string]interface{}{
+				"id":      inc.Id,
+				"details": "Since no merge request was initiated for the incarnation, it was not possible to wait for its pipeline.",
+			},
+		)
+		return
+	}
+
+	mergeRequestUrl := *inc.MergeRequestUrl
+	forge := forgeFor(forges, mergeRequestUrl)
+	if forge == nil {
+		diags.AddAttributeError(
+			path.Root("wait_for_pipeline"),
+			"Unable to wait for the pipeline",
+			fmt.Sprintf("No forge of the provider hosts the merge request %s. Configure the `gitlab` or `github` block of the provider.", mergeRequestUrl),
+		)
+		return
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	reported := false
+	for {
+		status, err := forge.PipelineStatus(timeoutCtx, mergeRequestUrl, inc.CommitSha)
+		if err != nil && timeoutCtx.Err() == nil {
+			diags.AddError("Unable to read the pipeline status", err.Error())
+			return
+		}
+
+		switch status.State {
+		case pipelineSuccess:
+			return
+		case pipelineFailed:
+			detail := fmt.Sprintf("The pipeline of the commit %s of the merge request %s failed.", inc.CommitSha, mergeRequestUrl)
+			if status.Url != "" {
+				detail += fmt.Sprintf(" See %s.", status.Url)
+			}
+			diags.AddError("Pipeline failed", detail)
+			return
+		}
+
+		reported = reported || status.State != pipelineMissing
+		tflog.Info(
+			ctx,
+			"waiting for the pipeline to succeed or fail",
+			map[string]interface{}{
+				"id":            inc.Id,
+				"merge_request": mergeRequestUrl,
+				"commit_sha":    inc.CommitSha,
+			},
+		)
+
+		select {
+		case <-timeoutCtx.Done():
+			detail := fmt.Sprintf("The pipeline of the commit %s of the merge request %s is still running.", inc.CommitSha, mergeRequestUrl)
+			if !reported {
+				detail = fmt.Sprintf("No pipeline, commit status or check run was reported for the commit %s of the merge request %s.", inc.CommitSha, mergeRequestUrl)
+			}
+			diags.AddError("operation timed out before the pipeline succeeded or failed", detail)
+			return
+		case <-time.After(pipelinePollInterval):
+		}
+	}
+}
+
+// getForgeJSON sends an authenticated GET request to a forge API and decodes
+// its JSON response.
+func getForgeJSON(ctx context.Context, httpClient *http.Client, endpoint string, header string, token string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set(header, token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, req.URL.Redacted(), strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// repositoryPath returns the path of the merge request url relative to the
+// base url of a forge, for the forges served under a sub-path.
+func repositoryPath(baseURL *url.URL, mergeRequestUrl *url.URL) string {
+	p := mergeRequestUrl.Path
+	if mergeRequestUrl.Host == baseURL.Host {
+		p = strings.TrimPrefix(p, baseURL.Path)
+	}
+	return strings.Trim(p, "/")
+}
+
+type gitlabClient struct {
+	httpClient *http.Client
+	baseURL    *url.URL
+	token      string
+}
+
+var _ forgeClient = (*gitlabClient)(nil)
+
+// gitlabProject returns the path of the project of a GitLab merge request
+// url, like `https://gitlab.com/group/project/-/merge_requests/1`.
+func (c *gitlabClient) gitlabProject(mergeRequestUrl string) (string, bool) {
+	u, err := url.Parse(mergeRequestUrl)
+	if err != nil || !strings.EqualFold(u.Host, c.baseURL.Host) {
+		return "", false
+	}
+	project, _, ok := strings.Cut(repositoryPath(c.baseURL, u), "/-/merge_requests/")
+	return project, ok && project != ""
+}
+
+func (c *gitlabClient) Handles(mergeRequestUrl string) bool {
+	_, ok := c.gitlabProject(mergeRequestUrl)
+	return ok
+}
+
+// PipelineStatus aggregates the statuses of the jobs of the commit: it fails
+// as soon as a job which is not allowed to fail failed or was canceled, and
+// succeeds once every job succeeded, was skipped or waits for a manual action.
+func (c *gitlabClient) PipelineStatus(ctx context.Context, mergeRequestUrl string, commitSha string) (pipelineStatus, error) {
+	project, ok := c.gitlabProject(mergeRequestUrl)
+	if !ok {
+		return pipelineStatus{}, fmt.Errorf("%s is not a GitLab merge request url", mergeRequestUrl)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v4/projects/%s/repository/commits/%s/statuses",
+		c.baseURL.String(), url.QueryEscape(project), url.PathEscape(commitSha))
+
+	var statuses []struct {
+		Status       string `json:"status"`
+		AllowFailure bool   `json:"allow_failure"`
+		TargetUrl    string `json:"target_url"`
+	}
+	if err := getForgeJSON(ctx, c.httpClient, endpoint, "PRIVATE-TOKEN", c.token, &statuses); err != nil {
+		return pipelineStatus{}, err
+	}
+
+	if len(statuses) == 0 {
+		// The pipeline is not created yet.
+		return pipelineStatus{State: pipelineMissing}, nil
+	}
+
+	result := pipelineStatus{State: pipelineSuccess}
+	for _, status := range statuses {
+		switch status.Status {
+		case "failed", "canceled":
+			if status.AllowFailure {
+				continue
+			}
+			return pipelineStatus{State: pipelineFailed, Url: status.TargetUrl}, nil
+		case "success", "skipped", "manual":
+		default:
+			result.State = pipelinePending
+		}
+	}
+	return result, nil
+}
+
+type githubClient struct {
+	httpClient *http.Client
+	baseURL    *url.URL
+	token      string
+}
+
+var _ forgeClient = (*githubClient)(nil)
+
+// githubRepository returns the owner and the name of the repository of a
+// GitHub pull request url, like `https://github.com/owner/repo/pull/1`.
+func (c *githubClient) githubRepository(mergeRequestUrl string) (string, string, bool) {
+	u, err := url.Parse(mergeRequestUrl)
+	if err != nil || !strings.EqualFold(u.Host, c.webHost()) {
+		return "", "", false
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 4 || segments[len(segments)-2] != "pull" {
+		return "", "", false
+	}
+	return segments[len(segments)-4], segments[len(segments)-3], true
+}
+
+// webHost returns the host of the pull request urls. GitHub serves its API on
+// api.github.com, while GitHub Enterprise Server serves it under /api/v3 of
+// the host of the pull requests.
+func (c *githubClient) webHost() string {
+	if strings.EqualFold(c.baseURL.Host, "api.github.com") {
+		return "github.com"
+	}
+	return c.baseURL.Host
+}
+
+func (c *githubClient) Handles(mergeRequestUrl string) bool {
+	_, _, ok := c.githubRepository(mergeRequestUrl)
+	return ok
+}
+
+// PipelineStatus combines the commit statuses of the commit, reported by
+// external services, with its check runs, reported by GitHub Actions and
+// GitHub Apps: it fails as soon as one of them failed, and succeeds once every
+// one of them succeeded.
+func (c *githubClient) PipelineStatus(ctx context.Context, mergeRequestUrl string, commitSha string) (pipelineStatus, error) {
+	owner, repo, ok := c.githubRepository(mergeRequestUrl)
+	if !ok {
+		return pipelineStatus{}, fmt.Errorf("%s is not a GitHub pull request url", mergeRequestUrl)
+	}
+	commit := fmt.Sprintf("%s/repos/%s/%s/commits/%s",
+		c.baseURL.String(), url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(commitSha))
+	token := c.token
+	if token != "" {
+		token = "Bearer " + token
+	}
+
+	var combined struct {
+		State    string `json:"state"`
+		Statuses []struct {
+			State     string `json:"state"`
+			TargetUrl string `json:"target_url"`
+		} `json:"statuses"`
+	}
+	if err := getForgeJSON(ctx, c.httpClient, commit+"/status", "Authorization", token, &combined); err != nil {
+		return pipelineStatus{}, err
+	}
+
+	var checks struct {
+		CheckRuns []struct {
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+			HtmlUrl    string `json:"html_url"`
+		} `json:"check_runs"`
+	}
+	if err := getForgeJSON(ctx, c.httpClient, commit+"/check-runs?per_page=100", "Authorization", token, &checks); err != nil {
+		return pipelineStatus{}, err
+	}
+
+	if len(combined.Statuses) == 0 && len(checks.CheckRuns) == 0 {
+		return pipelineStatus{State: pipelineMissing}, nil
+	}
+
+	result := pipelineStatus{State: pipelineSuccess}
+	switch combined.State {
+	case "failure", "error":
+		for _, status := range combined.Statuses {
+			if status.State == "failure" || status.State == "error" {
+				return pipelineStatus{State: pipelineFailed, Url: status.TargetUrl}, nil
+			}
+		}
+		return pipelineStatus{State: pipelineFailed}, nil
+	case "success":
+	default:
+		// The combined status is pending without any status as well.
+		if len(combined.Statuses) > 0 {
+			result.State = pipelinePending
+		}
+	}
+	for _, run := range checks.CheckRuns {
+		switch {
+		case run.Status != "completed":
+			result.State = pipelinePending
+		case run.Conclusion == "success", run.Conclusion == "neutral", run.Conclusion == "skipped":
+		default:
+			return pipelineStatus{State: pipelineFailed, Url: run.HtmlUrl}, nil
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,208 @@
+package provider_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccIncarnationResource_UpdateWithWaitForPipelineShouldReportThePipelineStatus(t *testing.T) {
+	tests := map[string]struct {
+		forge           string
+		mergeRequestURL string
+		mergeRequest    string
+		// responses are the bodies returned by the forge, by escaped path.
+		responses       map[string]string
+		expectedHeader  [2]string
+		expectedFailure *regexp.Regexp
+	}{
+		"gitlab success": {
+			forge:        "gitlab",
+			mergeRequest: "/group/repo/-/merge_requests/2",
+			responses: map[string]string{
+				"/api/v4/projects/group%2Frepo/repository/commits/87654321/statuses": `[{"status": "success"}, {"status": "failed", "allow_failure": true}, {"status": "manual"}]`,
+			},
+			expectedHeader: [2]string{"PRIVATE-TOKEN", "secret"},
+		},
+		"gitlab failure": {
+			forge:        "gitlab",
+			mergeRequest: "/group/repo/-/merge_requests/2",
+			responses: map[string]string{
+				"/api/v4/projects/group%2Frepo/repository/commits/87654321/statuses": `[{"status": "success"}, {"status": "failed", "target_url": "https://ci/jobs/7"}]`,
+			},
+			expectedHeader:  [2]string{"PRIVATE-TOKEN", "secret"},
+			expectedFailure: regexp.MustCompile(`(?s)Pipeline failed.*See\s+https://ci/jobs/7`),
+		},
+		"github success": {
+			forge:        "github",
+			mergeRequest: "/owner/repo/pull/2",
+			responses: map[string]string{
+				"/repos/owner/repo/commits/87654321/status":     `{"state": "success", "statuses": [{"state": "success"}]}`,
+				"/repos/owner/repo/commits/87654321/check-runs": `{"total_count": 0, "check_runs": []}`,
+			},
+			expectedHeader: [2]string{"Authorization", "Bearer secret"},
+		},
+		"github success of check runs only": {
+			forge:        "github",
+			mergeRequest: "/owner/repo/pull/2",
+			responses: map[string]string{
+				// GitHub reports a pending combined status without statuses.
+				"/repos/owner/repo/commits/87654321/status":     `{"state": "pending", "statuses": []}`,
+				"/repos/owner/repo/commits/87654321/check-runs": `{"total_count": 2, "check_runs": [{"status": "completed", "conclusion": "success"}, {"status": "completed", "conclusion": "skipped"}]}`,
+			},
+			expectedHeader: [2]string{"Authorization", "Bearer secret"},
+		},
+		"gitlab merge request of another host": {
+			forge:           "gitlab",
+			mergeRequestURL: "https://gitlab.other.example.com",
+			mergeRequest:    "/group/repo/-/merge_requests/2",
+			expectedFailure: regexp.MustCompile(`(?s)Unable to wait for the pipeline.*No forge of the provider hosts the merge request`),
+		},
+		"github failure": {
+			forge:        "github",
+			mergeRequest: "/owner/repo/pull/2",
+			responses: map[string]string{
+				"/repos/owner/repo/commits/87654321/status":     `{"state": "failure", "statuses": [{"state": "success"}, {"state": "error", "target_url": "https://ci/runs/7"}]}`,
+				"/repos/owner/repo/commits/87654321/check-runs": `{"total_count": 0, "check_runs": []}`,
+			},
+			expectedHeader:  [2]string{"Authorization", "Bearer secret"},
+			expectedFailure: regexp.MustCompile(`(?s)Pipeline failed.*See\s+https://ci/runs/7`),
+		},
+		"github failure of a check run": {
+			forge:        "github",
+			mergeRequest: "/owner/repo/pull/2",
+			responses: map[string]string{
+				"/repos/owner/repo/commits/87654321/status":     `{"state": "success", "statuses": [{"state": "success"}]}`,
+				"/repos/owner/repo/commits/87654321/check-runs": `{"total_count": 2, "check_runs": [{"status": "in_progress"}, {"status": "completed", "conclusion": "failure", "html_url": "https://github/runs/8"}]}`,
+			},
+			expectedHeader:  [2]string{"Authorization", "Bearer secret"},
+			expectedFailure: regexp.MustCompile(`(?s)Pipeline failed.*See\s+https://github/runs/8`),
+		},
+		"github without statuses nor check runs": {
+			forge:        "github",
+			mergeRequest: "/owner/repo/pull/2",
+			responses: map[string]string{
+				"/repos/owner/repo/commits/87654321/status":     `{"state": "pending", "statuses": []}`,
+				"/repos/owner/repo/commits/87654321/check-runs": `{"total_count": 0, "check_runs": []}`,
+			},
+			expectedHeader:  [2]string{"Authorization", "Bearer secret"},
+			expectedFailure: regexp.MustCompile(`(?s)timed out.*No pipeline, commit status or check run was reported`),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			forge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				response, ok := tt.responses[r.URL.EscapedPath()]
+				assert.True(t, ok, "unexpected request to %s", r.URL.EscapedPath())
+				assert.Equal(t, tt.expectedHeader[1], r.Header.Get(tt.expectedHeader[0]))
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, response)
+			}))
+			defer forge.Close()
+			mergeRequestURL := tt.mergeRequestURL
+			if mergeRequestURL == "" {
+				mergeRequestURL = forge.URL
+			}
+
+			setup := newTestProviderSetup(t)
+			setup.expectNoExistingIncarnations()
+
+			current := &provider.Incarnation{
+				Id:                        provider.IncarnationId("1234"),
+				IncarnationRepository:     "inc/repo",
+				TemplateRepository:        "template/repo",
+				TemplateRepositoryVersion: "v1.0.0",
+				TargetDirectory:           ".",
+				CommitSha:                 "12345678",
+				CommitUrl:                 "template/repo/commit",
+				TemplateData:              map[string]interface{}{},
+			}
+
+			setup.client.EXPECT().
+				CreateIncarnation(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+					return *current, nil
+				})
+
+			setup.client.EXPECT().
+				UpdateIncarnation(gomock.Any(), current.Id, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+					current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+					current.CommitSha = "87654321"
+					current.MergeRequestId = helpers.Addr("2")
+					current.MergeRequestStatus = helpers.Addr("merged")
+					current.MergeRequestUrl = helpers.Addr(mergeRequestURL + tt.mergeRequest)
+					return *current, nil
+				})
+
+			setup.client.EXPECT().
+				GetIncarnation(gomock.Any(), current.Id).
+				DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+					return *current, nil
+				}).
+				AnyTimes()
+
+			setup.client.EXPECT().
+				DeleteIncarnation(gomock.Any(), current.Id).
+				Return(nil)
+
+			config := func(version string) string {
+				return fmt.Sprintf(`
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  %s = {
+    base_url = %q
+    token    = "secret"
+  }
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = %q
+
+  wait_for_pipeline = {
+    timeout = "10s"
+  }
+}
+`, tt.forge, forge.URL, version)
+			}
+
+			resource.Test(t, resource.TestCase{
+				IsUnitTest:               true,
+				ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+				Steps: []resource.TestStep{
+					{
+						Config: config("v1.0.0"),
+					},
+					{
+						Config:      config("v2.0.0"),
+						ExpectError: tt.expectedFailure,
+						Check: resource.ComposeTestCheckFunc(
+							resource.TestCheckResourceAttr("foxops_incarnation.test", "commit_sha", "87654321"),
+						),
+					},
+					{
+						Config: config("v2.0.0"),
+						Check: resource.ComposeTestCheckFunc(
+							resource.TestCheckResourceAttr("foxops_incarnation.test", "template_repository_version", "v2.0.0"),
+						),
+					},
+				},
+			})
+		})
+	}
+}
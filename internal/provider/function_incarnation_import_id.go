@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+)
+
+type incarnationImportIdFunction struct{}
+
+var _ function.Function = (*incarnationImportIdFunction)(nil)
+
+func NewIncarnationImportIdFunction() function.Function {
+	return &incarnationImportIdFunction{}
+}
+
+func (f *incarnationImportIdFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "incarnation_import_id"
+}
+
+func (f *incarnationImportIdFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Build the import id of an incarnation",
+		MarkdownDescription: "Returns the id importing the incarnation of a directory of an incarnation repository into a `foxops_incarnation` resource, " +
+			"like in an `import` block. The incarnation is looked up in Foxops by the import.",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "incarnation_repository",
+				MarkdownDescription: "The repository of the incarnation.",
+			},
+			function.StringParameter{
+				Name: "target_directory",
+				MarkdownDescription: "The folder of the incarnation. " +
+					"`./some-folder`, `some-folder` and `some-folder/` designate the same folder and an empty string the root of the repository.",
+			},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *incarnationImportIdFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var incarnationRepository, targetDirectory string
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &incarnationRepository, &targetDirectory))
+	if resp.Error != nil {
+		return
+	}
+
+	if strings.TrimSpace(incarnationRepository) == "" {
+		resp.Error = function.NewArgumentFuncError(0, "The incarnation repository must not be empty.")
+		return
+	}
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, incarnationImportId(incarnationRepository, targetDirectory)))
+}
+
+// incarnationImportId returns the import id of the incarnation of a slot,
+// see cutIncarnationImportId.
+func incarnationImportId(incarnationRepository string, targetDirectory string) string {
+	if targetDirectory == "" {
+		targetDirectory = "."
+	}
+	return incarnationRepository + ":" + targetDirectoryKind.normalize(targetDirectory)
+}
+
+// cutIncarnationImportId splits an import id made of an incarnation
+// repository and a target directory. The repository can hold colons, like
+// in `git@gitlab.com:group/project`, not the directory.
+func cutIncarnationImportId(id string) (string, string, bool) {
+	i := strings.LastIndex(id, ":")
+	if i <= 0 || i == len(id)-1 {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
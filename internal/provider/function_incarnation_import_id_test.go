@@ -0,0 +1,128 @@
+package provider_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+	"go.uber.org/mock/gomock"
+)
+
+func TestIncarnationImportIdFunction(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.UnitTest(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_8_0),
+		},
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+output "root" {
+  value = provider::foxops::incarnation_import_id("inc/repo", "")
+}
+
+output "folder" {
+  value = provider::foxops::incarnation_import_id("git@gitlab.com:inc/repo", "./some-folder/")
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckOutput("root", "inc/repo:."),
+					resource.TestCheckOutput("folder", "git@gitlab.com:inc/repo:some-folder"),
+				),
+			},
+			{
+				Config: `
+output "test" {
+  value = provider::foxops::incarnation_import_id("", ".")
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)must\s+not\s+be\s+empty`),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_ShouldImportByIncarnationRepositoryAndTargetDirectory(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	incarnation := provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           "some-folder",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		TemplateData: map[string]interface{}{
+			"hello": "World!",
+		},
+	}
+
+	created := false
+	setup.client.EXPECT().
+		ListIncarnations(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.ListIncarnationsRequest) ([]provider.IncarnationBasic, error) {
+			if !created || *req.IncarnationRepository != incarnation.IncarnationRepository {
+				return []provider.IncarnationBasic{}, nil
+			}
+			return []provider.IncarnationBasic{{
+				Id:                    incarnation.Id,
+				IncarnationRepository: incarnation.IncarnationRepository,
+				TargetDirectory:       incarnation.TargetDirectory,
+			}}, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			created = true
+			return incarnation, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), incarnation.Id).
+		Return(incarnation, nil).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), incarnation.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + `
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "some-folder"
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_data = {
+    hello = "World!"
+  }
+}
+`,
+			},
+			{
+				ResourceName:      "foxops_incarnation.test",
+				ImportState:       true,
+				ImportStateId:     "inc/repo:./some-folder",
+				ImportStateVerify: true,
+			},
+			{
+				ResourceName:  "foxops_incarnation.test",
+				ImportState:   true,
+				ImportStateId: "inc/repo:other-folder",
+				ExpectError:   regexp.MustCompile(`(?s)Incarnation\s+not\s+found`),
+			},
+		},
+	})
+}
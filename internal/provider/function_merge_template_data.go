@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+type mergeTemplateDataFunction struct{}
+
+var _ function.Function = (*mergeTemplateDataFunction)(nil)
+
+func NewMergeTemplateDataFunction() function.Function {
+	return &mergeTemplateDataFunction{}
+}
+
+func (f *mergeTemplateDataFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "merge_template_data"
+}
+
+func (f *mergeTemplateDataFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Merge template data",
+		MarkdownDescription: "Merges objects or maps of template data, the later arguments taking precedence. " +
+			"Unlike `merge()`, nested objects and maps are merged key by key, and the values keep their types, " +
+			"so that the result can be passed to `jsonencode()` for the `template_data_json` of an incarnation. " +
+			"Null arguments are ignored.",
+		VariadicParameter: function.DynamicParameter{
+			Name:                "template_data",
+			MarkdownDescription: "The objects or maps to merge.",
+			AllowNullValue:      true,
+		},
+		Return: function.DynamicReturn{},
+	}
+}
+
+func (f *mergeTemplateDataFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var args []types.Dynamic
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &args))
+	if resp.Error != nil {
+		return
+	}
+
+	merged := map[string]attr.Value{}
+	for i, arg := range args {
+		if arg.IsNull() || arg.IsUnderlyingValueNull() {
+			continue
+		}
+		elements, ok := templateDataElements(arg.UnderlyingValue())
+		if !ok {
+			resp.Error = function.NewArgumentFuncError(
+				int64(i),
+				fmt.Sprintf("Expected an object or a map, got: %s.", arg.UnderlyingValue().Type(ctx)),
+			)
+			return
+		}
+		merged = mergeTemplateDataElements(ctx, merged, elements)
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, types.DynamicValue(templateDataObject(ctx, merged))))
+}
+
+// templateDataElements returns the elements of an object or a map.
+func templateDataElements(value attr.Value) (map[string]attr.Value, bool) {
+	switch value := value.(type) {
+	case types.Object:
+		return value.Attributes(), true
+	case types.Map:
+		return value.Elements(), true
+	case types.Dynamic:
+		return templateDataElements(value.UnderlyingValue())
+	}
+	return nil, false
+}
+
+// mergeTemplateDataElements merges the elements into merged, merging the
+// nested objects and maps of both key by key.
+func mergeTemplateDataElements(ctx context.Context, merged map[string]attr.Value, elements map[string]attr.Value) map[string]attr.Value {
+	result := make(map[string]attr.Value, len(merged)+len(elements))
+	for key, value := range merged {
+		result[key] = value
+	}
+	for key, value := range elements {
+		previous, ok := result[key]
+		if ok && !previous.IsNull() && !value.IsNull() {
+			previousElements, previousOk := templateDataElements(previous)
+			valueElements, valueOk := templateDataElements(value)
+			if previousOk && valueOk {
+				value = templateDataObject(ctx, mergeTemplateDataElements(ctx, previousElements, valueElements))
+			}
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// templateDataObject returns an object holding the elements, which keep
+// their own types.
+func templateDataObject(ctx context.Context, elements map[string]attr.Value) types.Object {
+	attributeTypes := make(map[string]attr.Type, len(elements))
+	for key, value := range elements {
+		attributeTypes[key] = value.Type(ctx)
+	}
+	return types.ObjectValueMust(attributeTypes, elements)
+}
@@ -0,0 +1,62 @@
+package provider_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+)
+
+func TestMergeTemplateDataFunction(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.UnitTest(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_8_0),
+		},
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+locals {
+  defaults = {
+    replicas = 1
+    debug    = false
+    database = { host = "localhost", port = 5432 }
+  }
+  production = {
+    replicas = 3
+    database = { host = "db.example.com" }
+    regions  = ["eu", "us"]
+  }
+}
+
+output "test" {
+  value = jsonencode(provider::foxops::merge_template_data(local.defaults, null, local.production))
+}
+`,
+				Check: resource.TestCheckOutput(
+					"test",
+					`{"database":{"host":"db.example.com","port":5432},"debug":false,"regions":["eu","us"],"replicas":3}`,
+				),
+			},
+			{
+				Config: `
+output "test" {
+  value = jsonencode(provider::foxops::merge_template_data({ hello = "World!" }, tomap({ hello = "You!", owner = "team-a" })))
+}
+`,
+				Check: resource.TestCheckOutput("test", `{"hello":"You!","owner":"team-a"}`),
+			},
+			{
+				Config: `
+output "test" {
+  value = provider::foxops::merge_template_data({ hello = "World!" }, ["You!"])
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)Expected\s+an\s+object\s+or\s+a\s+map`),
+			},
+		},
+	})
+}
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+type parseMergeRequestUrlFunction struct{}
+
+var _ function.Function = (*parseMergeRequestUrlFunction)(nil)
+
+func NewParseMergeRequestUrlFunction() function.Function {
+	return &parseMergeRequestUrlFunction{}
+}
+
+type mergeRequestUrlModel struct {
+	Forge   types.String `tfsdk:"forge"`
+	Project types.String `tfsdk:"project"`
+	Number  types.Int64  `tfsdk:"number"`
+}
+
+var mergeRequestUrlAttributeTypes = map[string]attr.Type{
+	"forge":   types.StringType,
+	"project": types.StringType,
+	"number":  types.Int64Type,
+}
+
+func (f *parseMergeRequestUrlFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "parse_merge_request_url"
+}
+
+func (f *parseMergeRequestUrlFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Parse the url of a merge request",
+		MarkdownDescription: "Returns the `forge` (`gitlab` or `github`), the `project` and the `number` of a merge request url, " +
+			"like the `merge_request_url` of an incarnation. " +
+			"GitLab urls look like `https://gitlab.com/group/project/-/merge_requests/1` and GitHub urls like `https://github.com/owner/repo/pull/1`.",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "url",
+				MarkdownDescription: "The url of the merge request.",
+			},
+		},
+		Return: function.ObjectReturn{
+			AttributeTypes: mergeRequestUrlAttributeTypes,
+		},
+	}
+}
+
+func (f *parseMergeRequestUrlFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var mergeRequestUrl string
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &mergeRequestUrl))
+	if resp.Error != nil {
+		return
+	}
+
+	result, err := parseMergeRequestUrl(mergeRequestUrl)
+	if err != nil {
+		resp.Error = function.NewArgumentFuncError(0, err.Error())
+		return
+	}
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, result))
+}
+
+// parseMergeRequestUrl splits a GitLab merge request url or a GitHub pull
+// request url, see gitlabClient.gitlabProject and
+// githubClient.githubRepository.
+func parseMergeRequestUrl(mergeRequestUrl string) (mergeRequestUrlModel, error) {
+	u, err := url.Parse(mergeRequestUrl)
+	if err != nil || u.Host == "" {
+		return mergeRequestUrlModel{}, fmt.Errorf("%q is not a url", mergeRequestUrl)
+	}
+
+	p := strings.Trim(u.Path, "/")
+	forge := "gitlab"
+	project, number, ok := strings.Cut(p, "/-/merge_requests/")
+	if !ok {
+		segments := strings.Split(p, "/")
+		if len(segments) == 4 && segments[2] == "pull" {
+			forge = "github"
+			project, number, ok = segments[0]+"/"+segments[1], segments[3], true
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if !ok || project == "" || err != nil {
+		return mergeRequestUrlModel{}, fmt.Errorf("%q is neither a GitLab merge request url nor a GitHub pull request url", mergeRequestUrl)
+	}
+	return mergeRequestUrlModel{
+		Forge:   types.StringValue(forge),
+		Project: types.StringValue(project),
+		Number:  types.Int64Value(n),
+	}, nil
+}
@@ -0,0 +1,63 @@
+package provider_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/knownvalue"
+	"github.com/hashicorp/terraform-plugin-testing/statecheck"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+)
+
+func TestParseMergeRequestUrlFunction(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.UnitTest(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_8_0),
+		},
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+output "gitlab" {
+  value = provider::foxops::parse_merge_request_url("https://gitlab.com/group/sub/project/-/merge_requests/12")
+}
+
+output "github" {
+  value = provider::foxops::parse_merge_request_url("https://github.com/owner/repo/pull/3/")
+}
+`,
+				ConfigStateChecks: []statecheck.StateCheck{
+					statecheck.ExpectKnownOutputValue("gitlab", knownvalue.ObjectExact(map[string]knownvalue.Check{
+						"forge":   knownvalue.StringExact("gitlab"),
+						"project": knownvalue.StringExact("group/sub/project"),
+						"number":  knownvalue.Int64Exact(12),
+					})),
+					statecheck.ExpectKnownOutputValue("github", knownvalue.ObjectExact(map[string]knownvalue.Check{
+						"forge":   knownvalue.StringExact("github"),
+						"project": knownvalue.StringExact("owner/repo"),
+						"number":  knownvalue.Int64Exact(3),
+					})),
+				},
+			},
+			{
+				Config: `
+output "test" {
+  value = provider::foxops::parse_merge_request_url("https://gitlab.com/group/project/-/issues/12")
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)neither\s+a\s+GitLab\s+merge\s+request\s+url`),
+			},
+			{
+				Config: `
+output "test" {
+  value = provider::foxops::parse_merge_request_url("inc/repo/mr!1234")
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)is\s+not\s+a\s+url`),
+			},
+		},
+	})
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+)
+
+type templateVersionCompareFunction struct{}
+
+var _ function.Function = (*templateVersionCompareFunction)(nil)
+
+func NewTemplateVersionCompareFunction() function.Function {
+	return &templateVersionCompareFunction{}
+}
+
+func (f *templateVersionCompareFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = "template_version_compare"
+}
+
+func (f *templateVersionCompareFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Compare two template versions",
+		MarkdownDescription: "Returns `-1` when the first version is older than the second one, `0` when they are equal and `1` when it is newer. " +
+			"The versions must be semantic versions, optionally prefixed with `v` like the tags of template repositories.",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "a",
+				MarkdownDescription: "The first version.",
+			},
+			function.StringParameter{
+				Name:                "b",
+				MarkdownDescription: "The second version.",
+			},
+		},
+		Return: function.Int64Return{},
+	}
+}
+
+func (f *templateVersionCompareFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var a, b string
+	resp.Error = function.ConcatFuncErrors(req.Arguments.Get(ctx, &a, &b))
+	if resp.Error != nil {
+		return
+	}
+
+	versions := make([]*version.Version, 2)
+	for i, value := range []string{a, b} {
+		v, err := version.NewVersion(value)
+		if err != nil {
+			resp.Error = function.ConcatFuncErrors(resp.Error, function.NewArgumentFuncError(
+				int64(i),
+				fmt.Sprintf("%q is not a semantic version.", value),
+			))
+			continue
+		}
+		versions[i] = v
+	}
+	if resp.Error != nil {
+		return
+	}
+
+	resp.Error = function.ConcatFuncErrors(resp.Result.Set(ctx, int64(versions[0].Compare(versions[1]))))
+}
@@ -0,0 +1,50 @@
+package provider_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+)
+
+func TestTemplateVersionCompareFunction(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.UnitTest(t, resource.TestCase{
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_8_0),
+		},
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+output "older" {
+  value = provider::foxops::template_version_compare("v1.2.0", "v1.10.0")
+}
+
+output "equal" {
+  value = provider::foxops::template_version_compare("v1.2.0", "1.2.0")
+}
+
+output "newer" {
+  value = provider::foxops::template_version_compare("v2.0.0", "v2.0.0-rc.1")
+}
+`,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckOutput("older", "-1"),
+					resource.TestCheckOutput("equal", "0"),
+					resource.TestCheckOutput("newer", "1"),
+				),
+			},
+			{
+				Config: `
+output "test" {
+  value = provider::foxops::template_version_compare("v1.0.0", "main")
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)"main"\s+is\s+not\s+a\s+semantic\s+version`),
+			},
+		},
+	})
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrReadOnly is returned for the changes attempted while the provider is
+	// configured with read_only.
+	ErrReadOnly = errors.New("the provider is read-only")
+	// ErrMergeRequestRequired is returned for the creations and updates which
+	// would be merged without review while the provider is configured with
+	// require_merge_request.
+	ErrMergeRequestRequired = errors.New("the provider requires changes to go through a merge request")
+)
+
+// guardClient is a FoxopsClient rejecting the changes forbidden by the
+// provider configuration before they reach Foxops.
+type guardClient struct {
+	FoxopsClient
+
+	readOnly            bool
+	requireMergeRequest bool
+}
+
+var _ FoxopsClient = (*guardClient)(nil)
+
+func (c *guardClient) unwrap() FoxopsClient {
+	return c.FoxopsClient
+}
+
+func (c *guardClient) readOnlyError(action string, id IncarnationId) error {
+	if id == "" {
+		return fmt.Errorf("%w: refusing to %s the incarnation, unset read_only to make changes", ErrReadOnly, action)
+	}
+	return fmt.Errorf("%w: refusing to %s the incarnation %s, unset read_only to make changes", ErrReadOnly, action, id)
+}
+
+// CreateIncarnation is refused by require_merge_request, as Foxops commits new
+// incarnations to the incarnation repository without a merge request.
+func (c *guardClient) CreateIncarnation(ctx context.Context, req CreateIncarnationRequest) (Incarnation, error) {
+	if c.readOnly {
+		return Incarnation{}, c.readOnlyError("create", "")
+	}
+	if c.requireMergeRequest {
+		return Incarnation{}, fmt.Errorf(
+			"%w: refusing to create an incarnation in %s, as Foxops commits new incarnations without a merge request, "+
+				"create it with a configuration of the provider without require_merge_request",
+			ErrMergeRequestRequired, req.IncarnationRepository,
+		)
+	}
+	return c.FoxopsClient.CreateIncarnation(ctx, req)
+}
+
+func (c *guardClient) UpdateIncarnation(ctx context.Context, id IncarnationId, req UpdateIncarnationRequest) (Incarnation, error) {
+	if c.readOnly {
+		return Incarnation{}, c.readOnlyError("update", id)
+	}
+	if c.requireMergeRequest && req.AutoMerge {
+		return Incarnation{}, fmt.Errorf(
+			"%w: refusing to update the incarnation %s with auto merge, set auto_merge_on_update (or auto_merge for rollouts) to false",
+			ErrMergeRequestRequired, id,
+		)
+	}
+	return c.FoxopsClient.UpdateIncarnation(ctx, id, req)
+}
+
+func (c *guardClient) DeleteIncarnation(ctx context.Context, id IncarnationId) error {
+	if c.readOnly {
+		return c.readOnlyError("delete", id)
+	}
+	return c.FoxopsClient.DeleteIncarnation(ctx, id)
+}
+
+// ResetIncarnation is allowed by require_merge_request, as Foxops always
+// opens a merge request to reset an incarnation.
+func (c *guardClient) ResetIncarnation(ctx context.Context, id IncarnationId, req ResetIncarnationRequest) (ResetIncarnationResult, error) {
+	if c.readOnly {
+		return ResetIncarnationResult{}, c.readOnlyError("reset", id)
+	}
+	return c.FoxopsClient.ResetIncarnation(ctx, id, req)
+}
@@ -0,0 +1,154 @@
+package provider_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccIncarnationResource_ReadOnlyShouldRejectChanges(t *testing.T) {
+	// No change reaches the client.
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+provider "foxops" {
+  endpoint  = "http://localhost:9876"
+  token     = "fake-token"
+  read_only = true
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+}
+`,
+				ExpectError: regexp.MustCompile(`the provider is read-only: refusing to create the incarnation`),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_RequireMergeRequestShouldRejectAutoMergedUpdates(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		TemplateData:              map[string]interface{}{},
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			return *current, nil
+		})
+
+	// Only the update going through a merge request reaches the client.
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+			AutoMerge:                 false,
+			TemplateData:              map[string]interface{}{},
+			TemplateRepositoryVersion: "v2.0.0",
+		}).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(version string, autoMerge string, requireMergeRequest bool) string {
+		return fmt.Sprintf(`
+provider "foxops" {
+  endpoint              = "http://localhost:9876"
+  token                 = "fake-token"
+  require_merge_request = %t
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = %q
+  auto_merge_on_update        = %s
+}
+`, requireMergeRequest, version, autoMerge)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				// Creations are refused, see
+				// TestAccIncarnationResource_RequireMergeRequestShouldRejectCreations.
+				Config: config("v1.0.0", "null", false),
+			},
+			{
+				Config:      config("v2.0.0", "null", true),
+				ExpectError: regexp.MustCompile(`(?s)requires changes to go through a merge request.*auto_merge_on_update`),
+			},
+			{
+				Config: config("v2.0.0", "false", true),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_RequireMergeRequestShouldRejectCreations(t *testing.T) {
+	// Foxops commits new incarnations without a merge request, no creation
+	// reaches the client.
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+provider "foxops" {
+  endpoint              = "http://localhost:9876"
+  token                 = "fake-token"
+  require_merge_request = true
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  auto_merge_on_update        = false
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)requires changes to go through a merge request: refusing to create an\s+incarnation\s+in\s+inc/repo`),
+			},
+		},
+	})
+}
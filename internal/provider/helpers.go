@@ -3,18 +3,22 @@ package provider
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
+// getIncarnation retrieves an incarnation, waiting for the status of its merge
+// request when waitForStatus is set. timedOut tells whether the merge request
+// did not reach the status in time.
 func getIncarnation(
 	ctx context.Context,
 	client FoxopsClient,
 	id IncarnationId,
 	waitForStatus *waitForStatusMRModel,
-) (inc Incarnation, diags diag.Diagnostics) {
+) (inc Incarnation, timedOut bool, diags diag.Diagnostics) {
 	var err error
 	if waitForStatus == nil {
 		tflog.Info(ctx, "fetching the incarnation", map[string]interface{}{"id": id})
@@ -59,12 +63,122 @@ func getIncarnation(
 		if err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
 				diags.AddError("operation timed out before the merge request status reached the expected status", err.Error())
+				timedOut = true
 				return
 			}
 			diags.AddError("failed to retrieve incarnation", err.Error())
 			return
 		}
+		if inc.MergeRequestId != nil && inc.MergeRequestStatus != nil && *inc.MergeRequestStatus != status {
+			diags.AddError(
+				"merge request settled before reaching the expected status",
+				fmt.Sprintf("The merge request %s of the incarnation %s is %s instead of %s.",
+					mergeRequestReference(inc), inc.Id, *inc.MergeRequestStatus, status),
+			)
+		}
 	}
 
 	return
 }
+
+// mergeRequestPollInterval is the delay between two reads of an incarnation
+// while waiting for its merge request.
+var mergeRequestPollInterval = time.Second
+
+func hasOpenMergeRequest(inc Incarnation) bool {
+	return inc.MergeRequestId != nil && inc.MergeRequestStatus != nil && *inc.MergeRequestStatus == "open"
+}
+
+// mergeRequestReference returns the url of the last merge request of an
+// incarnation, or its id when the url is not known.
+func mergeRequestReference(inc Incarnation) string {
+	if inc.MergeRequestUrl != nil {
+		return *inc.MergeRequestUrl
+	}
+	if inc.MergeRequestId != nil {
+		return *inc.MergeRequestId
+	}
+	return ""
+}
+
+// getFreshIncarnation retrieves an incarnation bypassing the read cache, for
+// the checks which must observe the current status of its merge request.
+func getFreshIncarnation(ctx context.Context, client FoxopsClient, id IncarnationId) (Incarnation, error) {
+	if c, ok := unwrapClient[*cachingClient](client); ok {
+		c.forget(id)
+		return c.GetIncarnation(ctx, id)
+	}
+	return client.GetIncarnation(ctx, id)
+}
+
+// wrappingClient is implemented by the clients adding a behaviour to another
+// client, so that the clients behind them can be found.
+type wrappingClient interface {
+	unwrap() FoxopsClient
+}
+
+// unwrapClient returns the first client of type T in the chain of wrapping
+// clients starting at client.
+func unwrapClient[T FoxopsClient](client FoxopsClient) (T, bool) {
+	for client != nil {
+		if c, ok := client.(T); ok {
+			return c, true
+		}
+		w, ok := client.(wrappingClient)
+		if !ok {
+			break
+		}
+		client = w.unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// waitForOpenMergeRequest waits until the last merge request of an incarnation
+// is merged or closed.
+func waitForOpenMergeRequest(
+	ctx context.Context,
+	client FoxopsClient,
+	id IncarnationId,
+	timeout time.Duration,
+) (diags diag.Diagnostics) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		inc, err := getFreshIncarnation(timeoutCtx, client, id)
+		if errors.Is(err, ErrNotFound) {
+			return
+		}
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				diags.AddError("operation timed out before the merge request was merged or closed", err.Error())
+				return
+			}
+			diags.AddError("failed to retrieve incarnation", err.Error())
+			return
+		}
+		if !hasOpenMergeRequest(inc) {
+			return
+		}
+
+		tflog.Info(
+			ctx,
+			"waiting for the merge request to be merged or closed",
+			map[string]interface{}{
+				"id":            id,
+				"merge_request": mergeRequestReference(inc),
+			},
+		)
+
+		select {
+		case <-timeoutCtx.Done():
+			diags.AddError(
+				"operation timed out before the merge request was merged or closed",
+				fmt.Sprintf("The merge request %s of the incarnation %s is still open.", mergeRequestReference(inc), id),
+			)
+			return
+		case <-time.After(mergeRequestPollInterval):
+		}
+	}
+}
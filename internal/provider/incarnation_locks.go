@@ -0,0 +1,34 @@
+package provider
+
+import "sync"
+
+// incarnationLocks serializes the updates of an incarnation during a
+// Terraform operation. Several resources read the template data of an
+// incarnation, change some of its keys and send it back to Foxops, which
+// would lose the changes of each other when done concurrently.
+type incarnationLocks struct {
+	mu    sync.Mutex
+	locks map[IncarnationId]*sync.Mutex
+}
+
+func newIncarnationLocks() *incarnationLocks {
+	return &incarnationLocks{locks: map[IncarnationId]*sync.Mutex{}}
+}
+
+// lock locks the incarnation and returns the function unlocking it.
+func (l *incarnationLocks) lock(id IncarnationId) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	l.mu.Lock()
+	m, ok := l.locks[id]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[id] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// incarnationSlot is the directory of an incarnation repository in which an
+// incarnation is rendered. A slot holds at most one incarnation.
+type incarnationSlot struct {
+	incarnationRepository string
+	targetDirectory       string
+}
+
+func newIncarnationSlot(incarnationRepository string, targetDirectory string) incarnationSlot {
+	return incarnationSlot{
+		incarnationRepository: incarnationRepository,
+		targetDirectory:       targetDirectoryKind.normalize(targetDirectory),
+	}
+}
+
+// incarnationSlots records the slots of the incarnations created by a
+// Terraform operation, so that two incarnations of the configuration created
+// in the same slot fail the plan. Terraform plans each resource of an
+// operation once and configures the provider again for every operation, so
+// a slot claimed twice is claimed by two resources.
+type incarnationSlots struct {
+	mu      sync.Mutex
+	claimed map[incarnationSlot]bool
+}
+
+func newIncarnationSlots() *incarnationSlots {
+	return &incarnationSlots{claimed: map[incarnationSlot]bool{}}
+}
+
+// claim records a slot planned for creation and tells whether it was free.
+func (s *incarnationSlots) claim(slot incarnationSlot) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.claimed[slot] {
+		return false
+	}
+	s.claimed[slot] = true
+	return true
+}
+
+// checkIncarnationSlot fails the plan of a created incarnation when another
+// incarnation of the configuration, or an existing incarnation, targets its
+// slot. The incarnation replaced by the plan, which is deleted first, does not
+// count.
+func checkIncarnationSlot(
+	ctx context.Context,
+	client FoxopsClient,
+	slots *incarnationSlots,
+	incarnationRepository types.String,
+	targetDirectory normalizedStringValue,
+	replaced IncarnationId,
+) (diags diag.Diagnostics) {
+	if incarnationRepository.IsUnknown() || targetDirectory.IsNull() || targetDirectory.IsUnknown() {
+		return
+	}
+
+	directory := targetDirectory.ValueString()
+	slot := newIncarnationSlot(incarnationRepository.ValueString(), directory)
+
+	if !slots.claim(slot) {
+		diags.AddAttributeError(
+			path.Root("target_directory"),
+			"Duplicate incarnation",
+			fmt.Sprintf(
+				"Another foxops_incarnation of the configuration targets the directory %q of %s. "+
+					"An incarnation repository directory can only hold one incarnation.",
+				directory,
+				slot.incarnationRepository,
+			),
+		)
+		return
+	}
+
+	repository := slot.incarnationRepository
+	incs, err := client.ListIncarnations(ctx, ListIncarnationsRequest{IncarnationRepository: &repository})
+	if err != nil {
+		diags.AddWarning(
+			"Unable to check for existing incarnations",
+			fmt.Sprintf("The incarnations of %s could not be listed: %s", repository, err.Error()),
+		)
+		return
+	}
+
+	for _, inc := range incs {
+		if newIncarnationSlot(inc.IncarnationRepository, inc.TargetDirectory) != slot || inc.Id == replaced {
+			continue
+		}
+		diags.AddAttributeError(
+			path.Root("target_directory"),
+			"Incarnation already exists",
+			fmt.Sprintf(
+				"The incarnation %s already targets the directory %q of %s. "+
+					"An incarnation repository directory can only hold one incarnation.\n\n"+
+					"If another foxops_incarnation of the configuration manages it, change the target_directory of one of them. "+
+					"Otherwise import it instead of creating a new one, for example with:\n\n"+
+					"  terraform import foxops_incarnation.<name> %q",
+				inc.Id,
+				directory,
+				repository,
+				inc.Id,
+			),
+		)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/list"
+	"github.com/hashicorp/terraform-plugin-framework/list/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// incarnationListResource lists the incarnations known to Foxops for
+// terraform query, which generates the configuration and import blocks of the
+// incarnations not managed yet. It shares the name and the configuration of
+// the foxops_incarnation resource.
+type incarnationListResource struct {
+	incarnationResource
+}
+
+var _ list.ListResourceWithConfigure = (*incarnationListResource)(nil)
+
+func NewIncarnationListResource() list.ListResource {
+	return &incarnationListResource{}
+}
+
+type incarnationListResourceModel struct {
+	IncarnationRepository types.String          `tfsdk:"incarnation_repository"`
+	TargetDirectory       types.String          `tfsdk:"target_directory"`
+	TemplateRepository    normalizedStringValue `tfsdk:"template_repository"`
+}
+
+func (r *incarnationListResource) ListResourceConfigSchema(_ context.Context, _ list.ListResourceSchemaRequest, resp *list.ListResourceSchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Use this list resource with `terraform query` to discover the incarnations known to Foxops, " +
+			"for example to generate the configuration of the incarnations to import with `-generate-config-out`. " +
+			"Without filters, every incarnation is listed.",
+		Attributes: map[string]schema.Attribute{
+			"incarnation_repository": schema.StringAttribute{
+				MarkdownDescription: "Only list the incarnations of this repository.",
+				Optional:            true,
+			},
+			"target_directory": schema.StringAttribute{
+				MarkdownDescription: "Only list the incarnations in this folder of their repository.",
+				Optional:            true,
+			},
+			"template_repository": schema.StringAttribute{
+				CustomType: templateRepositoryType,
+				MarkdownDescription: "Only list the incarnations of this template repository. " +
+					"Foxops does not filter incarnations by template, so the details of every incarnation are read to select them.",
+				Optional: true,
+			},
+		},
+	}
+}
+
+func (r *incarnationListResource) List(ctx context.Context, req list.ListRequest, stream *list.ListResultsStream) {
+	var data incarnationListResourceModel
+	diags := req.Config.Get(ctx, &data)
+	if diags.HasError() {
+		stream.Results = list.ListResultsStreamDiagnostics(diags)
+		return
+	}
+
+	listRequest := ListIncarnationsRequest{
+		IncarnationRepository: data.IncarnationRepository.ValueStringPointer(),
+	}
+	if !data.TargetDirectory.IsNull() {
+		targetDirectory := targetDirectoryKind.normalize(data.TargetDirectory.ValueString())
+		listRequest.TargetDirectory = &targetDirectory
+	}
+
+	stream.Results = func(push func(list.ListResult) bool) {
+		ctx, span := tracing.Start(ctx, "foxops_incarnation.List")
+		var diags diag.Diagnostics
+		defer func() { tracing.EndWithDiagnostics(span, diags) }()
+
+		incs, err := r.client.ListIncarnations(ctx, listRequest)
+		if err != nil {
+			diags.AddError("failed to list incarnations", err.Error())
+			push(list.ListResult{Diagnostics: diags})
+			return
+		}
+
+		var count int64
+		for _, basic := range incs {
+			if req.Limit > 0 && count >= req.Limit {
+				return
+			}
+
+			result := req.NewListResult(ctx)
+			result.DisplayName = incarnationImportId(basic.IncarnationRepository, basic.TargetDirectory)
+			result.Diagnostics.Append(setIncarnationIdentity(ctx, result.Identity, basic.Id)...)
+
+			// The details are only read when needed, listing the
+			// incarnations of a repository is a single request.
+			if req.IncludeResource || !data.TemplateRepository.IsNull() {
+				inc, err := r.client.GetIncarnation(ctx, basic.Id)
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				if err != nil {
+					diags.AddError("failed to retrieve incarnation", fmt.Sprintf("%s: %s", basic.Id, err.Error()))
+					push(list.ListResult{Diagnostics: diags})
+					return
+				}
+				if !data.TemplateRepository.IsNull() &&
+					!data.TemplateRepository.semanticallyEqual(newTemplateRepositoryValue(inc.TemplateRepository)) {
+					continue
+				}
+				if req.IncludeResource {
+					result.Diagnostics.Append(r.setState(ctx, result.Resource, inc, importedIncarnationModel(), nil)...)
+				}
+			}
+
+			count++
+			if !push(result) {
+				return
+			}
+		}
+	}
+}
+
+// importedIncarnationModel is the prior state of an incarnation which is not
+// managed yet: all of its template data is managed once it is imported.
+func importedIncarnationModel() incarnationResourceModel {
+	return incarnationResourceModel{
+		TemplateData:          types.MapNull(types.StringType),
+		TemplateDataAll:       types.MapNull(types.StringType),
+		TemplateDataFiles:     types.ListNull(types.StringType),
+		TemplateDataSources:   types.MapNull(types.StringType),
+		SensitiveTemplateData: types.MapNull(types.StringType),
+		TemplateVariables:     types.MapNull(templateVariableType),
+	}
+}
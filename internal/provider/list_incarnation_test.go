@@ -0,0 +1,113 @@
+package provider_test
+
+import (
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/knownvalue"
+	"github.com/hashicorp/terraform-plugin-testing/querycheck"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccIncarnationListResource_ShouldListTheFilteredIncarnations(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	incarnations := []provider.Incarnation{
+		{
+			Id:                        "1",
+			IncarnationRepository:     "inc/repo-a",
+			TargetDirectory:           ".",
+			TemplateRepository:        "https://example.com/template.git",
+			TemplateRepositoryVersion: "v1.0.0",
+		},
+		{
+			Id:                        "2",
+			IncarnationRepository:     "inc/repo-a",
+			TargetDirectory:           "sub",
+			TemplateRepository:        "https://example.com/other-template",
+			TemplateRepositoryVersion: "v2.0.0",
+		},
+		{
+			Id:                        "3",
+			IncarnationRepository:     "inc/repo-b",
+			TargetDirectory:           ".",
+			TemplateRepository:        "https://example.com/template/",
+			TemplateRepositoryVersion: "v1.1.0",
+		},
+	}
+
+	basics := make([]provider.IncarnationBasic, 0, len(incarnations))
+	for _, inc := range incarnations {
+		basics = append(basics, provider.IncarnationBasic{
+			Id:                    inc.Id,
+			IncarnationRepository: inc.IncarnationRepository,
+			TargetDirectory:       inc.TargetDirectory,
+		})
+		setup.client.EXPECT().
+			GetIncarnation(gomock.Any(), inc.Id).
+			Return(inc, nil).
+			AnyTimes()
+	}
+
+	setup.client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{}).
+		Return(basics, nil).
+		AnyTimes()
+	setup.client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{
+			IncarnationRepository: helpers.Addr("inc/repo-a"),
+			TargetDirectory:       helpers.Addr("sub"),
+		}).
+		Return(basics[1:2], nil).
+		AnyTimes()
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest: true,
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_14_0),
+		},
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig,
+			},
+			{
+				Query: true,
+				Config: providerConfig + `
+list "foxops_incarnation" "template" {
+  provider = foxops
+
+  config {
+    template_repository = "https://example.com/template"
+  }
+}
+
+list "foxops_incarnation" "directory" {
+  provider = foxops
+
+  config {
+    incarnation_repository = "inc/repo-a"
+    target_directory       = "sub/"
+  }
+}
+`,
+				QueryResultChecks: []querycheck.QueryResultCheck{
+					querycheck.ExpectLength("foxops_incarnation.template", 2),
+					querycheck.ExpectIdentity("foxops_incarnation.template", map[string]knownvalue.Check{
+						"id": knownvalue.StringExact("1"),
+					}),
+					querycheck.ExpectIdentity("foxops_incarnation.template", map[string]knownvalue.Check{
+						"id": knownvalue.StringExact("3"),
+					}),
+					querycheck.ExpectLength("foxops_incarnation.directory", 1),
+					querycheck.ExpectIdentity("foxops_incarnation.directory", map[string]knownvalue.Check{
+						"id": knownvalue.StringExact("2"),
+					}),
+				},
+			},
+		},
+	})
+}
@@ -99,6 +99,36 @@ func (mr *MockFoxopsClientMockRecorder) GetIncarnationWithMergeRequestStatus(arg
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIncarnationWithMergeRequestStatus", reflect.TypeOf((*MockFoxopsClient)(nil).GetIncarnationWithMergeRequestStatus), arg0, arg1, arg2)
 }
 
+// ListIncarnations mocks base method.
+func (m *MockFoxopsClient) ListIncarnations(arg0 context.Context, arg1 provider.ListIncarnationsRequest) ([]provider.IncarnationBasic, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListIncarnations", arg0, arg1)
+	ret0, _ := ret[0].([]provider.IncarnationBasic)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListIncarnations indicates an expected call of ListIncarnations.
+func (mr *MockFoxopsClientMockRecorder) ListIncarnations(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListIncarnations", reflect.TypeOf((*MockFoxopsClient)(nil).ListIncarnations), arg0, arg1)
+}
+
+// ResetIncarnation mocks base method.
+func (m *MockFoxopsClient) ResetIncarnation(arg0 context.Context, arg1 provider.IncarnationId, arg2 provider.ResetIncarnationRequest) (provider.ResetIncarnationResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetIncarnation", arg0, arg1, arg2)
+	ret0, _ := ret[0].(provider.ResetIncarnationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetIncarnation indicates an expected call of ResetIncarnation.
+func (mr *MockFoxopsClientMockRecorder) ResetIncarnation(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetIncarnation", reflect.TypeOf((*MockFoxopsClient)(nil).ResetIncarnation), arg0, arg1, arg2)
+}
+
 // UpdateIncarnation mocks base method.
 func (m *MockFoxopsClient) UpdateIncarnation(arg0 context.Context, arg1 provider.IncarnationId, arg2 provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
 	m.ctrl.T.Helper()
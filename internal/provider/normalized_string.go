@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// normalizedStringKind identifies how the values of a normalizedStringType are
+// normalized before being compared.
+type normalizedStringKind int
+
+const (
+	// targetDirectoryKind treats `./some-folder`, `some-folder` and
+	// `some-folder/` as the same folder.
+	targetDirectoryKind normalizedStringKind = iota + 1
+	// templateRepositoryKind treats repository urls with or without the
+	// `.git` suffix and a trailing slash as the same repository.
+	templateRepositoryKind
+)
+
+func (k normalizedStringKind) String() string {
+	switch k {
+	case targetDirectoryKind:
+		return "TargetDirectory"
+	case templateRepositoryKind:
+		return "TemplateRepository"
+	}
+	return "Unknown"
+}
+
+func (k normalizedStringKind) normalize(value string) string {
+	switch k {
+	case targetDirectoryKind:
+		return path.Clean(value)
+	case templateRepositoryKind:
+		value = strings.TrimRight(value, "/")
+		value = strings.TrimSuffix(value, ".git")
+		return strings.TrimRight(value, "/")
+	}
+	return value
+}
+
+var (
+	targetDirectoryType    = normalizedStringType{kind: targetDirectoryKind}
+	templateRepositoryType = normalizedStringType{kind: templateRepositoryKind}
+)
+
+var _ basetypes.StringTypable = normalizedStringType{}
+
+// normalizedStringType is a string type whose values are semantically equal
+// when they are equal once normalized, so that cosmetic changes in the
+// configuration do not produce diffs.
+type normalizedStringType struct {
+	basetypes.StringType
+	kind normalizedStringKind
+}
+
+func (t normalizedStringType) Equal(o attr.Type) bool {
+	other, ok := o.(normalizedStringType)
+	if !ok {
+		return false
+	}
+	return t.kind == other.kind && t.StringType.Equal(other.StringType)
+}
+
+func (t normalizedStringType) String() string {
+	return fmt.Sprintf("normalizedStringType[%s]", t.kind)
+}
+
+func (t normalizedStringType) ValueFromString(_ context.Context, in basetypes.StringValue) (basetypes.StringValuable, diag.Diagnostics) {
+	return normalizedStringValue{StringValue: in, kind: t.kind}, nil
+}
+
+func (t normalizedStringType) ValueFromTerraform(ctx context.Context, in tftypes.Value) (attr.Value, error) {
+	attrValue, err := t.StringType.ValueFromTerraform(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	stringValue, ok := attrValue.(basetypes.StringValue)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value type of %T", attrValue)
+	}
+
+	stringValuable, diags := t.ValueFromString(ctx, stringValue)
+	if diags.HasError() {
+		return nil, fmt.Errorf("unexpected error converting StringValue to StringValuable: %v", diags)
+	}
+
+	return stringValuable, nil
+}
+
+func (t normalizedStringType) ValueType(context.Context) attr.Value {
+	return normalizedStringValue{kind: t.kind}
+}
+
+var _ basetypes.StringValuableWithSemanticEquals = normalizedStringValue{}
+
+type normalizedStringValue struct {
+	basetypes.StringValue
+	kind normalizedStringKind
+}
+
+func newTargetDirectoryValue(value string) normalizedStringValue {
+	return normalizedStringValue{StringValue: basetypes.NewStringValue(value), kind: targetDirectoryKind}
+}
+
+func newTemplateRepositoryValue(value string) normalizedStringValue {
+	return normalizedStringValue{StringValue: basetypes.NewStringValue(value), kind: templateRepositoryKind}
+}
+
+func (v normalizedStringValue) Equal(o attr.Value) bool {
+	other, ok := o.(normalizedStringValue)
+	if !ok {
+		return false
+	}
+	return v.kind == other.kind && v.StringValue.Equal(other.StringValue)
+}
+
+func (v normalizedStringValue) Type(context.Context) attr.Type {
+	return normalizedStringType{kind: v.kind}
+}
+
+func (v normalizedStringValue) StringSemanticEquals(_ context.Context, newValuable basetypes.StringValuable) (bool, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	newValue, ok := newValuable.(normalizedStringValue)
+	if !ok {
+		diags.AddError(
+			"Semantic Equality Check Error",
+			"An unexpected value type was received while performing semantic equality checks. "+
+				"Please report this to the provider developers.\n\n"+
+				"Expected Value Type: "+fmt.Sprintf("%T", v)+"\n"+
+				"Got Value Type: "+fmt.Sprintf("%T", newValuable),
+		)
+		return false, diags
+	}
+
+	return v.semanticallyEqual(newValue), diags
+}
+
+// semanticallyEqual tells whether both values are known and equal once
+// normalized.
+func (v normalizedStringValue) semanticallyEqual(other normalizedStringValue) bool {
+	if v.IsNull() || v.IsUnknown() || other.IsNull() || other.IsUnknown() {
+		return v.Equal(other)
+	}
+	return v.kind.normalize(v.ValueString()) == other.kind.normalize(other.ValueString())
+}
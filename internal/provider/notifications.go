@@ -0,0 +1,422 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/setvalidator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Actions of the incarnation events.
+const (
+	incarnationCreated = "created"
+	incarnationUpdated = "updated"
+	incarnationReset   = "reset"
+	incarnationDeleted = "deleted"
+)
+
+// notificationSignatureHeader holds the HMAC-SHA256 signature of the payload
+// when the webhook has a secret.
+const notificationSignatureHeader = "X-Foxops-Signature-256"
+
+const defaultNotificationMaxAttempts = 3
+
+// notificationRetryDelay is the delay before the second delivery attempt of
+// a notification, doubled for every following attempt.
+var notificationRetryDelay = time.Second
+
+// notificationWebhookModel holds the values of an entry of the provider
+// `notifications` list.
+type notificationWebhookModel struct {
+	Url             types.String `tfsdk:"url"`
+	PayloadTemplate types.String `tfsdk:"payload_template"`
+	Secret          types.String `tfsdk:"secret"`
+	Events          types.Set    `tfsdk:"events"`
+	MaxAttempts     types.Int64  `tfsdk:"max_attempts"`
+}
+
+var notificationsSchema = schema.ListNestedAttribute{
+	MarkdownDescription: "Webhooks notified whenever the provider creates, updates, resets or deletes an incarnation. " +
+		"A notification is sent in the background after each successful change, while the resource carries on, for example waiting for the merge request. " +
+		"Failed deliveries are retried and then reported as warnings once the resource is done with the incarnation.",
+	Optional: true,
+	NestedObject: schema.NestedAttributeObject{
+		Attributes: map[string]schema.Attribute{
+			"url": schema.StringAttribute{
+				MarkdownDescription: "The url to which the notifications are posted.",
+				Required:            true,
+			},
+			"payload_template": schema.StringAttribute{
+				MarkdownDescription: "A [Go template](https://pkg.go.dev/text/template) rendering the body of the notification. " +
+					"The event fields are `.Action`, `.IncarnationId`, `.IncarnationRepository`, `.TargetDirectory`, `.TemplateRepository`, " +
+					"`.PreviousTemplateRepositoryVersion`, `.TemplateRepositoryVersion`, `.CommitSha` and `.MergeRequestUrl`, " +
+					"and the `json` function encodes a value in JSON. Default: the event encoded in JSON, with snake case keys.",
+				Optional: true,
+			},
+			"secret": schema.StringAttribute{
+				MarkdownDescription: fmt.Sprintf("When set, the body is signed with HMAC-SHA256 and the signature is sent in the `%s` header, like `sha256=<hex digest>`.", notificationSignatureHeader),
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"events": schema.SetAttribute{
+				MarkdownDescription: "The actions notified, among `created`, `updated`, `reset` and `deleted`. Default: all of them.",
+				ElementType:         types.StringType,
+				Optional:            true,
+				Validators: []validator.Set{
+					setvalidator.ValueStringsAre(stringvalidator.OneOf(incarnationCreated, incarnationUpdated, incarnationReset, incarnationDeleted)),
+				},
+			},
+			"max_attempts": schema.Int64Attribute{
+				MarkdownDescription: fmt.Sprintf("The number of delivery attempts of a notification. Default: `%d`.", defaultNotificationMaxAttempts),
+				Optional:            true,
+				Validators: []validator.Int64{
+					int64validator.AtLeast(1),
+				},
+			},
+		},
+	},
+}
+
+// incarnationEvent is a change of an incarnation made by the provider.
+type incarnationEvent struct {
+	Action                            string `json:"action"`
+	IncarnationId                     string `json:"incarnation_id"`
+	IncarnationRepository             string `json:"incarnation_repository"`
+	TargetDirectory                   string `json:"target_directory"`
+	TemplateRepository                string `json:"template_repository"`
+	PreviousTemplateRepositoryVersion string `json:"previous_template_repository_version,omitempty"`
+	TemplateRepositoryVersion         string `json:"template_repository_version,omitempty"`
+	CommitSha                         string `json:"commit_sha,omitempty"`
+	MergeRequestUrl                   string `json:"merge_request_url,omitempty"`
+}
+
+func newIncarnationEvent(action string, inc Incarnation) incarnationEvent {
+	event := incarnationEvent{
+		Action:                    action,
+		IncarnationId:             string(inc.Id),
+		IncarnationRepository:     inc.IncarnationRepository,
+		TargetDirectory:           inc.TargetDirectory,
+		TemplateRepository:        inc.TemplateRepository,
+		TemplateRepositoryVersion: inc.TemplateRepositoryVersion,
+		CommitSha:                 inc.CommitSha,
+	}
+	if inc.MergeRequestUrl != nil {
+		event.MergeRequestUrl = *inc.MergeRequestUrl
+	}
+	return event
+}
+
+var notificationTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// notificationWebhook is a webhook notified of the incarnation events.
+type notificationWebhook struct {
+	url         string
+	payload     *template.Template
+	secret      string
+	events      map[string]bool
+	maxAttempts int
+}
+
+func newNotificationWebhooks(ctx context.Context, models []notificationWebhookModel) ([]notificationWebhook, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	webhooks := make([]notificationWebhook, 0, len(models))
+
+	for i, model := range models {
+		webhook := notificationWebhook{
+			url:         model.Url.ValueString(),
+			secret:      model.Secret.ValueString(),
+			maxAttempts: defaultNotificationMaxAttempts,
+		}
+		if !model.MaxAttempts.IsNull() {
+			webhook.maxAttempts = int(model.MaxAttempts.ValueInt64())
+		}
+		if !model.PayloadTemplate.IsNull() {
+			payload, err := template.New("payload").Funcs(notificationTemplateFuncs).Parse(model.PayloadTemplate.ValueString())
+			if err != nil {
+				diags.AddAttributeError(
+					path.Root("notifications").AtListIndex(i).AtName("payload_template"),
+					"Invalid payload template",
+					err.Error(),
+				)
+				continue
+			}
+			webhook.payload = payload
+		}
+		if !model.Events.IsNull() {
+			var events []string
+			diags.Append(model.Events.ElementsAs(ctx, &events, false)...)
+			webhook.events = map[string]bool{}
+			for _, event := range events {
+				webhook.events[event] = true
+			}
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, diags
+}
+
+// notifies tells whether the webhook is notified of the action.
+func (w notificationWebhook) notifies(action string) bool {
+	return w.events == nil || w.events[action]
+}
+
+func (w notificationWebhook) render(event incarnationEvent) ([]byte, error) {
+	if w.payload == nil {
+		return json.Marshal(event)
+	}
+	var body bytes.Buffer
+	if err := w.payload.Execute(&body, event); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+func (w notificationWebhook) post(ctx context.Context, httpClient *http.Client, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set(notificationSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deliver posts the event to the webhook, retrying with an exponential
+// backoff.
+func (w notificationWebhook) deliver(ctx context.Context, httpClient *http.Client, event incarnationEvent) error {
+	body, err := w.render(event)
+	if err != nil {
+		return fmt.Errorf("unable to render the payload: %w", err)
+	}
+
+	delay := notificationRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, httpClient, body)
+		if err == nil || attempt >= w.maxAttempts {
+			return err
+		}
+		tflog.Debug(ctx, "retrying the notification", map[string]interface{}{
+			"url":     w.url,
+			"attempt": attempt,
+			"error":   err.Error(),
+		})
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// notifyingClient is a FoxopsClient notifying webhooks of the incarnations it
+// changes. The notifications are delivered in the background while the
+// resource carries on, and the delivery failures are kept until the resource
+// which made the change reports them, see notificationWarnings.
+type notifyingClient struct {
+	FoxopsClient
+
+	httpClient *http.Client
+	webhooks   []notificationWebhook
+
+	mu         sync.Mutex
+	deliveries map[IncarnationId]*sync.WaitGroup
+	failures   map[IncarnationId][]string
+}
+
+var _ FoxopsClient = (*notifyingClient)(nil)
+
+func newNotifyingClient(client FoxopsClient, version Version, webhooks []notificationWebhook) *notifyingClient {
+	return &notifyingClient{
+		FoxopsClient: client,
+		httpClient: &http.Client{
+			Transport: helpers.NewTransport(string(version), http.DefaultTransport),
+			Timeout:   30 * time.Second,
+		},
+		webhooks:   webhooks,
+		deliveries: map[IncarnationId]*sync.WaitGroup{},
+		failures:   map[IncarnationId][]string{},
+	}
+}
+
+func (c *notifyingClient) unwrap() FoxopsClient {
+	return c.FoxopsClient
+}
+
+type previousIncarnationKey struct{}
+
+// withPreviousIncarnation passes the incarnation known to a resource before
+// it changes it, so that the notifications do not read it again.
+func withPreviousIncarnation(ctx context.Context, inc Incarnation) context.Context {
+	return context.WithValue(ctx, previousIncarnationKey{}, inc)
+}
+
+func (c *notifyingClient) CreateIncarnation(ctx context.Context, req CreateIncarnationRequest) (Incarnation, error) {
+	inc, err := c.FoxopsClient.CreateIncarnation(ctx, req)
+	if err == nil {
+		c.notify(ctx, newIncarnationEvent(incarnationCreated, inc))
+	}
+	return inc, err
+}
+
+func (c *notifyingClient) UpdateIncarnation(ctx context.Context, id IncarnationId, req UpdateIncarnationRequest) (Incarnation, error) {
+	previous, notified := c.previous(ctx, incarnationUpdated, id)
+	inc, err := c.FoxopsClient.UpdateIncarnation(ctx, id, req)
+	if err == nil && notified {
+		event := newIncarnationEvent(incarnationUpdated, inc)
+		event.PreviousTemplateRepositoryVersion = previous.TemplateRepositoryVersion
+		c.notify(ctx, event)
+	}
+	return inc, err
+}
+
+// ResetIncarnation notifies the previous version only when the reset changes
+// it: without an override, the incarnation is reset to its current version
+// and the version of the files it replaces is not known.
+func (c *notifyingClient) ResetIncarnation(ctx context.Context, id IncarnationId, req ResetIncarnationRequest) (ResetIncarnationResult, error) {
+	previous, notified := c.previous(ctx, incarnationReset, id)
+	result, err := c.FoxopsClient.ResetIncarnation(ctx, id, req)
+	if err == nil && notified {
+		event := newIncarnationEvent(incarnationReset, previous)
+		event.IncarnationId = string(id)
+		if req.OverrideVersion != nil {
+			event.PreviousTemplateRepositoryVersion = previous.TemplateRepositoryVersion
+			event.TemplateRepositoryVersion = *req.OverrideVersion
+		}
+		event.CommitSha = ""
+		event.MergeRequestUrl = result.MergeRequestUrl
+		c.notify(ctx, event)
+	}
+	return result, err
+}
+
+func (c *notifyingClient) DeleteIncarnation(ctx context.Context, id IncarnationId) error {
+	previous, notified := c.previous(ctx, incarnationDeleted, id)
+	err := c.FoxopsClient.DeleteIncarnation(ctx, id)
+	if err == nil && notified {
+		event := newIncarnationEvent(incarnationDeleted, previous)
+		event.IncarnationId = string(id)
+		event.PreviousTemplateRepositoryVersion = previous.TemplateRepositoryVersion
+		event.TemplateRepositoryVersion = ""
+		event.CommitSha = ""
+		event.MergeRequestUrl = ""
+		c.notify(ctx, event)
+	}
+	return err
+}
+
+// previous returns the incarnation before it is changed by the action, when a
+// webhook is notified of it: the one passed by withPreviousIncarnation or else
+// the one read from Foxops, which only holds the id when it cannot be read.
+func (c *notifyingClient) previous(ctx context.Context, action string, id IncarnationId) (Incarnation, bool) {
+	if !slices.ContainsFunc(c.webhooks, func(webhook notificationWebhook) bool { return webhook.notifies(action) }) {
+		return Incarnation{}, false
+	}
+	if inc, ok := ctx.Value(previousIncarnationKey{}).(Incarnation); ok && inc.Id == id {
+		return inc, true
+	}
+	inc, err := c.FoxopsClient.GetIncarnation(ctx, id)
+	if err != nil {
+		return Incarnation{Id: id}, true
+	}
+	return inc, true
+}
+
+// notify delivers the event to the webhooks in the background.
+func (c *notifyingClient) notify(ctx context.Context, event incarnationEvent) {
+	id := IncarnationId(event.IncarnationId)
+	for _, webhook := range c.webhooks {
+		if !webhook.notifies(event.Action) {
+			continue
+		}
+
+		c.mu.Lock()
+		deliveries, ok := c.deliveries[id]
+		if !ok {
+			deliveries = &sync.WaitGroup{}
+			c.deliveries[id] = deliveries
+		}
+		deliveries.Add(1)
+		c.mu.Unlock()
+
+		go func() {
+			defer deliveries.Done()
+			if err := webhook.deliver(ctx, c.httpClient, event); err != nil {
+				c.mu.Lock()
+				c.failures[id] = append(
+					c.failures[id],
+					fmt.Sprintf("The %s notification of the incarnation %s could not be delivered to %s: %s", event.Action, event.IncarnationId, webhook.url, err.Error()),
+				)
+				c.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// notificationWarnings waits for the notifications about an incarnation and
+// returns their delivery failures as warnings. Resources call it once they
+// are done with the incarnation, so that the deliveries do not delay them.
+func notificationWarnings(client FoxopsClient, id IncarnationId) (diags diag.Diagnostics) {
+	c, ok := unwrapClient[*notifyingClient](client)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	deliveries := c.deliveries[id]
+	c.mu.Unlock()
+	if deliveries != nil {
+		deliveries.Wait()
+	}
+
+	c.mu.Lock()
+	failures := c.failures[id]
+	delete(c.failures, id)
+	c.mu.Unlock()
+
+	for _, failure := range failures {
+		diags.AddWarning("Notification not delivered", failure)
+	}
+	return
+}
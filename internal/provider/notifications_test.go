@@ -0,0 +1,162 @@
+package provider_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccIncarnationResource_NotificationsShouldBeSentForEachChange(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]string{}
+	failedAttempts := 0
+
+	notifications := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/signed":
+			mac := hmac.New(sha256.New, []byte("notification-secret"))
+			mac.Write(body)
+			assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Foxops-Signature-256"))
+		case "/failing":
+			failedAttempts += 1
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received[r.URL.Path] = append(received[r.URL.Path], string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer notifications.Close()
+
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		TemplateData:              map[string]interface{}{},
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			current.CommitSha = "87654321"
+			current.MergeRequestId = helpers.Addr("2")
+			current.MergeRequestStatus = helpers.Addr("open")
+			current.MergeRequestUrl = helpers.Addr("inc/repo/mr!2")
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(version string) string {
+		return fmt.Sprintf(`
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  notifications = [
+    {
+      url    = "%[1]s/signed"
+      secret = "notification-secret"
+    },
+    {
+      url              = "%[1]s/templated"
+      events           = ["updated"]
+      payload_template = "{{ .IncarnationRepository }}: {{ .PreviousTemplateRepositoryVersion }} -> {{ .TemplateRepositoryVersion }} ({{ .MergeRequestUrl }})"
+    },
+    {
+      url          = "%[1]s/failing"
+      events       = ["created"]
+      max_attempts = 2
+    },
+  ]
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = %[2]q
+}
+`, notifications.URL, version)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				// The delivery failures are only warnings.
+				Config: config("v1.0.0"),
+			},
+			{
+				Config: config("v2.0.0"),
+			},
+		},
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, 2, failedAttempts)
+	assert.Equal(t, []string{"inc/repo: v1.0.0 -> v2.0.0 (inc/repo/mr!2)"}, received["/templated"])
+
+	require.Len(t, received["/signed"], 3)
+	var events []map[string]interface{}
+	for _, body := range received["/signed"] {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(body), &event))
+		events = append(events, event)
+	}
+	assert.Equal(t, "created", events[0]["action"])
+	assert.Equal(t, "v1.0.0", events[0]["template_repository_version"])
+	assert.Equal(t, "updated", events[1]["action"])
+	assert.Equal(t, "v1.0.0", events[1]["previous_template_repository_version"])
+	assert.Equal(t, "v2.0.0", events[1]["template_repository_version"])
+	assert.Equal(t, "inc/repo/mr!2", events[1]["merge_request_url"])
+	assert.Equal(t, "deleted", events[2]["action"])
+	assert.Equal(t, "1234", events[2]["incarnation_id"])
+	assert.Equal(t, "v2.0.0", events[2]["previous_template_repository_version"])
+}
@@ -3,13 +3,20 @@ package provider
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/list"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
@@ -24,18 +31,45 @@ type Version string
 
 type ClientEndpoint string
 type ClientToken string
-type ClientConstructor func(ClientEndpoint, ClientToken, Version) FoxopsClient
+type ClientConstructor func(ClientEndpoint, ClientToken, Version, ClientConfig) FoxopsClient
+
+// ClientConfig holds the optional settings of the Foxops client.
+type ClientConfig struct {
+	Headers                   map[string]string
+	SensitiveTemplateDataKeys []string
+}
 
 type foxopsProvider struct {
 	version     Version
 	clientCtor  ClientConstructor
 	datasources []func() datasource.DataSource
 	resources   []func() resource.Resource
+	functions   []func() function.Function
+	ephemerals  []func() ephemeral.EphemeralResource
+	lists       []func() list.ListResource
 }
 
+var _ provider.ProviderWithFunctions = (*foxopsProvider)(nil)
+var _ provider.ProviderWithEphemeralResources = (*foxopsProvider)(nil)
+var _ provider.ProviderWithListResources = (*foxopsProvider)(nil)
+
 type FoxopsProviderModel struct {
-	Endpoint types.String `tfsdk:"endpoint"`
-	Token    types.String `tfsdk:"token"`
+	Endpoint                       types.String               `tfsdk:"endpoint"`
+	Token                          types.String               `tfsdk:"token"`
+	EphemeralOnly                  types.Bool                 `tfsdk:"ephemeral_only"`
+	RefreshCacheTTL                types.String               `tfsdk:"refresh_cache_ttl"`
+	Headers                        types.Map                  `tfsdk:"headers"`
+	SensitiveTemplateDataKeys      types.Set                  `tfsdk:"sensitive_template_data_keys"`
+	Defaults                       *incarnationDefaultsModel  `tfsdk:"defaults"`
+	Gitlab                         *forgeModel                `tfsdk:"gitlab"`
+	Github                         *forgeModel                `tfsdk:"github"`
+	MergeRequestWebhooks           *mergeRequestWebhooksModel `tfsdk:"merge_request_webhooks"`
+	Notifications                  []notificationWebhookModel `tfsdk:"notifications"`
+	ReadOnly                       types.Bool                 `tfsdk:"read_only"`
+	RequireMergeRequest            types.Bool                 `tfsdk:"require_merge_request"`
+	AllowedTemplateRepositories    types.List                 `tfsdk:"allowed_template_repositories"`
+	AllowedIncarnationRepositories types.List                 `tfsdk:"allowed_incarnation_repositories"`
+	AllowLocalTemplateRepositories types.Bool                 `tfsdk:"allow_local_template_repositories"`
 }
 
 func New(
@@ -43,6 +77,9 @@ func New(
 	clientCtor ClientConstructor,
 	datasources []func() datasource.DataSource,
 	resources []func() resource.Resource,
+	functions []func() function.Function,
+	ephemerals []func() ephemeral.EphemeralResource,
+	lists []func() list.ListResource,
 ) func() provider.Provider {
 	return func() provider.Provider {
 		return &foxopsProvider{
@@ -50,6 +87,9 @@ func New(
 			clientCtor:  clientCtor,
 			datasources: datasources,
 			resources:   resources,
+			functions:   functions,
+			ephemerals:  ephemerals,
+			lists:       lists,
 		}
 	}
 }
@@ -68,8 +108,77 @@ func (p *foxopsProvider) Schema(ctx context.Context, req provider.SchemaRequest,
 				Optional:            true,
 			},
 			"token": schema.StringAttribute{
-				MarkdownDescription: "The token used to authenticate to your Foxops instance.",
-				Optional:            true,
+				MarkdownDescription: "The token used to authenticate to your Foxops instance, " +
+					"which can be the `token` of a `foxops_token` ephemeral resource opened by another configuration of the provider. " +
+					"Required unless `ephemeral_only` is set.",
+				Optional: true,
+			},
+			"ephemeral_only": schema.BoolAttribute{
+				MarkdownDescription: "Set to `true` on a configuration of the provider without a token, which is only used to open `foxops_token` ephemeral resources. " +
+					"Its resources and data sources fail when used. Default: `false`.",
+				Optional: true,
+			},
+			"headers": schema.MapAttribute{
+				MarkdownDescription: "Additional HTTP headers sent with every request to your Foxops instance, " +
+					"for example to authenticate to a gateway in front of it. Their values are redacted from the logs.",
+				ElementType: types.StringType,
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"sensitive_template_data_keys": schema.SetAttribute{
+				MarkdownDescription: "Keys of `template_data` whose values are redacted from the logs. " +
+					fmt.Sprintf("Request and response bodies are only logged when the `%s` environment variable is set to `true`.", helpers.LogHTTPBodiesEnvVar),
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"defaults":               defaultsSchema,
+			"gitlab":                 forgeSchema("GitLab", "GitLab instance", defaultGitlabBaseURL, gitlabTokenEnvVar),
+			"github":                 forgeSchema("GitHub", "GitHub API, like `https://github.example.com/api/v3` for GitHub Enterprise Server", defaultGithubBaseURL, githubTokenEnvVar),
+			"merge_request_webhooks": mergeRequestWebhooksSchema,
+			"notifications":          notificationsSchema,
+			"allowed_template_repositories": schema.ListAttribute{
+				MarkdownDescription: "The template repositories from which incarnations may be created, checked when planning the creation or replacement of an incarnation " +
+					"and when reading the `foxops_template_versions` data source. " +
+					repositoryRulesDescription,
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"allowed_incarnation_repositories": schema.ListAttribute{
+				MarkdownDescription: "The repositories in which incarnations may be created, checked when planning the creation or replacement of an incarnation. " +
+					repositoryRulesDescription,
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"allow_local_template_repositories": schema.BoolAttribute{
+				MarkdownDescription: "Whether the tags of template repositories given as absolute paths or `file://` urls, like `/srv/git/template.git`, " +
+					"may be listed to resolve `template_repository_version_constraint` and to read the `foxops_template_versions` data source. " +
+					"Only http(s), ssh and scp-like urls are listed otherwise. Default: `false`.",
+				Optional: true,
+			},
+			"read_only": schema.BoolAttribute{
+				MarkdownDescription: "Whether the provider refuses to create, update, reset or delete incarnations. " +
+					"Plans and refreshes work as usual, which allows running them with a production token without any risk of writes. " +
+					"Default: `false`.",
+				Optional: true,
+			},
+			"require_merge_request": schema.BoolAttribute{
+				MarkdownDescription: "Whether the provider refuses the changes which would be merged without review, " +
+					"that is every update with `auto_merge_on_update` (or `auto_merge` for rollouts) set to `true`, " +
+					"and every creation of an incarnation, which Foxops commits without a merge request. " +
+					"Resets and rollbacks are allowed, as they always open a merge request. Default: `false`.",
+				Optional: true,
+			},
+			"refresh_cache_ttl": schema.StringAttribute{
+				MarkdownDescription: "When set, incarnations and lists of incarnations read from Foxops are cached for this amount of time. " +
+					"On the first read, the incarnations listed by Foxops are fetched in the background in batches of 25, " +
+					"so that the reads of the following resources, data sources and rollouts are served from memory, " +
+					"and the concurrent reads of an incarnation share a single request. " +
+					"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). " +
+					"Example: `5m`. Default: caching is disabled.",
+				Optional: true,
+				Validators: []validator.String{
+					durationValidator,
+				},
 			},
 		},
 	}
@@ -141,30 +250,112 @@ func (p *foxopsProvider) Configure(ctx context.Context, req provider.ConfigureRe
 		)
 	}
 
-	if token == "" {
+	if token == "" && !data.EphemeralOnly.ValueBool() {
 		resp.Diagnostics.AddAttributeError(
 			path.Root("token"),
 			"Missing Foxops API token",
 			fmt.Sprintf(
 				"The provider cannot create the Foxops API client as there is a missing configuration value for the API token."+
-					"Set the token value in the configuration or use the %s environment variable. IF either is already set, ensure the value is not empty.",
+					"Set the token value in the configuration or use the %s environment variable. IF either is already set, ensure the value is not empty. "+
+					"Set ephemeral_only to true if the provider is only used to open foxops_token ephemeral resources.",
 				token_env_var,
 			),
 		)
 	}
 
+	var refreshCacheTTL time.Duration
+	if !data.RefreshCacheTTL.IsNull() && !data.RefreshCacheTTL.IsUnknown() {
+		var err error
+		refreshCacheTTL, err = time.ParseDuration(data.RefreshCacheTTL.ValueString())
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("refresh_cache_ttl"),
+				"Invalid refresh cache TTL",
+				err.Error(),
+			)
+		}
+	}
+
+	var clientConfig ClientConfig
+	if !data.Headers.IsNull() {
+		resp.Diagnostics.Append(data.Headers.ElementsAs(ctx, &clientConfig.Headers, false)...)
+	}
+	if !data.SensitiveTemplateDataKeys.IsNull() {
+		resp.Diagnostics.Append(data.SensitiveTemplateDataKeys.ElementsAs(ctx, &clientConfig.SensitiveTemplateDataKeys, false)...)
+	}
+
+	forges, diags := newForgeClients(p.version, data.Gitlab, data.Github)
+	resp.Diagnostics.Append(diags...)
+
+	webhooks, diags := newNotificationWebhooks(ctx, data.Notifications)
+	resp.Diagnostics.Append(diags...)
+
+	templatePolicy, diags := newRepositoryPolicy(ctx, "allowed_template_repositories", data.AllowedTemplateRepositories)
+	resp.Diagnostics.Append(diags...)
+	incarnationPolicy, diags := newRepositoryPolicy(ctx, "allowed_incarnation_repositories", data.AllowedIncarnationRepositories)
+	resp.Diagnostics.Append(diags...)
+
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	client := p.clientCtor(
-		ClientEndpoint(endpoint),
-		ClientToken(token),
-		p.version,
-	)
+	// A provider with ephemeral_only and without a token may only be used to
+	// open foxops_token ephemeral resources, its resources and data sources
+	// fail when used.
+	var client FoxopsClient = missingTokenClient{}
+	if token != "" {
+		client = p.clientCtor(
+			ClientEndpoint(endpoint),
+			ClientToken(token),
+			p.version,
+			clientConfig,
+		)
+	}
+
+	if refreshCacheTTL > 0 {
+		client = NewCachingClient(client, refreshCacheTTL)
+	}
+
+	if data.MergeRequestWebhooks != nil {
+		client, diags = newWebhookClient(client, data.MergeRequestWebhooks)
+		resp.Diagnostics.Append(diags...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	if len(webhooks) > 0 {
+		client = newNotifyingClient(client, p.version, webhooks)
+	}
+
+	if data.ReadOnly.ValueBool() || data.RequireMergeRequest.ValueBool() {
+		client = &guardClient{
+			FoxopsClient:        client,
+			readOnly:            data.ReadOnly.ValueBool(),
+			requireMergeRequest: data.RequireMergeRequest.ValueBool(),
+		}
+	}
 
-	resp.DataSourceData = client
-	resp.ResourceData = client
+	providerData := &resourceProviderData{
+		client:   client,
+		slots:    newIncarnationSlots(),
+		locks:    newIncarnationLocks(),
+		versions: newTemplateVersions(data.AllowLocalTemplateRepositories.ValueBool()),
+		forges:   forges,
+
+		templatePolicy:    templatePolicy,
+		incarnationPolicy: incarnationPolicy,
+	}
+	if data.Defaults != nil {
+		providerData.defaults = *data.Defaults
+	}
+
+	resp.DataSourceData = providerData
+	resp.ResourceData = providerData
+	resp.ListResourceData = providerData
+	resp.EphemeralResourceData = &http.Client{
+		Transport: helpers.NewTransport(string(p.version), http.DefaultTransport),
+	}
 }
 
 func (p *foxopsProvider) DataSources(ctx context.Context) []func() datasource.DataSource {
@@ -174,3 +365,15 @@ func (p *foxopsProvider) DataSources(ctx context.Context) []func() datasource.Da
 func (p *foxopsProvider) Resources(context.Context) []func() resource.Resource {
 	return p.resources
 }
+
+func (p *foxopsProvider) Functions(context.Context) []func() function.Function {
+	return p.functions
+}
+
+func (p *foxopsProvider) EphemeralResources(context.Context) []func() ephemeral.EphemeralResource {
+	return p.ephemerals
+}
+
+func (p *foxopsProvider) ListResources(context.Context) []func() list.ListResource {
+	return p.lists
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/Roche/terraform-provider-foxops/internal/provider"
 	mock_provider "github.com/Roche/terraform-provider-foxops/internal/provider/mocks"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/list"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
@@ -39,13 +42,34 @@ func newTestProviderSetup(
 			"foxops": providerserver.NewProtocol6WithError(
 				provider.New(
 					"test",
-					func(provider.ClientEndpoint, provider.ClientToken, provider.Version) provider.FoxopsClient {
+					func(provider.ClientEndpoint, provider.ClientToken, provider.Version, provider.ClientConfig) provider.FoxopsClient {
 						return client
 					},
-					[]func() datasource.DataSource{provider.NewIncarnationDataSource},
-					[]func() resource.Resource{provider.NewIncarnationResource},
+					[]func() datasource.DataSource{provider.NewIncarnationDataSource, provider.NewTemplateVersionsDataSource},
+					[]func() resource.Resource{provider.NewIncarnationResource, provider.NewIncarnationTemplateDataResource, provider.NewTemplateRolloutResource},
+					[]func() function.Function{
+						provider.NewParseMergeRequestUrlFunction,
+						provider.NewIncarnationImportIdFunction,
+						provider.NewTemplateVersionCompareFunction,
+						provider.NewMergeTemplateDataFunction,
+					},
+					[]func() ephemeral.EphemeralResource{
+						provider.NewTokenEphemeralResource,
+					},
+					[]func() list.ListResource{
+						provider.NewIncarnationListResource,
+					},
 				)(),
 			),
 		},
 	}
 }
+
+// expectNoExistingIncarnations makes the client find no existing incarnation
+// when a created incarnation checks that its slot is free.
+func (s testProviderSetup) expectNoExistingIncarnations() {
+	s.client.EXPECT().
+		ListIncarnations(gomock.Any(), gomock.Any()).
+		Return([]provider.IncarnationBasic{}, nil).
+		AnyTimes()
+}
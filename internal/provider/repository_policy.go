@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+const repositoryRulesDescription = "Rules are globs, where `*` matches within a path segment and `**` across segments, " +
+	"or regular expressions when enclosed in slashes, like `/^https://gitlab\\.example\\.com/.*$/`. " +
+	"A rule prefixed with `!` rejects the repositories it matches and the last matching rule wins. " +
+	"The `.git` suffix and trailing slashes are ignored. Default: every repository is allowed."
+
+// repositoryRule is a rule of an allowlist of repositories. Rules are globs,
+// where `*` matches within a path segment and `**` across segments, or
+// regular expressions when enclosed in slashes. A rule prefixed with `!`
+// rejects the repositories it matches.
+type repositoryRule struct {
+	rule    string
+	deny    bool
+	matcher *regexp.Regexp
+}
+
+func newRepositoryRule(rule string) (repositoryRule, error) {
+	pattern, deny := strings.CutPrefix(rule, "!")
+
+	var expression string
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expression = "^(?:" + pattern[1:len(pattern)-1] + ")$"
+	} else {
+		expression = "^" + globExpression(templateRepositoryKind.normalize(pattern)) + "$"
+	}
+
+	matcher, err := regexp.Compile(expression)
+	if err != nil {
+		return repositoryRule{}, err
+	}
+	return repositoryRule{rule: rule, deny: deny, matcher: matcher}, nil
+}
+
+func globExpression(glob string) string {
+	var expression strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				expression.WriteString(".*")
+				i++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expression.String()
+}
+
+// repositoryPolicy is an allowlist of repositories set in a provider
+// attribute. The last rule matching a repository decides whether it is
+// allowed, like in a `.gitignore` file.
+type repositoryPolicy struct {
+	attribute string
+	rules     []repositoryRule
+}
+
+func newRepositoryPolicy(ctx context.Context, attribute string, rules types.List) (*repositoryPolicy, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if rules.IsNull() || rules.IsUnknown() {
+		return nil, diags
+	}
+
+	var values []string
+	diags.Append(rules.ElementsAs(ctx, &values, false)...)
+
+	policy := &repositoryPolicy{attribute: attribute}
+	for i, value := range values {
+		rule, err := newRepositoryRule(value)
+		if err != nil {
+			diags.AddAttributeError(path.Root(attribute).AtListIndex(i), "Invalid repository rule", err.Error())
+			continue
+		}
+		policy.rules = append(policy.rules, rule)
+	}
+	return policy, diags
+}
+
+// check returns an error naming the rule rejecting the repository, if any.
+func (p *repositoryPolicy) check(repository string) error {
+	if p == nil {
+		return nil
+	}
+
+	normalized := templateRepositoryKind.normalize(repository)
+	var decisive *repositoryRule
+	for i, rule := range p.rules {
+		if rule.matcher.MatchString(normalized) {
+			decisive = &p.rules[i]
+		}
+	}
+
+	switch {
+	case decisive == nil:
+		return fmt.Errorf("%s matches none of the rules of the provider %s", repository, p.attribute)
+	case decisive.deny:
+		return fmt.Errorf("%s is rejected by the rule %q of the provider %s", repository, decisive.rule, p.attribute)
+	}
+	return nil
+}
+
+// checkRepositoryPolicy validates a planned repository against a policy.
+func checkRepositoryPolicy(policy *repositoryPolicy, attribute path.Path, summary string, repository types.String) (diags diag.Diagnostics) {
+	if repository.IsNull() || repository.IsUnknown() {
+		return
+	}
+	if err := policy.check(repository.ValueString()); err != nil {
+		diags.AddAttributeError(attribute, summary, err.Error()+".")
+	}
+	return
+}
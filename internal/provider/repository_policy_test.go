@@ -0,0 +1,142 @@
+package provider_test
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccIncarnationResource_RepositoryPoliciesShouldRejectUnapprovedRepositories(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	config := func(templateRepository string, incarnationRepository string) string {
+		return fmt.Sprintf(`
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  allowed_template_repositories    = ["https://git.example.com/templates/**", "!**/legacy-*"]
+  allowed_incarnation_repositories = ["/https://git\\.example\\.com/apps/[a-z-]+/"]
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = %q
+  template_repository         = %q
+  template_repository_version = "v1.0.0"
+}
+`, incarnationRepository, templateRepository)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      config("https://git.example.com/templates/legacy-app.git", "https://git.example.com/apps/app"),
+				ExpectError: regexp.MustCompile(`(?s)Template repository not allowed.*rejected\s+by\s+the\s+rule\s+"!\*\*/legacy-\*"\s+of\s+the\s+provider\s+allowed_template_repositories`),
+			},
+			{
+				Config:      config("https://github.com/other/template", "https://git.example.com/apps/app"),
+				ExpectError: regexp.MustCompile(`(?s)Template repository not allowed.*matches\s+none\s+of\s+the\s+rules\s+of\s+the\s+provider\s+allowed_template_repositories`),
+			},
+			{
+				Config:      config("https://git.example.com/templates/group/app/", "https://git.example.com/other/app"),
+				ExpectError: regexp.MustCompile(`(?s)Incarnation repository not allowed.*matches\s+none\s+of\s+the\s+rules\s+of\s+the\s+provider\s+allowed_incarnation_repositories`),
+			},
+			{
+				Config:             config("https://git.example.com/templates/group/app.git", "https://git.example.com/apps/app.git"),
+				PlanOnly:           true,
+				ExpectNonEmptyPlan: true,
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_RepositoryPoliciesShouldRejectAChangedIncarnationRepository(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	incarnation := provider.Incarnation{
+		Id:                        "1234",
+		IncarnationRepository:     "https://git.example.com/apps/app",
+		TargetDirectory:           ".",
+		TemplateRepository:        "https://git.example.com/templates/app",
+		TemplateRepositoryVersion: "v1.0.0",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "https://git.example.com/apps/app/commit",
+		TemplateData:              map[string]interface{}{},
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(incarnation, nil)
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), incarnation.Id).
+		Return(incarnation, nil).
+		AnyTimes()
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), incarnation.Id).
+		Return(nil)
+
+	config := func(incarnationRepository string) string {
+		return fmt.Sprintf(`
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  allowed_incarnation_repositories = ["https://git.example.com/apps/*"]
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = %q
+  template_repository         = "https://git.example.com/templates/app"
+  template_repository_version = "v1.0.0"
+}
+`, incarnationRepository)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("https://git.example.com/apps/app"),
+			},
+			{
+				Config:      config("https://git.example.com/other/app"),
+				ExpectError: regexp.MustCompile(`(?s)Incarnation repository not allowed`),
+			},
+		},
+	})
+}
+
+func TestAcc_TemplateVersionsDataSource_RepositoryPoliciesShouldRejectUnapprovedRepositories(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  allowed_template_repositories = ["https://git.example.com/templates/**"]
+}
+
+data "foxops_template_versions" "test" {
+  template_repository = "https://github.com/other/template"
+}
+`,
+				ExpectError: regexp.MustCompile(`(?s)Template repository not allowed.*matches\s+none\s+of\s+the\s+rules\s+of\s+the\s+provider\s+allowed_template_repositories`),
+			},
+		},
+	})
+}
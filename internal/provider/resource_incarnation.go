@@ -2,30 +2,58 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/hashicorp/terraform-plugin-framework-validators/objectvalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/identityschema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
 type incarnationResource struct {
-	client FoxopsClient
+	client   FoxopsClient
+	defaults incarnationDefaultsModel
+	slots    *incarnationSlots
+	locks    *incarnationLocks
+	versions *templateVersions
+	forges   []forgeClient
+
+	templatePolicy    *repositoryPolicy
+	incarnationPolicy *repositoryPolicy
 }
 
 var _ resource.ResourceWithConfigure = (*incarnationResource)(nil)
+var _ resource.ResourceWithValidateConfig = (*incarnationResource)(nil)
+var _ resource.ResourceWithModifyPlan = (*incarnationResource)(nil)
+var _ resource.ResourceWithIdentity = (*incarnationResource)(nil)
 
 func NewIncarnationResource() resource.Resource {
 	return &incarnationResource{}
 }
 
+const (
+	onDestroyForget        = "forget"
+	onDestroyFailIfOpenMR  = "fail_if_open_mr"
+	onDestroyWaitForOpenMR = "wait_for_open_mr"
+
+	defaultOnDestroyTimeout = 10 * time.Minute
+)
+
 type incarnationStateSetter interface {
 	Set(ctx context.Context, val interface{}) diag.Diagnostics
 }
@@ -33,17 +61,45 @@ type incarnationStateSetter interface {
 type incarnationResourceModel struct {
 	Id                        types.String          `tfsdk:"id"`
 	IncarnationRepository     types.String          `tfsdk:"incarnation_repository"`
-	TargetDirectory           types.String          `tfsdk:"target_directory"`
+	TargetDirectory           normalizedStringValue `tfsdk:"target_directory"`
 	TemplateData              types.Map             `tfsdk:"template_data"`
-	TemplateRepository        types.String          `tfsdk:"template_repository"`
+	TemplateDataAll           types.Map             `tfsdk:"template_data_all"`
+	TemplateDataJSON          types.String          `tfsdk:"template_data_json"`
+	TemplateDataFiles         types.List            `tfsdk:"template_data_files"`
+	TemplateDataSources       types.Map             `tfsdk:"template_data_sources"`
+	EffectiveTemplateData     types.Map             `tfsdk:"effective_template_data"`
+	SensitiveTemplateData     types.Map             `tfsdk:"sensitive_template_data"`
+	SensitiveTemplateDataWO   types.Map             `tfsdk:"sensitive_template_data_wo"`
+	SensitiveTemplateDataHash types.Map             `tfsdk:"sensitive_template_data_hashes"`
+	TemplateSpecFile          types.String          `tfsdk:"template_spec_file"`
+	TemplateVariables         types.Map             `tfsdk:"template_variables"`
+	TemplateRepository        normalizedStringValue `tfsdk:"template_repository"`
 	TemplateRepositoryVersion types.String          `tfsdk:"template_repository_version"`
+	TemplateVersionConstraint types.String          `tfsdk:"template_repository_version_constraint"`
+	PreventDowngrade          types.Bool            `tfsdk:"prevent_downgrade"`
 	MergeRequestUrl           types.String          `tfsdk:"merge_request_url"`
 	CommitSha                 types.String          `tfsdk:"commit_sha"`
 	CommitUrl                 types.String          `tfsdk:"commit_url"`
 	MergeRequestStatus        types.String          `tfsdk:"merge_request_status"`
 	MergeRequestId            types.String          `tfsdk:"merge_request_id"`
 	WaitForMRStatus           *waitForStatusMRModel `tfsdk:"wait_for_mr_status_on_update"`
+	WaitForPipeline           *waitForPipelineModel `tfsdk:"wait_for_pipeline"`
 	AutoMerge                 types.Bool            `tfsdk:"auto_merge_on_update"`
+	DeletionProtection        types.Bool            `tfsdk:"deletion_protection"`
+	RollbackOnFailure         types.Bool            `tfsdk:"rollback_on_failure"`
+	OnDestroy                 types.String          `tfsdk:"on_destroy"`
+	OnDestroyTimeout          types.String          `tfsdk:"on_destroy_timeout"`
+}
+
+// incarnationIdentityModel is the identity of an incarnation, used to import
+// it and to list it with terraform query.
+type incarnationIdentityModel struct {
+	Id types.String `tfsdk:"id"`
+}
+
+// waitForPipelineModel holds the settings of wait_for_pipeline.
+type waitForPipelineModel struct {
+	Timeout types.String `tfsdk:"timeout"`
 }
 
 func (ds *incarnationResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -55,22 +111,31 @@ func (ds *incarnationResource) Configure(ctx context.Context, req resource.Confi
 		return
 	}
 
-	client, ok := req.ProviderData.(FoxopsClient)
+	data, ok := req.ProviderData.(*resourceProviderData)
 	if !ok {
 		resp.Diagnostics.AddError(
-			"Unexpected Data Source Configure Type",
-			fmt.Sprintf("Expected provider.FoxopsClient, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+			"Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *provider.resourceProviderData, got: %T. Please report this issue to the provider developers.", req.ProviderData),
 		)
 		return
 	}
 
-	ds.client = client
+	ds.client = data.client
+	ds.defaults = data.defaults
+	ds.slots = data.slots
+	ds.locks = data.locks
+	ds.versions = data.versions
+	ds.forges = data.forges
+	ds.templatePolicy = data.templatePolicy
+	ds.incarnationPolicy = data.incarnationPolicy
 }
 
 func (r *incarnationResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
 	resp.Schema = schema.Schema{
-		Description:         "Use this resource to create and manage incarnations.",
-		MarkdownDescription: "Use this resource to create and manage incarnations.",
+		Description: "Use this resource to create and manage incarnations." +
+			" A directory of an incarnation repository holds a single incarnation: planning a new incarnation fails when another incarnation of the configuration, or one already known to Foxops, targets the same directory.",
+		MarkdownDescription: "Use this resource to create and manage incarnations." +
+			" A directory of an incarnation repository holds a single incarnation: planning a new incarnation fails when another incarnation of the configuration, or one already known to Foxops, targets the same directory.",
 		Attributes: map[string]schema.Attribute{
 			"id": schema.StringAttribute{
 				MarkdownDescription: "The `id` of the incarnation.",
@@ -80,41 +145,183 @@ func (r *incarnationResource) Schema(_ context.Context, _ resource.SchemaRequest
 				},
 			},
 			"incarnation_repository": schema.StringAttribute{
-				MarkdownDescription: "The repository in which the incarnation will be created.",
+				MarkdownDescription: "The repository in which the incarnation will be created. Changing it replaces the incarnation.",
 				Required:            true,
-				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplace(),
-				},
 			},
 			"target_directory": schema.StringAttribute{
-				MarkdownDescription: "The folder in which the incarnation will be created. Default: `.`.",
-				Optional:            true,
-				Computed:            true,
-				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplaceIfConfigured(),
-				},
+				CustomType: targetDirectoryType,
+				MarkdownDescription: "The folder in which the incarnation will be created. " +
+					"`./some-folder`, `some-folder` and `some-folder/` designate the same folder. " +
+					"Default: the `target_directory` of the provider `defaults` block, or `.`.",
+				Optional: true,
+				Computed: true,
 			},
 			"template_data": schema.MapAttribute{
 				MarkdownDescription: "An object containing variables used to generate the incarnation. " +
-					"These variables should match those declared in the `fengine.yaml` file of the template",
+					"These variables should match those declared in the `fengine.yaml` file of the template. " +
+					"The variables of the incarnation which are not set by the resource, like the ones managed by " +
+					"`foxops_incarnation_template_data` resources, are kept by the updates.",
 				ElementType: types.StringType,
 				Optional:    true,
 			},
-			"template_repository": schema.StringAttribute{
-				MarkdownDescription: "The repository containing the template used to create the incarnation.",
-				Required:            true,
-				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplace(),
+			"template_data_all": schema.MapAttribute{
+				MarkdownDescription: "The variables used to generate the incarnation: `template_data` merged with " +
+					"`template_data_json`, `template_data_files` and the `template_data` of the provider `defaults` block, " +
+					"excluding the sensitive variables.",
+				ElementType: types.StringType,
+				Computed:    true,
+			},
+			"template_data_json": schema.StringAttribute{
+				MarkdownDescription: "A JSON object, like the result of `jsonencode()`, holding variables used to generate the incarnation. " +
+					"Unlike `template_data`, its values keep their types: integers and numbers are sent as such to Foxops, " +
+					"except for the numbers that Foxops cannot store exactly, which are sent as strings, " +
+					"booleans as `true` or `false` and lists and objects encoded in JSON. Null values are ignored with a warning. " +
+					"The variables of `template_data` take precedence over it.",
+				Optional: true,
+			},
+			"template_data_files": schema.ListAttribute{
+				MarkdownDescription: "The paths of YAML or JSON files holding objects of variables used to generate the incarnation, " +
+					"like the variables of an environment. The files are merged in order, the variables of a file taking precedence over the previous files, " +
+					"and `template_data_json` and `template_data` take precedence over them. Their values keep their types like in `template_data_json`.",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"template_data_sources": schema.MapAttribute{
+				MarkdownDescription: "The source of each variable of `template_data_all`: `template_data`, `template_data_json`, " +
+					"the path of one of `template_data_files` or `defaults` for the provider `defaults` block.",
+				ElementType: types.StringType,
+				Computed:    true,
+			},
+			"template_spec_file": schema.StringAttribute{
+				MarkdownDescription: "The path of a local copy of the `fengine.yaml` file of the template. " +
+					"When set, the template data is validated against the declared variables at plan time: " +
+					"unknown variables, missing required variables and values which do not match the type of their variable are reported.",
+				Optional: true,
+			},
+			"template_variables": schema.MapNestedAttribute{
+				MarkdownDescription: "The variables declared in `template_spec_file`, by name.",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"type": schema.StringAttribute{
+							MarkdownDescription: "The type of the variable, like `str`, `int`, `float`, `bool`, `list` or `object`.",
+							Computed:            true,
+						},
+						"description": schema.StringAttribute{
+							MarkdownDescription: "The description of the variable.",
+							Computed:            true,
+						},
+						"default": schema.StringAttribute{
+							MarkdownDescription: "The default value of the variable. Lists and objects are encoded in JSON.",
+							Computed:            true,
+						},
+						"required": schema.BoolAttribute{
+							MarkdownDescription: "Whether the variable must be set, which is the case when it has no default value.",
+							Computed:            true,
+						},
+					},
 				},
 			},
+			"effective_template_data": schema.MapAttribute{
+				MarkdownDescription: "The variables of the incarnation as stored by Foxops, excluding the sensitive variables. " +
+					"It includes the defaults filled in by Foxops from the `fengine.yaml` file of the template " +
+					"for the variables which are not set in `template_data`.",
+				ElementType: types.StringType,
+				Computed:    true,
+			},
+			"sensitive_template_data": schema.MapAttribute{
+				MarkdownDescription: "Variables used to generate the incarnation whose values must not be displayed in the plan output. " +
+					"They are merged with `template_data` and their values are redacted from the provider logs.",
+				ElementType: types.StringType,
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"sensitive_template_data_wo": schema.MapAttribute{
+				MarkdownDescription: "Variables used to generate the incarnation which are never stored in the plan or the state. " +
+					"They are merged with `template_data` and `sensitive_template_data`. Requires Terraform 1.11 or later.",
+				ElementType: types.StringType,
+				Optional:    true,
+				Sensitive:   true,
+				WriteOnly:   true,
+			},
+			"sensitive_template_data_hashes": schema.MapAttribute{
+				MarkdownDescription: "The salted PBKDF2-SHA256 hashes of the values of `sensitive_template_data` and `sensitive_template_data_wo`. " +
+					"They are used to detect changes of these values in Foxops. The hashes of changed values are known after apply.",
+				ElementType: types.StringType,
+				Computed:    true,
+			},
+			"template_repository": schema.StringAttribute{
+				CustomType: templateRepositoryType,
+				MarkdownDescription: "The repository containing the template used to create the incarnation. " +
+					"The `.git` suffix and a trailing slash are ignored when comparing values. " +
+					"Required unless set in the provider `defaults` block.",
+				Optional: true,
+				Computed: true,
+			},
 			"template_repository_version": schema.StringAttribute{
-				MarkdownDescription: "A tag, commit or branch of the template repository to use for the incarnation.",
-				Required:            true,
+				MarkdownDescription: "A tag, commit or branch of the template repository to use for the incarnation. " +
+					"Exactly one of `template_repository_version` and `template_repository_version_constraint` must be set. " +
+					"When `template_repository_version_constraint` is set, it holds the resolved version.",
+				Optional: true,
+				Computed: true,
+				Validators: []validator.String{
+					stringvalidator.ExactlyOneOf(path.MatchRoot("template_repository_version_constraint")),
+				},
+			},
+			"template_repository_version_constraint": schema.StringAttribute{
+				MarkdownDescription: "A version constraint, like `~> 1.2` or `>= 1.2.0, < 2.0.0`, resolved at plan time " +
+					"to the newest tag of the template repository matching it. The tags are listed with `git ls-remote`, " +
+					"using the git credentials of the machine running Terraform, and the template repository must be an http(s), ssh or scp-like url, or a local one with the provider `allow_local_template_repositories` setting. " +
+					"Tags which are not semantic versions are ignored.",
+				Optional: true,
+			},
+			"prevent_downgrade": schema.BoolAttribute{
+				MarkdownDescription: "Whether plans moving the incarnation to an older `template_repository_version` are rejected. " +
+					"Versions which are not semantic versions, like branches or commits, are not compared. Default: `false`.",
+				Optional: true,
 			},
 			"auto_merge_on_update": schema.BoolAttribute{
 				MarkdownDescription: "Whether merge request should automatically merged after update of the incarnation.",
 				Optional:            true,
 			},
+			"deletion_protection": schema.BoolAttribute{
+				MarkdownDescription: "Whether Terraform is prevented from destroying or replacing the incarnation. " +
+					"It must be set to `false` and applied before the incarnation can be destroyed. Default: `false`.",
+				Optional: true,
+			},
+			"rollback_on_failure": schema.BoolAttribute{
+				MarkdownDescription: "Whether the incarnation is reset to the previous `template_repository_version` and template data " +
+					"when the merge request of an update is closed or does not reach the status awaited by " +
+					"`wait_for_mr_status_on_update` in time. The reset opens a new merge request and the update is reported as failed. " +
+					"A merge request which timed out is left open and must be closed by hand, so that it is not merged later. " +
+					"The previous values of `sensitive_template_data_wo` are not known, their current values are kept. Default: `false`.",
+				Optional: true,
+			},
+			"on_destroy": schema.StringAttribute{
+				MarkdownDescription: "What to do when the incarnation is destroyed. " +
+					"Destroying an incarnation only removes it from the Foxops inventory, the files rendered in the incarnation repository are left in place. " +
+					"With `forget`, the incarnation is removed right away. " +
+					"With `fail_if_open_mr`, destroying the incarnation fails while its last merge request is open. " +
+					"With `wait_for_open_mr`, the last merge request is waited for until it is merged or closed, up to `on_destroy_timeout`. " +
+					"Default: `forget`.",
+				Optional: true,
+				Validators: []validator.String{
+					stringvalidator.OneOf(
+						onDestroyForget,
+						onDestroyFailIfOpenMR,
+						onDestroyWaitForOpenMR,
+					),
+				},
+			},
+			"on_destroy_timeout": schema.StringAttribute{
+				MarkdownDescription: "The amount of time to wait for the last merge request when `on_destroy` is `wait_for_open_mr`. " +
+					"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). " +
+					"Example: `1m30s`. Default: `10m`.",
+				Optional: true,
+				Validators: []validator.String{
+					durationValidator,
+				},
+			},
 			"merge_request_url": schema.StringAttribute{
 				MarkdownDescription: "The url of the latest merge request created for the incarnation. " +
 					"This property will be `null` after the creation of the incarnation and only populated after updates.",
@@ -147,12 +354,45 @@ func (r *incarnationResource) Schema(_ context.Context, _ resource.SchemaRequest
 					"This property will be `null` after the creation of the incarnation and only populated after updates.",
 				Computed: true,
 			},
-			"wait_for_mr_status_on_update": waitForSchema,
+			"wait_for_mr_status_on_update": waitForOnUpdateSchema,
+			"wait_for_pipeline": schema.SingleNestedAttribute{
+				MarkdownDescription: "Wait for the pipeline of the commit of the last merge request to succeed or fail before completing an update. " +
+					"The update fails when the pipeline fails. It runs after `wait_for_mr_status_on_update` and requires the forge hosting " +
+					"the incarnation repository to be configured in the `gitlab` or `github` block of the provider. " +
+					"On GitLab, the jobs of the commit are taken into account; on GitHub, both its commit statuses and its check runs, like the GitHub Actions jobs. " +
+					"A commit for which nothing is reported is waited for until the `timeout`, which then reports it.",
+				Optional: true,
+				Attributes: map[string]schema.Attribute{
+					"timeout": schema.StringAttribute{
+						MarkdownDescription: "The amount of time to wait for the pipeline. " +
+							"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). " +
+							"Example: `1m30s`. Default: `30m`.",
+						Optional: true,
+						Validators: []validator.String{
+							durationValidator,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (r *incarnationResource) IdentitySchema(_ context.Context, _ resource.IdentitySchemaRequest, resp *resource.IdentitySchemaResponse) {
+	resp.IdentitySchema = identityschema.Schema{
+		Attributes: map[string]identityschema.Attribute{
+			"id": identityschema.StringAttribute{
+				Description:       "The id of the incarnation.",
+				RequiredForImport: true,
+			},
 		},
 	}
 }
 
 func (r *incarnationResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation.Read")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
 	var data incarnationResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
@@ -165,6 +405,10 @@ func (r *incarnationResource) Read(ctx context.Context, req resource.ReadRequest
 	}
 
 	id := IncarnationId(data.Id.ValueString())
+	resp.Diagnostics.Append(setIncarnationIdentity(ctx, resp.Identity, id)...)
+
+	sensitiveKeys, _ := stringMapElements(data.SensitiveTemplateDataHash)
+	ctx = helpers.WithSensitiveTemplateDataKeys(ctx, sortedKeys(sensitiveKeys)...)
 
 	if data.WaitForMRStatus == nil {
 		inc, err := r.client.GetIncarnation(ctx, id)
@@ -176,65 +420,117 @@ func (r *incarnationResource) Read(ctx context.Context, req resource.ReadRequest
 			resp.Diagnostics.AddError("failed to retrieve incarnation", err.Error())
 			return
 		}
-		resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc)...)
+		resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc, data, currentSensitiveTemplateData(inc, sensitiveKeys))...)
 		return
 	}
 
 	var inc Incarnation
 	var diags diag.Diagnostics
-	inc, diags = getIncarnation(ctx, r.client, id, data.WaitForMRStatus)
+	inc, _, diags = getIncarnation(ctx, r.client, id, data.WaitForMRStatus)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc)...)
+	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc, data, currentSensitiveTemplateData(inc, sensitiveKeys))...)
 }
 
 func (r *incarnationResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation.Create")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
 	var data incarnationResourceModel
-	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var writeOnly types.Map
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("sensitive_template_data_wo"), &writeOnly)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	sensitive, _ := sensitiveTemplateData(data.SensitiveTemplateData, writeOnly)
+	ctx = helpers.WithSensitiveTemplateDataKeys(ctx, sortedKeys(sensitive)...)
+
+	var targetDirectory *string
+	if !data.TargetDirectory.IsUnknown() {
+		targetDirectory = data.TargetDirectory.ValueStringPointer()
+	}
+
+	templateData, diags := typedTemplateData(ctx, data, sensitive)
+	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
 	createIncarnationRequest := CreateIncarnationRequest{
 		IncarnationRepository: data.IncarnationRepository.ValueString(),
-		TargetDirectory:       data.TargetDirectory.ValueStringPointer(),
+		TargetDirectory:       targetDirectory,
 		TemplateRepository:    data.TemplateRepository.ValueString(),
 		UpdateIncarnationRequest: UpdateIncarnationRequest{
-			TemplateData:              map[string]interface{}{},
+			TemplateData:              templateData,
 			TemplateRepositoryVersion: data.TemplateRepositoryVersion.ValueString(),
 		},
 	}
 
-	if !data.TemplateData.IsNull() {
-		for key, value := range data.TemplateData.Elements() {
-			if value, ok := value.(types.String); ok {
-				createIncarnationRequest.TemplateData[key] = value.ValueString()
-			}
-		}
-	}
-
 	inc, err := r.client.CreateIncarnation(ctx, createIncarnationRequest)
 	if err != nil {
 		resp.Diagnostics.AddError("failed to create incarnation", err.Error())
 		return
 	}
+	defer func() { resp.Diagnostics.Append(notificationWarnings(r.client, inc.Id)...) }()
+	resp.Diagnostics.Append(setIncarnationIdentity(ctx, resp.Identity, inc.Id)...)
+	resp.Diagnostics.Append(setFilledInTemplateDataKeys(ctx, resp.Private, inc, createIncarnationRequest.TemplateData)...)
 
-	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc)...)
+	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc, data, sensitive)...)
 }
 
 func (r *incarnationResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation.Update")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
 	var data incarnationResourceModel
 	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
+	var state incarnationResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// States written before the resource had an identity have none yet.
+	resp.Diagnostics.Append(setIncarnationIdentity(ctx, resp.Identity, IncarnationId(state.Id.ValueString()))...)
+
+	// The plan holds the values produced by the last update, see
+	// planUpdateResults.
+	if !updatesIncarnation(data, state) {
+		resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+		return
+	}
+
+	var writeOnly types.Map
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("sensitive_template_data_wo"), &writeOnly)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	sensitive, _ := sensitiveTemplateData(data.SensitiveTemplateData, writeOnly)
+	ctx = helpers.WithSensitiveTemplateDataKeys(ctx, sortedKeys(sensitive)...)
+
+	templateData, diags := typedTemplateData(ctx, data, sensitive)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	updateIncarnationRequest := UpdateIncarnationRequest{
 		AutoMerge:                 true,
-		TemplateData:              map[string]interface{}{},
+		TemplateData:              templateData,
 		TemplateRepositoryVersion: data.TemplateRepositoryVersion.ValueString(),
 	}
 
@@ -242,64 +538,282 @@ func (r *incarnationResource) Update(ctx context.Context, req resource.UpdateReq
 		updateIncarnationRequest.AutoMerge = data.AutoMerge.ValueBool()
 	}
 
-	if !data.TemplateData.IsNull() {
-		for key, value := range data.TemplateData.Elements() {
-			if value, ok := value.(types.String); ok {
-				updateIncarnationRequest.TemplateData[key] = value.ValueString()
-			}
-		}
+	id := IncarnationId(data.Id.ValueString())
+	unlock := r.locks.lock(id)
+	current, err := getFreshIncarnation(ctx, r.client, id)
+	if err != nil {
+		unlock()
+		resp.Diagnostics.AddError("failed to retrieve incarnation", err.Error())
+		return
+	}
+
+	unmanaged, diags := unmanagedTemplateData(ctx, req.Private, current, state, updateIncarnationRequest.TemplateData)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		unlock()
+		return
+	}
+	for key, value := range unmanaged {
+		updateIncarnationRequest.TemplateData[key] = value
 	}
 
-	inc, err := r.client.UpdateIncarnation(ctx, IncarnationId(data.Id.ValueString()), updateIncarnationRequest)
+	inc, err := r.client.UpdateIncarnation(withPreviousIncarnation(ctx, current), id, updateIncarnationRequest)
+	unlock()
 	if err != nil {
 		resp.Diagnostics.AddError("failed to update incarnation", err.Error())
 		return
 	}
+	// The notifications of the update and of its rollback are reported last.
+	defer func() { resp.Diagnostics.Append(notificationWarnings(r.client, id)...) }()
+	resp.Diagnostics.Append(setFilledInTemplateDataKeys(ctx, resp.Private, inc, updateIncarnationRequest.TemplateData)...)
 
-	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc)...)
+	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc, data, sensitive)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	var diags diag.Diagnostics
-	inc, diags = getIncarnation(ctx, r.client, inc.Id, data.WaitForMRStatus)
+	updated := inc
+	inc, timedOut, diags := getIncarnation(ctx, r.client, inc.Id, data.WaitForMRStatus)
+	if reason := updateFailure(inc, timedOut, data.WaitForMRStatus); data.RollbackOnFailure.ValueBool() && reason != "" {
+		writeOnlyValues, _ := stringMapElements(writeOnly)
+		resp.Diagnostics.Append(r.rollback(ctx, &resp.State, updated, reason, state, writeOnlyValues, unmanaged)...)
+		return
+	}
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc)...)
+	resp.Diagnostics.Append(r.setState(ctx, &resp.State, inc, data, sensitive)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+
+	if data.WaitForPipeline != nil {
+		timeout := defaultPipelineTimeout
+		if !data.WaitForPipeline.Timeout.IsNull() {
+			timeout, err = time.ParseDuration(data.WaitForPipeline.Timeout.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddError("invalid timeout", err.Error())
+				return
+			}
+		}
+		resp.Diagnostics.Append(waitForPipeline(ctx, r.forges, inc, timeout)...)
+	}
+}
+
+// updateFailure tells why the merge request of an update failed: it was
+// closed, or it did not reach the awaited status in time. It is empty when the
+// update did not fail.
+func updateFailure(inc Incarnation, timedOut bool, waitForStatus *waitForStatusMRModel) string {
+	switch {
+	case waitForStatus == nil:
+		return ""
+	case timedOut:
+		return fmt.Sprintf("did not reach the status %s within the timeout and is left open: "+
+			"close it so that the failed update is not merged later", waitForStatus.Status.ValueString())
+	case inc.MergeRequestStatus != nil && *inc.MergeRequestStatus == "closed" && waitForStatus.Status.ValueString() != "closed":
+		return "was closed"
+	}
+	return ""
+}
+
+// rollback resets the incarnation to the template version and data of the
+// prior state after the merge request of an update failed. The prior values
+// of the write-only template data are not known, their current values are
+// kept, like the template data not managed by the resource. The merge request
+// which timed out is left open, as the provider cannot close it.
+func (r *incarnationResource) rollback(
+	ctx context.Context,
+	setter incarnationStateSetter,
+	updated Incarnation,
+	reason string,
+	state incarnationResourceModel,
+	writeOnly map[string]string,
+	unmanaged map[string]interface{},
+) (diags diag.Diagnostics) {
+	sensitive, _ := stringMapElements(state.SensitiveTemplateData)
+	templateData, diags := typedTemplateData(ctx, state, sensitive)
+	if diags.HasError() {
+		return
+	}
+	for key, value := range writeOnly {
+		if _, ok := templateData[key]; !ok {
+			templateData[key] = value
+		}
+	}
+	for key, value := range unmanaged {
+		if _, ok := templateData[key]; !ok {
+			templateData[key] = value
+		}
+	}
+
+	version := state.TemplateRepositoryVersion.ValueString()
+	failed := mergeRequestReference(updated)
+	if failed == "" {
+		failed = "(unknown)"
+	}
+
+	tflog.Info(ctx, "rolling back the incarnation", map[string]interface{}{
+		"id":      updated.Id,
+		"version": version,
+	})
+	result, err := r.client.ResetIncarnation(withPreviousIncarnation(ctx, updated), updated.Id, ResetIncarnationRequest{
+		OverrideVersion:      &version,
+		OverrideTemplateData: templateData,
+	})
+	if err != nil {
+		diags.AddError(
+			"Unable to roll back the incarnation",
+			fmt.Sprintf("The merge request %s updating the incarnation %s %s, and resetting it to the version %s failed: %s",
+				failed, updated.Id, reason, version, err.Error()),
+		)
+		return
+	}
+
+	detail := fmt.Sprintf("The merge request %s updating the incarnation %s %s. "+
+		"The incarnation is reset to the version %s by the merge request %s.",
+		failed, updated.Id, reason, version, result.MergeRequestUrl)
+
+	diags.Append(setter.Set(ctx, state)...)
+	diags.AddError("Incarnation update rolled back", detail)
+	return
 }
 
 func (r *incarnationResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation.Delete")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
 	var data incarnationResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	err := r.client.DeleteIncarnation(ctx, IncarnationId(data.Id.ValueString()))
+	id := IncarnationId(data.Id.ValueString())
+
+	if data.DeletionProtection.ValueBool() {
+		resp.Diagnostics.AddError(
+			"incarnation is protected against deletion",
+			fmt.Sprintf("Set deletion_protection to false and apply it before destroying the incarnation %s.", id),
+		)
+		return
+	}
+
+	switch data.OnDestroy.ValueString() {
+	case onDestroyFailIfOpenMR:
+		inc, err := getFreshIncarnation(ctx, r.client, id)
+		if errors.Is(err, ErrNotFound) {
+			return
+		}
+		if err != nil {
+			resp.Diagnostics.AddError("failed to retrieve incarnation", err.Error())
+			return
+		}
+		if hasOpenMergeRequest(inc) {
+			resp.Diagnostics.AddError(
+				"incarnation has an open merge request",
+				fmt.Sprintf("The merge request %s of the incarnation %s must be merged or closed before destroying it.", mergeRequestReference(inc), id),
+			)
+			return
+		}
+	case onDestroyWaitForOpenMR:
+		timeout := defaultOnDestroyTimeout
+		if !data.OnDestroyTimeout.IsNull() {
+			var err error
+			timeout, err = time.ParseDuration(data.OnDestroyTimeout.ValueString())
+			if err != nil {
+				resp.Diagnostics.AddError("invalid timeout", err.Error())
+				return
+			}
+		}
+		resp.Diagnostics.Append(waitForOpenMergeRequest(ctx, r.client, id, timeout)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	previous := Incarnation{
+		Id:                        id,
+		IncarnationRepository:     data.IncarnationRepository.ValueString(),
+		TargetDirectory:           data.TargetDirectory.ValueString(),
+		TemplateRepository:        data.TemplateRepository.ValueString(),
+		TemplateRepositoryVersion: data.TemplateRepositoryVersion.ValueString(),
+	}
+	err := r.client.DeleteIncarnation(withPreviousIncarnation(ctx, previous), id)
+	if errors.Is(err, ErrNotFound) {
+		tflog.Info(ctx, "incarnation already deleted", map[string]interface{}{"id": id})
+		return
+	}
 	if err != nil {
 		resp.Diagnostics.AddError("failed to delete incarnation", err.Error())
 		return
 	}
+	resp.Diagnostics.Append(notificationWarnings(r.client, id)...)
 }
 
+// ImportState imports an incarnation by id or by identity, or by incarnation
+// repository and target directory with an id built by the
+// incarnation_import_id function.
 func (r *incarnationResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+	repository, directory, ok := cutIncarnationImportId(req.ID)
+	if !ok {
+		resource.ImportStatePassthroughWithIdentity(ctx, path.Root("id"), path.Root("id"), req, resp)
+	} else {
+		slot := newIncarnationSlot(repository, directory)
+		incs, err := r.client.ListIncarnations(ctx, ListIncarnationsRequest{IncarnationRepository: &repository})
+		if err != nil {
+			resp.Diagnostics.AddError("failed to list incarnations", err.Error())
+			return
+		}
+		var id IncarnationId
+		for _, inc := range incs {
+			if newIncarnationSlot(inc.IncarnationRepository, inc.TargetDirectory) == slot {
+				id = inc.Id
+				break
+			}
+		}
+		if id == "" {
+			resp.Diagnostics.AddError(
+				"Incarnation not found",
+				fmt.Sprintf("No incarnation targets the directory %q of %s.", slot.targetDirectory, repository),
+			)
+			return
+		}
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), string(id))...)
+		resp.Diagnostics.Append(setIncarnationIdentity(ctx, resp.Identity, id)...)
+	}
+
+	// All of the template data of an imported incarnation is managed.
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, filledInTemplateDataKey, []byte("[]"))...)
+}
+
+// setIncarnationIdentity stores the id of an incarnation in its identity.
+func setIncarnationIdentity(ctx context.Context, identity *tfsdk.ResourceIdentity, id IncarnationId) diag.Diagnostics {
+	if identity == nil {
+		return nil
+	}
+	return identity.Set(ctx, incarnationIdentityModel{Id: types.StringValue(string(id))})
 }
 
-func (r *incarnationResource) setState(ctx context.Context, setter incarnationStateSetter, inc Incarnation) (diags diag.Diagnostics) {
+// setState stores the incarnation returned by Foxops in the state. The values
+// of the sensitive keys are kept out of template_data and only their hashes
+// are stored. The settings which are not stored in Foxops, like
+// sensitive_template_data, are carried over from prior.
+func (r *incarnationResource) setState(
+	ctx context.Context,
+	setter incarnationStateSetter,
+	inc Incarnation,
+	prior incarnationResourceModel,
+	sensitive map[string]string,
+) (diags diag.Diagnostics) {
 	var data incarnationResourceModel
 
 	data.Id = types.StringValue(string(inc.Id))
 	data.IncarnationRepository = types.StringValue(inc.IncarnationRepository)
 	data.TemplateRepositoryVersion = types.StringValue(inc.TemplateRepositoryVersion)
-	data.TemplateRepository = types.StringValue(inc.TemplateRepository)
-	data.TargetDirectory = types.StringValue(inc.TargetDirectory)
+	data.TemplateRepository = newTemplateRepositoryValue(inc.TemplateRepository)
+	data.TargetDirectory = newTargetDirectoryValue(inc.TargetDirectory)
 	data.CommitSha = types.StringValue(inc.CommitSha)
 	data.CommitUrl = types.StringValue(inc.CommitUrl)
 
@@ -317,18 +831,61 @@ func (r *incarnationResource) setState(ctx context.Context, setter incarnationSt
 
 	templateData := map[string]string{}
 	for key, value := range inc.TemplateData {
-		switch value := value.(type) {
-		case string:
+		if _, ok := sensitive[key]; ok {
+			continue
+		}
+		if value, ok := templateDatumString(value); ok {
 			templateData[key] = value
-		case int:
-			templateData[key] = fmt.Sprintf("%d", value)
-		case float64:
-			templateData[key] = fmt.Sprintf("%f", value)
 		}
 	}
-	data.TemplateData, diags = types.MapValueFrom(ctx, types.StringType, templateData)
+	data.EffectiveTemplateData, diags = types.MapValueFrom(ctx, types.StringType, templateData)
+	if diags.HasError() {
+		return
+	}
+
+	// Only the keys set in the configuration are stored in template_data, the
+	// values filled in by Foxops are only stored in effective_template_data.
+	if prior.TemplateDataAll.IsNull() {
+		// The incarnation was imported, all of its template data is managed.
+		data.TemplateData = data.EffectiveTemplateData
+		data.TemplateDataAll = data.EffectiveTemplateData
+		data.TemplateDataSources = templateDataSources(data.TemplateDataAll, data.TemplateData, structuredTemplateData{})
+	} else {
+		data.TemplateData, diags = selectTemplateData(ctx, templateData, prior.TemplateData)
+		if diags.HasError() {
+			return
+		}
+		data.TemplateDataAll, diags = selectTemplateData(ctx, templateData, prior.TemplateDataAll)
+		if diags.HasError() {
+			return
+		}
+	}
 
-	diags.Append(diags...)
+	data.WaitForMRStatus = prior.WaitForMRStatus
+	data.AutoMerge = prior.AutoMerge
+	data.DeletionProtection = prior.DeletionProtection
+	data.RollbackOnFailure = prior.RollbackOnFailure
+	data.WaitForPipeline = prior.WaitForPipeline
+	data.OnDestroy = prior.OnDestroy
+	data.OnDestroyTimeout = prior.OnDestroyTimeout
+	data.SensitiveTemplateData = prior.SensitiveTemplateData
+	data.TemplateDataJSON = prior.TemplateDataJSON
+	data.TemplateDataFiles = prior.TemplateDataFiles
+	if data.TemplateDataFiles.IsNull() {
+		data.TemplateDataFiles = types.ListNull(types.StringType)
+	}
+	if !prior.TemplateDataAll.IsNull() {
+		data.TemplateDataSources = prior.TemplateDataSources
+	}
+	data.TemplateSpecFile = prior.TemplateSpecFile
+	data.TemplateVersionConstraint = prior.TemplateVersionConstraint
+	data.PreventDowngrade = prior.PreventDowngrade
+	data.TemplateVariables = prior.TemplateVariables
+	if data.TemplateVariables.IsNull() {
+		data.TemplateVariables = types.MapNull(templateVariableType)
+	}
+	data.SensitiveTemplateDataWO = types.MapNull(types.StringType)
+	data.SensitiveTemplateDataHash, diags = sensitiveTemplateDataHashes(ctx, sensitive, prior.SensitiveTemplateDataHash)
 	if diags.HasError() {
 		return
 	}
@@ -337,3 +894,512 @@ func (r *incarnationResource) setState(ctx context.Context, setter incarnationSt
 
 	return
 }
+
+func (r *incarnationResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var data incarnationResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	templateData := data.TemplateData.Elements()
+	sensitive := data.SensitiveTemplateData.Elements()
+	for key := range data.SensitiveTemplateDataWO.Elements() {
+		if _, ok := templateData[key]; ok {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("sensitive_template_data_wo").AtMapKey(key),
+				"Duplicate template data key",
+				fmt.Sprintf("The key %q is also set in template_data.", key),
+			)
+		}
+		if _, ok := sensitive[key]; ok {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("sensitive_template_data_wo").AtMapKey(key),
+				"Duplicate template data key",
+				fmt.Sprintf("The key %q is also set in sensitive_template_data.", key),
+			)
+		}
+	}
+	for key := range sensitive {
+		if _, ok := templateData[key]; ok {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("sensitive_template_data").AtMapKey(key),
+				"Duplicate template data key",
+				fmt.Sprintf("The key %q is also set in template_data.", key),
+			)
+		}
+	}
+}
+
+func (r *incarnationResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() {
+		var state incarnationResourceModel
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		if state.DeletionProtection.ValueBool() {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("deletion_protection"),
+				"Incarnation is protected against deletion",
+				fmt.Sprintf("Set deletion_protection to false and apply it before destroying the incarnation %s.", state.Id.ValueString()),
+			)
+		}
+		return
+	}
+
+	var config incarnationResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &config)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var state *incarnationResourceModel
+	if !req.State.Raw.IsNull() {
+		state = &incarnationResourceModel{}
+		resp.Diagnostics.Append(req.State.Get(ctx, state)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	resp.Diagnostics.Append(r.applyDefaults(ctx, config, state, resp)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.planTemplateRepositoryVersion(ctx, config, state, resp)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if state == nil || len(resp.RequiresReplace) > 0 {
+		resp.Diagnostics.Append(r.checkRepositoryPolicies(ctx, resp)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	if state != nil && len(resp.RequiresReplace) > 0 {
+		if state.DeletionProtection.ValueBool() {
+			resp.Diagnostics.AddAttributeError(
+				path.Root("deletion_protection"),
+				"Incarnation is protected against deletion",
+				fmt.Sprintf(
+					"The incarnation %s must be replaced to change %s. Set deletion_protection to false and apply it first.",
+					state.Id.ValueString(),
+					resp.RequiresReplace,
+				),
+			)
+			return
+		}
+		resp.Diagnostics.AddWarning(
+			"Replacing an incarnation does not clean up its repository",
+			fmt.Sprintf(
+				"The incarnation %s is removed from the Foxops inventory, but the files rendered in the directory %q of %s are left in place. "+
+					"The new incarnation is created next to them.",
+				state.Id.ValueString(),
+				state.TargetDirectory.ValueString(),
+				state.IncarnationRepository.ValueString(),
+			),
+		)
+	}
+
+	if state == nil || len(resp.RequiresReplace) > 0 {
+		var targetDirectory normalizedStringValue
+		resp.Diagnostics.Append(resp.Plan.GetAttribute(ctx, path.Root("target_directory"), &targetDirectory)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		if config.TargetDirectory.IsNull() && r.defaults.TargetDirectory.IsNull() {
+			// Foxops renders the incarnation at the root of the repository.
+			targetDirectory = newTargetDirectoryValue(".")
+		}
+		var replaced IncarnationId
+		if state != nil {
+			replaced = IncarnationId(state.Id.ValueString())
+		}
+		resp.Diagnostics.Append(checkIncarnationSlot(ctx, r.client, r.slots, config.IncarnationRepository, targetDirectory, replaced)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	hashes := types.MapUnknown(types.StringType)
+	sensitive, known := sensitiveTemplateData(config.SensitiveTemplateData, config.SensitiveTemplateDataWO)
+	if known {
+		stateHashes := types.MapNull(types.StringType)
+		if state != nil {
+			stateHashes = state.SensitiveTemplateDataHash
+		}
+		hashes = plannedSensitiveTemplateDataHashes(sensitive, stateHashes)
+	}
+
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("sensitive_template_data_hashes"), hashes)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	var templateDataAll types.Map
+	resp.Diagnostics.Append(resp.Plan.GetAttribute(ctx, path.Root("template_data_all"), &templateDataAll)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	templateVariables, diags := planTemplateVariables(ctx, config.TemplateSpecFile, []templateDataSource{
+		{attribute: path.Root("template_data"), values: config.TemplateData},
+		{attribute: path.Root("template_data_all"), values: defaultTemplateData(templateDataAll, config.TemplateData)},
+		{attribute: path.Root("sensitive_template_data"), values: config.SensitiveTemplateData, sensitive: true},
+		{attribute: path.Root("sensitive_template_data_wo"), values: config.SensitiveTemplateDataWO, sensitive: true},
+	})
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.Plan.SetAttribute(ctx, path.Root("template_variables"), templateVariables)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(planUpdateResults(ctx, state, resp)...)
+}
+
+// currentSensitiveTemplateData returns the values stored in Foxops for the
+// sensitive keys.
+func currentSensitiveTemplateData(inc Incarnation, keys map[string]string) map[string]string {
+	result := map[string]string{}
+	for key := range keys {
+		if value, ok := templateDatumString(inc.TemplateData[key]); ok {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// filledInTemplateDataKey is the key of the private state holding the keys of
+// the template data filled in by Foxops on the last create or update.
+const filledInTemplateDataKey = "filled_in_template_data_keys"
+
+type privateState interface {
+	GetKey(ctx context.Context, key string) ([]byte, diag.Diagnostics)
+	SetKey(ctx context.Context, key string, value []byte) diag.Diagnostics
+}
+
+// setFilledInTemplateDataKeys records the keys of the template data of the
+// incarnation which were not sent to Foxops.
+func setFilledInTemplateDataKeys(ctx context.Context, private privateState, inc Incarnation, sent map[string]interface{}) diag.Diagnostics {
+	keys := []string{}
+	for key := range inc.TemplateData {
+		if _, ok := sent[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	value, err := json.Marshal(keys)
+	if err != nil {
+		var diags diag.Diagnostics
+		diags.AddError("failed to record the template data filled in by Foxops", err.Error())
+		return diags
+	}
+	return private.SetKey(ctx, filledInTemplateDataKey, value)
+}
+
+// unmanagedTemplateData returns the current template data of the incarnation
+// which is not managed by the resource, like the keys of the
+// foxops_incarnation_template_data resources, so that an update keeps it. The
+// keys filled in by Foxops are left out for Foxops to fill them in again. For
+// the states written before these keys were recorded, every key known at the
+// last refresh is taken as filled in.
+func unmanagedTemplateData(
+	ctx context.Context,
+	private privateState,
+	current Incarnation,
+	state incarnationResourceModel,
+	sent map[string]interface{},
+) (map[string]interface{}, diag.Diagnostics) {
+	value, diags := private.GetKey(ctx, filledInTemplateDataKey)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	filledIn := map[string]bool{}
+	if value == nil {
+		for key := range state.EffectiveTemplateData.Elements() {
+			filledIn[key] = true
+		}
+	} else {
+		var keys []string
+		if err := json.Unmarshal(value, &keys); err != nil {
+			diags.AddError("failed to read the template data filled in by Foxops", err.Error())
+			return nil, diags
+		}
+		for _, key := range keys {
+			filledIn[key] = true
+		}
+	}
+
+	managed := state.TemplateDataAll.Elements()
+	hashes := state.SensitiveTemplateDataHash.Elements()
+	result := map[string]interface{}{}
+	for key, value := range current.TemplateData {
+		if _, ok := sent[key]; ok || filledIn[key] {
+			continue
+		}
+		if _, ok := managed[key]; ok {
+			continue
+		}
+		if _, ok := hashes[key]; ok {
+			continue
+		}
+		result[key] = value
+	}
+	return result, diags
+}
+
+// applyDefaults merges the provider defaults into the plan, so that the plan
+// shows the values sent to Foxops. As the defaults can change the template
+// repository and the target directory, the replacement of the incarnation is
+// decided here rather than by plan modifiers, which the framework only applies
+// after ModifyPlan. The incarnation repository has no default but is decided
+// here as well, so that every replacement is known to the checks of ModifyPlan.
+func (r *incarnationResource) applyDefaults(
+	ctx context.Context,
+	config incarnationResourceModel,
+	state *incarnationResourceModel,
+	resp *resource.ModifyPlanResponse,
+) (diags diag.Diagnostics) {
+	if state != nil && !config.IncarnationRepository.Equal(state.IncarnationRepository) {
+		resp.RequiresReplace = append(resp.RequiresReplace, path.Root("incarnation_repository"))
+	}
+
+	templateRepository := config.TemplateRepository
+	if templateRepository.IsNull() {
+		templateRepository = r.defaults.TemplateRepository
+	}
+	if templateRepository.IsNull() {
+		diags.AddAttributeError(
+			path.Root("template_repository"),
+			"Missing template repository",
+			"The template repository must be set either on the resource or in the defaults block of the provider.",
+		)
+		return
+	}
+	if state != nil {
+		templateRepository = planReplaceable(config.TemplateRepository, templateRepository, state.TemplateRepository, path.Root("template_repository"), resp)
+	}
+	diags.Append(resp.Plan.SetAttribute(ctx, path.Root("template_repository"), templateRepository)...)
+
+	targetDirectory := config.TargetDirectory
+	if targetDirectory.IsNull() {
+		targetDirectory = r.defaults.TargetDirectory
+	}
+	if !targetDirectory.IsNull() {
+		if state != nil {
+			targetDirectory = planReplaceable(config.TargetDirectory, targetDirectory, state.TargetDirectory, path.Root("target_directory"), resp)
+		}
+		diags.Append(resp.Plan.SetAttribute(ctx, path.Root("target_directory"), targetDirectory)...)
+	} else if state != nil {
+		diags.Append(resp.Plan.SetAttribute(ctx, path.Root("target_directory"), state.TargetDirectory)...)
+	}
+
+	structured, known, d := loadStructuredTemplateData(ctx, config.TemplateDataFiles, config.TemplateDataJSON)
+	diags.Append(d...)
+	if diags.HasError() {
+		return
+	}
+	structuredValues := types.MapUnknown(types.StringType)
+	if known {
+		structuredValues = mergeTemplateData(
+			types.MapValueMust(types.StringType, stringMapValues(structured.strings)),
+			types.MapNull(types.StringType),
+			config.SensitiveTemplateData,
+			config.SensitiveTemplateDataWO,
+		)
+	}
+
+	templateData := mergeTemplateData(
+		r.defaults.TemplateData,
+		mergedTemplateData(structuredValues, config.TemplateData),
+		config.SensitiveTemplateData,
+		config.SensitiveTemplateDataWO,
+	)
+	diags.Append(resp.Plan.SetAttribute(ctx, path.Root("template_data_all"), templateData)...)
+	diags.Append(resp.Plan.SetAttribute(ctx, path.Root("template_data_sources"), templateDataSources(templateData, config.TemplateData, structured))...)
+
+	waitForMRStatus := config.WaitForMRStatus
+	if waitForMRStatus == nil {
+		waitForMRStatus = r.defaults.WaitForMRStatus
+	}
+	diags.Append(resp.Plan.SetAttribute(ctx, path.Root("wait_for_mr_status_on_update"), waitForMRStatus)...)
+
+	return
+}
+
+// planTemplateRepositoryVersion resolves the version constraint of the
+// template repository and rejects downgrades when they are prevented.
+func (r *incarnationResource) planTemplateRepositoryVersion(
+	ctx context.Context,
+	config incarnationResourceModel,
+	state *incarnationResourceModel,
+	resp *resource.ModifyPlanResponse,
+) (diags diag.Diagnostics) {
+	templateRepositoryVersion := config.TemplateRepositoryVersion
+
+	if !config.TemplateVersionConstraint.IsNull() {
+		var templateRepository normalizedStringValue
+		diags.Append(resp.Plan.GetAttribute(ctx, path.Root("template_repository"), &templateRepository)...)
+		if diags.HasError() {
+			return
+		}
+
+		if config.TemplateVersionConstraint.IsUnknown() || templateRepository.IsUnknown() {
+			templateRepositoryVersion = types.StringUnknown()
+		} else {
+			tag, err := r.versions.resolve(ctx, templateRepository.ValueString(), config.TemplateVersionConstraint.ValueString())
+			if err != nil {
+				diags.AddAttributeError(
+					path.Root("template_repository_version_constraint"),
+					"Unable to resolve the template repository version",
+					err.Error(),
+				)
+				return
+			}
+			templateRepositoryVersion = types.StringValue(tag)
+		}
+		diags.Append(resp.Plan.SetAttribute(ctx, path.Root("template_repository_version"), templateRepositoryVersion)...)
+	}
+
+	// The versions of a replaced incarnation may come from another template
+	// repository and are not compared.
+	if state == nil || len(resp.RequiresReplace) > 0 || !config.PreventDowngrade.ValueBool() || templateRepositoryVersion.IsUnknown() {
+		return
+	}
+	if isDowngrade(state.TemplateRepositoryVersion.ValueString(), templateRepositoryVersion.ValueString()) {
+		diags.AddAttributeError(
+			path.Root("template_repository_version"),
+			"Template repository version downgrade",
+			fmt.Sprintf(
+				"The incarnation %s would move from the version %s to the older version %s of the template. "+
+					"Set prevent_downgrade to false to allow it.",
+				state.Id.ValueString(),
+				state.TemplateRepositoryVersion.ValueString(),
+				templateRepositoryVersion.ValueString(),
+			),
+		)
+	}
+
+	return
+}
+
+// checkRepositoryPolicies validates the planned repositories of an
+// incarnation being created against the allowlists of the provider.
+func (r *incarnationResource) checkRepositoryPolicies(ctx context.Context, resp *resource.ModifyPlanResponse) (diags diag.Diagnostics) {
+	var templateRepository normalizedStringValue
+	var incarnationRepository types.String
+	diags.Append(resp.Plan.GetAttribute(ctx, path.Root("template_repository"), &templateRepository)...)
+	diags.Append(resp.Plan.GetAttribute(ctx, path.Root("incarnation_repository"), &incarnationRepository)...)
+	if diags.HasError() {
+		return
+	}
+
+	diags.Append(checkRepositoryPolicy(r.templatePolicy, path.Root("template_repository"), "Template repository not allowed", templateRepository.StringValue)...)
+	diags.Append(checkRepositoryPolicy(r.incarnationPolicy, path.Root("incarnation_repository"), "Incarnation repository not allowed", incarnationRepository)...)
+	return
+}
+
+// waitForOnUpdateSchema is the schema of wait_for_mr_status_on_update. Its
+// attributes are computed so that Terraform keeps the values merged from the
+// provider defaults instead of planning their removal.
+var waitForOnUpdateSchema = schema.SingleNestedAttribute{
+	MarkdownDescription: waitForSchema.MarkdownDescription +
+		" Default: the `wait_for_mr_status_on_update` of the provider `defaults` block.",
+	Optional: true,
+	Computed: true,
+	Attributes: map[string]schema.Attribute{
+		"status": schema.StringAttribute{
+			MarkdownDescription: waitForStatusSchema.MarkdownDescription +
+				" Required when `wait_for_mr_status_on_update` is set.",
+			Optional:   true,
+			Computed:   true,
+			Validators: waitForStatusSchema.Validators,
+		},
+		"timeout": schema.StringAttribute{
+			MarkdownDescription: waitForTimeoutSchema.MarkdownDescription,
+			Optional:            true,
+			Computed:            true,
+			Validators:          waitForTimeoutSchema.Validators,
+		},
+	},
+	Validators: []validator.Object{
+		objectvalidator.AlsoRequires(path.MatchRelative().AtName("status")),
+	},
+}
+
+// planReplaceable returns the planned value of an attribute whose change
+// requires the replacement of the incarnation. Semantically equal values do
+// not replace it and, unless set in the configuration which Terraform requires
+// to be planned as is, the value in the state is kept.
+func planReplaceable(
+	configured normalizedStringValue,
+	planned normalizedStringValue,
+	state normalizedStringValue,
+	p path.Path,
+	resp *resource.ModifyPlanResponse,
+) normalizedStringValue {
+	if !planned.semanticallyEqual(state) {
+		resp.RequiresReplace = append(resp.RequiresReplace, p)
+		return planned
+	}
+	if configured.IsNull() {
+		return state
+	}
+	return planned
+}
+
+// updatesIncarnation tells whether applying the plan sends changes to Foxops,
+// which then produces a new commit or merge request. The other settings, like
+// wait_for_mr_status_on_update, only affect the provider.
+func updatesIncarnation(plan incarnationResourceModel, state incarnationResourceModel) bool {
+	return !plan.TemplateDataAll.Equal(state.TemplateDataAll) ||
+		!plan.SensitiveTemplateDataHash.Equal(state.SensitiveTemplateDataHash) ||
+		!plan.TemplateRepositoryVersion.Equal(state.TemplateRepositoryVersion)
+}
+
+// planUpdateResults keeps the values produced by Foxops in the plan when the
+// incarnation is not updated, so that the values filled in by Foxops never
+// show up as changes. They are only unknown when a new commit or merge request
+// will be produced.
+func planUpdateResults(
+	ctx context.Context,
+	state *incarnationResourceModel,
+	resp *resource.ModifyPlanResponse,
+) (diags diag.Diagnostics) {
+	if state == nil {
+		return
+	}
+
+	var plan incarnationResourceModel
+	diags.Append(resp.Plan.Get(ctx, &plan)...)
+	if diags.HasError() {
+		return
+	}
+
+	if updatesIncarnation(plan, *state) {
+		plan.EffectiveTemplateData = types.MapUnknown(types.StringType)
+		plan.CommitSha = types.StringUnknown()
+		plan.CommitUrl = types.StringUnknown()
+		plan.MergeRequestId = types.StringUnknown()
+		plan.MergeRequestUrl = types.StringUnknown()
+		plan.MergeRequestStatus = types.StringUnknown()
+	} else {
+		plan.EffectiveTemplateData = state.EffectiveTemplateData
+		plan.CommitSha = state.CommitSha
+		plan.CommitUrl = state.CommitUrl
+		plan.MergeRequestId = state.MergeRequestId
+		plan.MergeRequestUrl = state.MergeRequestUrl
+		plan.MergeRequestStatus = state.MergeRequestStatus
+	}
+
+	diags.Append(resp.Plan.Set(ctx, plan)...)
+	return
+}
@@ -0,0 +1,280 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/hashicorp/terraform-plugin-framework-validators/mapvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+type incarnationTemplateDataResource struct {
+	client FoxopsClient
+	locks  *incarnationLocks
+}
+
+var _ resource.ResourceWithConfigure = (*incarnationTemplateDataResource)(nil)
+var _ resource.ResourceWithImportState = (*incarnationTemplateDataResource)(nil)
+
+func NewIncarnationTemplateDataResource() resource.Resource {
+	return &incarnationTemplateDataResource{}
+}
+
+type incarnationTemplateDataResourceModel struct {
+	Id            types.String `tfsdk:"id"`
+	IncarnationId types.String `tfsdk:"incarnation_id"`
+	TemplateData  types.Map    `tfsdk:"template_data"`
+	AutoMerge     types.Bool   `tfsdk:"auto_merge"`
+}
+
+func (r *incarnationTemplateDataResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_incarnation_template_data"
+}
+
+func (r *incarnationTemplateDataResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	data, ok := req.ProviderData.(*resourceProviderData)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *provider.resourceProviderData, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+		return
+	}
+
+	r.client = data.client
+	r.locks = data.locks
+}
+
+func (r *incarnationTemplateDataResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "Use this resource to manage some keys of the template data of an existing incarnation. " +
+			"The other keys are kept as they are, so that the template data of an incarnation can be managed by several configurations. " +
+			"A key must not be set by both this resource and the `template_data` of the `foxops_incarnation` resource, " +
+			"which keeps the keys it does not manage. The changes of an incarnation are made one at a time by the provider.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The `id` of the incarnation.",
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"incarnation_id": schema.StringAttribute{
+				MarkdownDescription: "The `id` of the incarnation whose template data is managed.",
+				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"template_data": schema.MapAttribute{
+				MarkdownDescription: "The variables of the incarnation managed by the resource. " +
+					"The variables removed from the map, or all of them when the resource is destroyed, are removed from the incarnation.",
+				ElementType: types.StringType,
+				Required:    true,
+				Validators: []validator.Map{
+					mapvalidator.SizeAtLeast(1),
+				},
+			},
+			"auto_merge": schema.BoolAttribute{
+				MarkdownDescription: "Whether the merge requests of the changes are merged automatically. Default: `true`.",
+				Optional:            true,
+			},
+		},
+	}
+}
+
+func (r *incarnationTemplateDataResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation_template_data.Read")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data incarnationTemplateDataResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	inc, err := r.client.GetIncarnation(ctx, IncarnationId(data.IncarnationId.ValueString()))
+	if errors.Is(err, ErrNotFound) {
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("failed to retrieve incarnation", err.Error())
+		return
+	}
+
+	// Only the managed keys are read, the ones removed from the incarnation
+	// are added again by the next apply.
+	templateData := map[string]string{}
+	for key := range data.TemplateData.Elements() {
+		if value, ok := templateDatumString(inc.TemplateData[key]); ok {
+			templateData[key] = value
+		}
+	}
+
+	var diags diag.Diagnostics
+	data.TemplateData, diags = types.MapValueFrom(ctx, types.StringType, templateData)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+}
+
+func (r *incarnationTemplateDataResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation_template_data.Create")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data incarnationTemplateDataResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	set, _ := stringMapElements(data.TemplateData)
+	resp.Diagnostics.Append(r.change(ctx, data, set, nil)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.Id = data.IncarnationId
+	resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+}
+
+func (r *incarnationTemplateDataResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation_template_data.Update")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data, state incarnationTemplateDataResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	set, _ := stringMapElements(data.TemplateData)
+	var removed []string
+	for key := range state.TemplateData.Elements() {
+		if _, ok := set[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+
+	resp.Diagnostics.Append(r.change(ctx, data, set, removed)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+}
+
+func (r *incarnationTemplateDataResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_incarnation_template_data.Delete")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data incarnationTemplateDataResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	resp.Diagnostics.Append(r.change(ctx, data, nil, sortedKeys(data.TemplateData.Elements()))...)
+}
+
+func (r *incarnationTemplateDataResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	id, keys, ok := strings.Cut(req.ID, ":")
+	if !ok || id == "" || keys == "" {
+		resp.Diagnostics.AddError(
+			"Unexpected Import Identifier",
+			fmt.Sprintf("Expected an import identifier with the format <incarnation_id>:<key>[,<key>...], got: %q", req.ID),
+		)
+		return
+	}
+
+	// The values are read from Foxops by the refresh following the import.
+	templateData := map[string]string{}
+	for _, key := range strings.Split(keys, ",") {
+		templateData[key] = ""
+	}
+
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), id)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("incarnation_id"), id)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("template_data"), templateData)...)
+}
+
+// change sets and removes keys of the template data of the incarnation. The
+// template data is read and sent back while the incarnation is locked, so
+// that the changes of the other resources are kept.
+func (r *incarnationTemplateDataResource) change(
+	ctx context.Context,
+	data incarnationTemplateDataResourceModel,
+	set map[string]string,
+	removed []string,
+) (diags diag.Diagnostics) {
+	id := IncarnationId(data.IncarnationId.ValueString())
+
+	unlock := r.locks.lock(id)
+	defer unlock()
+
+	current, err := getFreshIncarnation(ctx, r.client, id)
+	if errors.Is(err, ErrNotFound) && set == nil {
+		tflog.Info(ctx, "incarnation already deleted", map[string]interface{}{"id": id})
+		return
+	}
+	if err != nil {
+		diags.AddError("failed to retrieve incarnation", err.Error())
+		return
+	}
+
+	changed := false
+	templateData := make(map[string]interface{}, len(current.TemplateData)+len(set))
+	for key, value := range current.TemplateData {
+		templateData[key] = value
+	}
+	for key, value := range set {
+		if currentValue, ok := templateDatumString(templateData[key]); !ok || currentValue != value {
+			templateData[key] = value
+			changed = true
+		}
+	}
+	for _, key := range removed {
+		if _, ok := templateData[key]; ok {
+			delete(templateData, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	autoMerge := true
+	if !data.AutoMerge.IsNull() {
+		autoMerge = data.AutoMerge.ValueBool()
+	}
+
+	_, err = r.client.UpdateIncarnation(withPreviousIncarnation(ctx, current), id, UpdateIncarnationRequest{
+		AutoMerge:                 autoMerge,
+		TemplateData:              templateData,
+		TemplateRepositoryVersion: current.TemplateRepositoryVersion,
+	})
+	if err != nil {
+		diags.AddError("failed to update incarnation", err.Error())
+		return
+	}
+	diags.Append(notificationWarnings(r.client, id)...)
+	return
+}
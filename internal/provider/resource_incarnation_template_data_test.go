@@ -0,0 +1,231 @@
+package provider_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/terraform"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAccIncarnationTemplateDataResource_ShouldManageItsOwnKeys(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+	}
+
+	// Foxops fills in the variables of the fengine.yaml file which are not set.
+	withDefaults := func(templateData map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{"region": "eu"}
+		for key, value := range templateData {
+			result[key] = value
+		}
+		return result
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateData = withDefaults(req.TemplateData)
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateData = withDefaults(req.TemplateData)
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(hello string, templateData string) string {
+		return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_data = {
+    hello = %q
+  }
+}
+
+resource "foxops_incarnation_template_data" "test" {
+  incarnation_id = foxops_incarnation.test.id
+  template_data  = %s
+}
+`, hello, templateData)
+	}
+
+	expectTemplateData := func(expected map[string]interface{}) resource.TestCheckFunc {
+		return func(*terraform.State) error {
+			if !reflect.DeepEqual(current.TemplateData, expected) {
+				return fmt.Errorf("expected the template data %v, got %v", expected, current.TemplateData)
+			}
+			return nil
+		}
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("World!", `{ owner = "team-a" }`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation_template_data.test", "id", "1234"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.%", "1"),
+					expectTemplateData(map[string]interface{}{"hello": "World!", "owner": "team-a", "region": "eu"}),
+				),
+			},
+			{
+				// The incarnation keeps the keys of the other resource.
+				Config: config("You!", `{ owner = "team-a" }`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.hello", "You!"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "effective_template_data.owner", "team-a"),
+					expectTemplateData(map[string]interface{}{"hello": "You!", "owner": "team-a", "region": "eu"}),
+				),
+			},
+			{
+				// The keys removed from the resource are removed from the incarnation.
+				Config: config("You!", `{ team = "team-b" }`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation_template_data.test", "template_data.team", "team-b"),
+					expectTemplateData(map[string]interface{}{"hello": "You!", "team": "team-b", "region": "eu"}),
+				),
+			},
+			{
+				ResourceName:      "foxops_incarnation_template_data.test",
+				ImportState:       true,
+				ImportStateId:     "1234:team",
+				ImportStateVerify: true,
+			},
+			{
+				// Changes made outside of Terraform are reverted.
+				PreConfig: func() {
+					current.TemplateData["team"] = "team-c"
+				},
+				Config: config("You!", `{ team = "team-b" }`),
+				Check:  expectTemplateData(map[string]interface{}{"hello": "You!", "team": "team-b", "region": "eu"}),
+			},
+			{
+				Config:  config("You!", `{ team = "team-b" }`),
+				Destroy: true,
+			},
+		},
+	})
+}
+
+func TestAccIncarnationTemplateDataResource_ShouldRemoveItsKeysOnDestroy(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		TemplateData:              map[string]interface{}{"hello": "World!"},
+	}
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	gomock.InOrder(
+		setup.client.EXPECT().
+			UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+				AutoMerge:                 false,
+				TemplateData:              map[string]interface{}{"hello": "World!", "owner": "team-a"},
+				TemplateRepositoryVersion: "v1.0.0",
+			}).
+			DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+				current.TemplateData = req.TemplateData
+				return *current, nil
+			}),
+		setup.client.EXPECT().
+			UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+				AutoMerge:                 false,
+				TemplateData:              map[string]interface{}{"hello": "World!"},
+				TemplateRepositoryVersion: "v1.0.0",
+			}).
+			DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+				current.TemplateData = req.TemplateData
+				return *current, nil
+			}),
+	)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + `
+resource "foxops_incarnation_template_data" "test" {
+  incarnation_id = "1234"
+  auto_merge     = false
+  template_data = {
+    owner = "team-a"
+  }
+}
+`,
+			},
+		},
+	})
+}
+
+func TestAccIncarnationTemplateDataResource_InvalidImportIdShouldFail(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + `
+resource "foxops_incarnation_template_data" "test" {
+  incarnation_id = "1234"
+  template_data = {
+    owner = "team-a"
+  }
+}
+`,
+				ResourceName:  "foxops_incarnation_template_data.test",
+				ImportState:   true,
+				ImportStateId: "1234",
+				ExpectError:   regexp.MustCompile(`(?s)Unexpected\s+Import\s+Identifier`),
+			},
+		},
+	})
+}
@@ -2,15 +2,31 @@ package provider_test
 
 import (
 	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/Roche/terraform-provider-foxops/internal/helpers"
 	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/knownvalue"
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
+	"github.com/hashicorp/terraform-plugin-testing/statecheck"
+	"github.com/hashicorp/terraform-plugin-testing/tfjsonpath"
+	"github.com/hashicorp/terraform-plugin-testing/tfversion"
 	"github.com/imdario/mergo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,6 +51,7 @@ func IncarnationResourceConfigFactory(name string, req provider.CreateIncarnatio
 
 func TestAccEdgeClusterResource_ShouldCreateOrImportAnIncarnation(t *testing.T) {
 	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
 
 	incarnation := provider.Incarnation{
 		Id:                        provider.IncarnationId("1234"),
@@ -107,6 +124,68 @@ func TestAccEdgeClusterResource_ShouldCreateOrImportAnIncarnation(t *testing.T)
 	})
 }
 
+func TestAccIncarnationResource_ShouldImportByIdentity(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	incarnation := provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		TemplateData: map[string]interface{}{
+			"hello": "World!",
+		},
+	}
+
+	req := provider.CreateIncarnationRequest{
+		IncarnationRepository: incarnation.IncarnationRepository,
+		TargetDirectory:       &incarnation.TargetDirectory,
+		TemplateRepository:    incarnation.TemplateRepository,
+		UpdateIncarnationRequest: provider.UpdateIncarnationRequest{
+			TemplateData:              incarnation.TemplateData,
+			TemplateRepositoryVersion: incarnation.TemplateRepositoryVersion,
+		},
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), req).
+		Return(incarnation, nil)
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), incarnation.Id).
+		Return(incarnation, nil).
+		AnyTimes()
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), incarnation.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest: true,
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(tfversion.Version1_12_0),
+		},
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: IncarnationResourceConfigFactory("test", req),
+				ConfigStateChecks: []statecheck.StateCheck{
+					statecheck.ExpectIdentity("foxops_incarnation.test", map[string]knownvalue.Check{
+						"id": knownvalue.StringExact(string(incarnation.Id)),
+					}),
+				},
+			},
+			{
+				ResourceName:    "foxops_incarnation.test",
+				ImportState:     true,
+				ImportStateKind: resource.ImportBlockWithResourceIdentity,
+			},
+		},
+	})
+}
+
 type resourceChangeTestSetup struct {
 	Name           string
 	Key            string
@@ -161,6 +240,7 @@ func TestIncarnationResourceChanges(t *testing.T) {
 			incarnationResourceChangeTestName(changeTestSetup.Key, changeTestSetup.ShouldRecreate),
 			func(t *testing.T) {
 				setup := newTestProviderSetup(t)
+				setup.expectNoExistingIncarnations()
 
 				incarnation := provider.Incarnation{
 					IncarnationRepository:     "inc/repo",
@@ -273,7 +353,9 @@ func TestIncarnationResourceChanges(t *testing.T) {
 							return incarnation, nil
 						},
 					).
-					Times(3 + updateCallCount)
+					// An update reads the template data of the incarnation
+					// before sending it.
+					Times(3 + 2*updateCallCount)
 
 				setup.client.EXPECT().
 					DeleteIncarnation(
@@ -327,6 +409,7 @@ func TestIncarnationResourceChanges(t *testing.T) {
 
 func TestAccIncarnationResource_NotFoundOnRefreshShouldRemoveFromState(t *testing.T) {
 	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
 
 	incarnation := provider.Incarnation{
 		Id:                        provider.IncarnationId("1234"),
@@ -381,6 +464,7 @@ func TestAccIncarnationResource_NotFoundOnRefreshShouldRemoveFromState(t *testin
 
 func TestAccEdgeClusterResource_ClientErrorShouldNotDeleteState(t *testing.T) {
 	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
 
 	incarnation := provider.Incarnation{
 		Id:                        provider.IncarnationId("1234"),
@@ -466,3 +550,1591 @@ func TestAccEdgeClusterResource_ClientErrorShouldNotDeleteState(t *testing.T) {
 		},
 	})
 }
+
+// sensitiveIncarnationTestSetup returns the incarnation served by the mocked
+// client and a function registering the expected calls, so that tests skipped
+// for older Terraform versions do not report missing calls.
+func sensitiveIncarnationTestSetup(
+	t *testing.T,
+	sensitiveKey string,
+	sensitiveValue string,
+) (testProviderSetup, *provider.Incarnation, func()) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+	}
+
+	expect := func() {
+		setup.client.EXPECT().
+			CreateIncarnation(gomock.Any(), provider.CreateIncarnationRequest{
+				IncarnationRepository: current.IncarnationRepository,
+				TargetDirectory:       &current.TargetDirectory,
+				TemplateRepository:    current.TemplateRepository,
+				UpdateIncarnationRequest: provider.UpdateIncarnationRequest{
+					TemplateData: map[string]interface{}{
+						"hello":      "World!",
+						sensitiveKey: sensitiveValue,
+					},
+					TemplateRepositoryVersion: current.TemplateRepositoryVersion,
+				},
+			}).
+			DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+				current.TemplateData = req.TemplateData
+				return *current, nil
+			})
+
+		setup.client.EXPECT().
+			GetIncarnation(gomock.Any(), current.Id).
+			DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+				return *current, nil
+			}).
+			AnyTimes()
+
+		setup.client.EXPECT().
+			DeleteIncarnation(gomock.Any(), current.Id).
+			Return(nil)
+	}
+
+	return setup, current, expect
+}
+
+// isHashOf checks that an attribute holds the salted hash of value.
+func isHashOf(value string) resource.CheckResourceAttrWithFunc {
+	return func(hash string) error {
+		parts := strings.Split(hash, "$")
+		if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+			return fmt.Errorf("unexpected hash format: %s", hash)
+		}
+		iterations, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return err
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return err
+		}
+		key, err := pbkdf2.Key(sha256.New, value, salt, iterations, sha256.Size)
+		if err != nil {
+			return err
+		}
+		if base64.RawStdEncoding.EncodeToString(key) != parts[3] {
+			return fmt.Errorf("%s is not the hash of the expected value", hash)
+		}
+		return nil
+	}
+}
+
+func TestAccIncarnationResource_SensitiveTemplateDataShouldBeKeptOutOfTemplateData(t *testing.T) {
+	setup, current, expect := sensitiveIncarnationTestSetup(t, "password", "s3cret")
+
+	expect()
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+			AutoMerge: true,
+			TemplateData: map[string]interface{}{
+				"hello":    "World!",
+				"password": "s3cret",
+			},
+			TemplateRepositoryVersion: current.TemplateRepositoryVersion,
+		}).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateData = req.TemplateData
+			return *current, nil
+		})
+
+	config := providerConfig + `
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_data = {
+    hello = "World!"
+  }
+  sensitive_template_data = {
+    password = "s3cret"
+  }
+}
+`
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.%", "1"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.hello", "World!"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "sensitive_template_data.password", "s3cret"),
+					resource.TestCheckResourceAttrWith("foxops_incarnation.test", "sensitive_template_data_hashes.password", isHashOf("s3cret")),
+				),
+			},
+			{
+				// The value is changed outside of Terraform, the drift is
+				// detected through the stored hash and reverted.
+				PreConfig: func() {
+					current.TemplateData = map[string]interface{}{
+						"hello":    "World!",
+						"password": "changed",
+					}
+				},
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrWith("foxops_incarnation.test", "sensitive_template_data_hashes.password", isHashOf("s3cret")),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_WriteOnlySensitiveTemplateDataShouldNotBeStored(t *testing.T) {
+	setup, current, expect := sensitiveIncarnationTestSetup(t, "token", "abc")
+
+	config := func(token string) string {
+		return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_data = {
+    hello = "World!"
+  }
+  sensitive_template_data_wo = {
+    token = "%s"
+  }
+}
+`, token)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		TerraformVersionChecks: []tfversion.TerraformVersionCheck{
+			tfversion.SkipBelow(version.Must(version.NewVersion("1.11.0"))),
+		},
+		Steps: []resource.TestStep{
+			{
+				PreConfig: func() {
+					expect()
+					setup.client.EXPECT().
+						UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+							AutoMerge: true,
+							TemplateData: map[string]interface{}{
+								"hello": "World!",
+								"token": "def",
+							},
+							TemplateRepositoryVersion: current.TemplateRepositoryVersion,
+						}).
+						DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+							current.TemplateData = req.TemplateData
+							return *current, nil
+						})
+				},
+				Config: config("abc"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.%", "1"),
+					resource.TestCheckNoResourceAttr("foxops_incarnation.test", "sensitive_template_data_wo"),
+					resource.TestCheckResourceAttrWith("foxops_incarnation.test", "sensitive_template_data_hashes.token", isHashOf("abc")),
+				),
+			},
+			{
+				Config: config("def"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrWith("foxops_incarnation.test", "sensitive_template_data_hashes.token", isHashOf("def")),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_ShouldMergeProviderDefaults(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	incarnations := map[provider.IncarnationId]provider.Incarnation{}
+	created := 0
+
+	expectCreate := func(templateRepository string) {
+		setup.client.EXPECT().
+			CreateIncarnation(gomock.Any(), provider.CreateIncarnationRequest{
+				IncarnationRepository: "inc/repo",
+				TemplateRepository:    templateRepository,
+				UpdateIncarnationRequest: provider.UpdateIncarnationRequest{
+					TemplateData: map[string]interface{}{
+						"hello": "World!",
+						"owner": "team-a",
+					},
+					TemplateRepositoryVersion: "v1.0.0",
+				},
+			}).
+			DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+				created += 1
+				inc := provider.Incarnation{
+					Id:                        provider.IncarnationId(fmt.Sprintf("%04d", created)),
+					IncarnationRepository:     req.IncarnationRepository,
+					TargetDirectory:           ".",
+					TemplateRepository:        req.TemplateRepository,
+					TemplateRepositoryVersion: req.TemplateRepositoryVersion,
+					TemplateData:              req.TemplateData,
+					CommitSha:                 "12345678",
+					CommitUrl:                 "template/repo/commit",
+				}
+				incarnations[inc.Id] = inc
+				return inc, nil
+			})
+	}
+	expectCreate("template/repo")
+	expectCreate("template/other-repo")
+
+	// The refreshes wait for the merge request status set in the defaults.
+	setup.client.EXPECT().
+		GetIncarnationWithMergeRequestStatus(gomock.Any(), gomock.Any(), "merged").
+		DoAndReturn(func(_ context.Context, id provider.IncarnationId, _ string) (provider.Incarnation, error) {
+			return incarnations[id], nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), gomock.Any()).
+		Return(nil).
+		Times(2)
+
+	config := func(templateRepository string) string {
+		return fmt.Sprintf(`
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  defaults = {
+    template_repository = %q
+    template_data = {
+      hello = "Default"
+      owner = "team-a"
+    }
+    wait_for_mr_status_on_update = {
+      status  = "merged"
+      timeout = "1m"
+    }
+  }
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  template_repository_version = "v1.0.0"
+  template_data = {
+    hello = "World!"
+  }
+}
+`, templateRepository)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("template/repo"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectKnownValue(
+							"foxops_incarnation.test",
+							tfjsonpath.New("template_data_all"),
+							knownvalue.MapExact(map[string]knownvalue.Check{
+								"hello": knownvalue.StringExact("World!"),
+								"owner": knownvalue.StringExact("team-a"),
+							}),
+						),
+						plancheck.ExpectKnownValue(
+							"foxops_incarnation.test",
+							tfjsonpath.New("template_repository"),
+							knownvalue.StringExact("template/repo"),
+						),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "id", "0001"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.%", "1"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_all.owner", "team-a"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "target_directory", "."),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "wait_for_mr_status_on_update.status", "merged"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "wait_for_mr_status_on_update.timeout", "1m"),
+				),
+			},
+			{
+				Config: config("template/other-repo"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("foxops_incarnation.test", plancheck.ResourceActionReplace),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "id", "0002"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_repository", "template/other-repo"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_MissingTemplateRepositoryShouldFail(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: providerConfig + `
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  template_repository_version = "v1.0.0"
+}
+`,
+				ExpectError: regexp.MustCompile("Missing template repository"),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_TemplateDataDefaultedByFoxopsShouldNotShowAsChanges(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+	}
+
+	// Foxops fills in the variables of the fengine.yaml file which are not set.
+	withDefaults := func(templateData map[string]interface{}) map[string]interface{} {
+		result := map[string]interface{}{"region": "eu"}
+		for key, value := range templateData {
+			result[key] = value
+		}
+		return result
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateData = withDefaults(req.TemplateData)
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+			AutoMerge:                 true,
+			TemplateData:              map[string]interface{}{"hello": "You!"},
+			TemplateRepositoryVersion: current.TemplateRepositoryVersion,
+		}).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateData = withDefaults(req.TemplateData)
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(hello string) string {
+		return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_data = {
+    hello = %q
+  }
+}
+`, hello)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("World!"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.%", "1"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "effective_template_data.%", "2"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "effective_template_data.region", "eu"),
+				),
+			},
+			{
+				Config: config("World!"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectEmptyPlan(),
+					},
+				},
+			},
+			{
+				Config: config("You!"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectUnknownValue("foxops_incarnation.test", tfjsonpath.New("effective_template_data")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data.hello", "You!"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "effective_template_data.hello", "You!"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "effective_template_data.region", "eu"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_MergeRequestAttributesShouldOnlyBeUnknownWhenUpdated(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	current := &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		MergeRequestId:            helpers.Addr("1"),
+		MergeRequestStatus:        helpers.Addr("merged"),
+		MergeRequestUrl:           helpers.Addr("inc/repo/mr!1"),
+		TemplateData:              map[string]interface{}{"hello": "World!"},
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			return *current, nil
+		})
+
+	// Only the change of the template version reaches Foxops.
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, provider.UpdateIncarnationRequest{
+			TemplateData:              map[string]interface{}{"hello": "World!"},
+			TemplateRepositoryVersion: "v2.0.0",
+		}).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			current.CommitSha = "87654321"
+			current.MergeRequestId = helpers.Addr("2")
+			current.MergeRequestStatus = helpers.Addr("open")
+			current.MergeRequestUrl = helpers.Addr("inc/repo/mr!2")
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(version string, autoMerge bool) string {
+		return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = %q
+  auto_merge_on_update        = %t
+  template_data = {
+    hello = "World!"
+  }
+}
+`, version, autoMerge)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("v1.0.0", true),
+			},
+			{
+				Config: config("v1.0.0", false),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("foxops_incarnation.test", plancheck.ResourceActionUpdate),
+						plancheck.ExpectKnownValue("foxops_incarnation.test", tfjsonpath.New("commit_sha"), knownvalue.StringExact("12345678")),
+						plancheck.ExpectKnownValue("foxops_incarnation.test", tfjsonpath.New("merge_request_id"), knownvalue.StringExact("1")),
+						plancheck.ExpectKnownValue("foxops_incarnation.test", tfjsonpath.New("merge_request_url"), knownvalue.StringExact("inc/repo/mr!1")),
+					},
+				},
+			},
+			{
+				Config: config("v2.0.0", false),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectUnknownValue("foxops_incarnation.test", tfjsonpath.New("commit_sha")),
+						plancheck.ExpectUnknownValue("foxops_incarnation.test", tfjsonpath.New("merge_request_id")),
+						plancheck.ExpectUnknownValue("foxops_incarnation.test", tfjsonpath.New("merge_request_url")),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "commit_sha", "87654321"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "merge_request_id", "2"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "merge_request_url", "inc/repo/mr!2"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_RollbackOnFailureShouldResetFailedUpdates(t *testing.T) {
+	tests := map[string]struct {
+		failedMergeRequest func(current provider.Incarnation) (provider.Incarnation, error)
+		expectedError      *regexp.Regexp
+	}{
+		"closed merge request": {
+			failedMergeRequest: func(current provider.Incarnation) (provider.Incarnation, error) {
+				current.MergeRequestStatus = helpers.Addr("closed")
+				return current, nil
+			},
+			expectedError: regexp.MustCompile(`(?s)merge request inc/repo/mr!2 updating the incarnation\s+1234\s+was\s+closed\.\s+The\s+incarnation\s+is\s+reset\s+to\s+the\s+version\s+v1.0.0\s+by\s+the\s+merge\s+request\s+inc/repo/mr!3`),
+		},
+		"timed out merge request": {
+			failedMergeRequest: func(current provider.Incarnation) (provider.Incarnation, error) {
+				return current, context.DeadlineExceeded
+			},
+			expectedError: regexp.MustCompile(`(?s)merge request inc/repo/mr!2 updating the incarnation\s+1234\s+did\s+not\s+reach\s+the\s+status\s+merged\s+within\s+the\s+timeout\s+and\s+is\s+left\s+open.*reset\s+to\s+the\s+version\s+v1.0.0\s+by\s+the\s+merge\s+request\s+inc/repo/mr!3`),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			setup := newTestProviderSetup(t)
+			setup.expectNoExistingIncarnations()
+
+			current := &provider.Incarnation{
+				Id:                        provider.IncarnationId("1234"),
+				IncarnationRepository:     "inc/repo",
+				TemplateRepository:        "template/repo",
+				TemplateRepositoryVersion: "v1.0.0",
+				TargetDirectory:           ".",
+				CommitSha:                 "12345678",
+				CommitUrl:                 "template/repo/commit",
+				MergeRequestId:            helpers.Addr("1"),
+				MergeRequestStatus:        helpers.Addr("merged"),
+				MergeRequestUrl:           helpers.Addr("inc/repo/mr!1"),
+				TemplateData:              map[string]interface{}{"hello": "World!"},
+			}
+
+			setup.client.EXPECT().
+				CreateIncarnation(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+					return *current, nil
+				})
+
+			setup.client.EXPECT().
+				UpdateIncarnation(gomock.Any(), current.Id, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+					current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+					current.TemplateData = req.TemplateData
+					current.MergeRequestId = helpers.Addr("2")
+					current.MergeRequestStatus = helpers.Addr("open")
+					current.MergeRequestUrl = helpers.Addr("inc/repo/mr!2")
+					return *current, nil
+				})
+
+			// The previous version and template data are restored.
+			setup.client.EXPECT().
+				ResetIncarnation(gomock.Any(), current.Id, provider.ResetIncarnationRequest{
+					OverrideVersion:      helpers.Addr("v1.0.0"),
+					OverrideTemplateData: map[string]interface{}{"hello": "World!"},
+				}).
+				DoAndReturn(func(context.Context, provider.IncarnationId, provider.ResetIncarnationRequest) (provider.ResetIncarnationResult, error) {
+					current.TemplateRepositoryVersion = "v1.0.0"
+					current.TemplateData = map[string]interface{}{"hello": "World!"}
+					current.MergeRequestId = helpers.Addr("3")
+					current.MergeRequestStatus = helpers.Addr("open")
+					current.MergeRequestUrl = helpers.Addr("inc/repo/mr!3")
+					return provider.ResetIncarnationResult{MergeRequestId: "3", MergeRequestUrl: "inc/repo/mr!3"}, nil
+				})
+
+			setup.client.EXPECT().
+				GetIncarnation(gomock.Any(), current.Id).
+				DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+					return *current, nil
+				}).
+				AnyTimes()
+
+			setup.client.EXPECT().
+				GetIncarnationWithMergeRequestStatus(gomock.Any(), current.Id, "merged").
+				DoAndReturn(func(context.Context, provider.IncarnationId, string) (provider.Incarnation, error) {
+					if *current.MergeRequestId == "2" {
+						return tt.failedMergeRequest(*current)
+					}
+					return *current, nil
+				}).
+				AnyTimes()
+
+			setup.client.EXPECT().
+				DeleteIncarnation(gomock.Any(), current.Id).
+				Return(nil)
+
+			config := func(version string, hello string) string {
+				return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = %q
+  rollback_on_failure         = true
+  template_data = {
+    hello = %q
+  }
+  wait_for_mr_status_on_update = {
+    status  = "merged"
+    timeout = "1s"
+  }
+}
+`, version, hello)
+			}
+
+			resource.Test(t, resource.TestCase{
+				IsUnitTest:               true,
+				ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+				Steps: []resource.TestStep{
+					{
+						Config: config("v1.0.0", "World!"),
+					},
+					{
+						Config:      config("v2.0.0", "Mars!"),
+						ExpectError: tt.expectedError,
+					},
+					{
+						Config: config("v1.0.0", "World!"),
+						ConfigPlanChecks: resource.ConfigPlanChecks{
+							PreApply: []plancheck.PlanCheck{
+								plancheck.ExpectEmptyPlan(),
+							},
+						},
+						Check: resource.ComposeTestCheckFunc(
+							resource.TestCheckResourceAttr("foxops_incarnation.test", "template_repository_version", "v1.0.0"),
+							resource.TestCheckResourceAttr("foxops_incarnation.test", "merge_request_url", "inc/repo/mr!3"),
+						),
+					},
+				},
+			})
+		})
+	}
+}
+
+func TestAccIncarnationResource_CosmeticPathChangesShouldNotReplaceTheIncarnation(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	// Foxops returns the normalized values.
+	current := provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "https://example.com/template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           "some-folder",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		TemplateData:              map[string]interface{}{},
+	}
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), provider.CreateIncarnationRequest{
+			IncarnationRepository: current.IncarnationRepository,
+			TargetDirectory:       helpers.Addr("./some-folder"),
+			TemplateRepository:    "https://example.com/template/repo.git",
+			UpdateIncarnationRequest: provider.UpdateIncarnationRequest{
+				TemplateData:              map[string]interface{}{},
+				TemplateRepositoryVersion: current.TemplateRepositoryVersion,
+			},
+		}).
+		Return(current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		Return(current, nil).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(targetDirectory string, templateRepository string) string {
+		return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = %q
+  template_repository         = %q
+  template_repository_version = "v1.0.0"
+}
+`, targetDirectory, templateRepository)
+	}
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config("./some-folder", "https://example.com/template/repo.git"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "target_directory", "./some-folder"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_repository", "https://example.com/template/repo.git"),
+				),
+			},
+			{
+				Config: config("some-folder/", "https://example.com/template/repo/"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("foxops_incarnation.test", plancheck.ResourceActionUpdate),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "id", string(current.Id)),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "target_directory", "some-folder/"),
+				),
+			},
+		},
+	})
+}
+
+func destroyTestIncarnation() *provider.Incarnation {
+	return &provider.Incarnation{
+		Id:                        provider.IncarnationId("1234"),
+		IncarnationRepository:     "inc/repo",
+		TemplateRepository:        "template/repo",
+		TemplateRepositoryVersion: "v1.0.0",
+		TargetDirectory:           ".",
+		CommitSha:                 "12345678",
+		CommitUrl:                 "template/repo/commit",
+		MergeRequestId:            helpers.Addr("1"),
+		MergeRequestStatus:        helpers.Addr("open"),
+		MergeRequestUrl:           helpers.Addr("inc/repo/mr!1"),
+		TemplateData:              map[string]interface{}{},
+	}
+}
+
+func destroyTestConfig(settings string) string {
+	return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  %s
+}
+`, settings)
+}
+
+func TestAccIncarnationResource_DeletionProtectionShouldPreventDestroy(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(*current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		Return(*current, nil).
+		AnyTimes()
+
+	// The incarnation was already removed from Foxops.
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(provider.ErrNotFound)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: destroyTestConfig(`deletion_protection = true`),
+			},
+			{
+				Config:      destroyTestConfig(`deletion_protection = true`),
+				Destroy:     true,
+				ExpectError: regexp.MustCompile("Incarnation is protected against deletion"),
+			},
+			{
+				Config: destroyTestConfig(`deletion_protection = false`),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_ReplacementShouldWarnAndRespectDeletionProtection(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(*current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		Return(*current, nil).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: destroyTestConfig(`deletion_protection = true`),
+			},
+			{
+				Config: strings.Replace(
+					destroyTestConfig(`deletion_protection = true`),
+					`"template/repo"`, `"template/other-repo"`, 1,
+				),
+				ExpectError: regexp.MustCompile("Incarnation is protected against deletion"),
+			},
+			{
+				Config: destroyTestConfig(`deletion_protection = false`),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_IncarnationRepositoryChangeShouldRespectDeletionProtection(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(*current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		Return(*current, nil).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: destroyTestConfig(`deletion_protection = true`),
+			},
+			{
+				Config: strings.Replace(
+					destroyTestConfig(`deletion_protection = true`),
+					`"inc/repo"`, `"inc/other-repo"`, 1,
+				),
+				ExpectError: regexp.MustCompile(`(?s)Incarnation is protected against deletion.*must be replaced to change\s+\[incarnation_repository\]`),
+			},
+			{
+				Config: destroyTestConfig(`deletion_protection = false`),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_FailIfOpenMergeRequestShouldPreventDestroy(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(*current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := destroyTestConfig(`on_destroy = "fail_if_open_mr"`)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+			},
+			{
+				Config:      config,
+				Destroy:     true,
+				ExpectError: regexp.MustCompile(`merge request inc/repo/mr!1 of the incarnation 1234 must be merged`),
+			},
+			{
+				PreConfig: func() {
+					current.MergeRequestStatus = helpers.Addr("merged")
+				},
+				Config:  config,
+				Destroy: true,
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_WaitForOpenMergeRequestShouldDelayDestroy(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+
+	destroying := false
+	openPolls := 0
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(*current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			inc := *current
+			if destroying {
+				openPolls += 1
+				// The merge request is merged while the destroy waits for it.
+				if openPolls > 2 {
+					current.MergeRequestStatus = helpers.Addr("merged")
+				}
+			}
+			return inc, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) error {
+			assert.Equal(t, "merged", *current.MergeRequestStatus)
+			return nil
+		})
+
+	config := destroyTestConfig(`
+  on_destroy         = "wait_for_open_mr"
+  on_destroy_timeout = "1m"
+`)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+			},
+			{
+				PreConfig: func() {
+					destroying = true
+				},
+				Config:  config,
+				Destroy: true,
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_ExistingIncarnationShouldFailCreate(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	setup.client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{IncarnationRepository: helpers.Addr("inc/repo")}).
+		Return([]provider.IncarnationBasic{
+			{
+				Id:                    provider.IncarnationId("5678"),
+				IncarnationRepository: "inc/repo",
+				TargetDirectory:       "./",
+			},
+		}, nil).
+		AnyTimes()
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      destroyTestConfig(""),
+				ExpectError: regexp.MustCompile(`(?s)Incarnation already exists.*terraform import foxops_incarnation.<name>\s+"5678"`),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_DuplicateIncarnationsShouldFail(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+
+	// The duplicate is rejected by the plan, before any incarnation is
+	// created.
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: destroyTestConfig("") + `
+resource "foxops_incarnation" "duplicate" {
+  incarnation_repository      = "inc/repo"
+  template_repository         = "template/other-repo"
+  template_repository_version = "v1.0.0"
+}
+`,
+				PlanOnly:    true,
+				ExpectError: regexp.MustCompile("Duplicate incarnation"),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_ReplacementShouldNotConflictWithTheReplacedIncarnation(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	current := destroyTestIncarnation()
+
+	existing := []provider.IncarnationBasic{}
+
+	setup.client.EXPECT().
+		ListIncarnations(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.ListIncarnationsRequest) ([]provider.IncarnationBasic, error) {
+			return existing, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateRepository = req.TemplateRepository
+			existing = []provider.IncarnationBasic{
+				{
+					Id:                    current.Id,
+					IncarnationRepository: current.IncarnationRepository,
+					TargetDirectory:       current.TargetDirectory,
+				},
+			}
+			return *current, nil
+		}).
+		Times(2)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, provider.IncarnationId) error {
+			existing = []provider.IncarnationBasic{}
+			return nil
+		}).
+		Times(2)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: destroyTestConfig(""),
+			},
+			{
+				Config: strings.Replace(
+					destroyTestConfig(""),
+					`"template/repo"`, `"template/other-repo"`, 1,
+				),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("foxops_incarnation.test", plancheck.ResourceActionDestroyBeforeCreate),
+					},
+				},
+			},
+		},
+	})
+}
+
+func writeTemplateSpec(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "fengine.yaml")
+	spec := `
+required_foxops_version: v2.0.0
+variables:
+  name:
+    type: str
+    description: The name of the application
+  replicas:
+    type: int
+    description: The number of replicas
+    default: 1
+  ports:
+    type: list
+    default: [80, 443]
+`
+	require.NoError(t, os.WriteFile(file, []byte(spec), 0o600))
+	return file
+}
+
+func templateSpecTestConfig(specFile string, templateData string) string {
+	return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_spec_file          = %q
+  template_data = {
+    %s
+  }
+}
+`, specFile, templateData)
+}
+
+func TestAccIncarnationResource_TemplateSpecFileShouldExposeTheTemplateVariables(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+	specFile := writeTemplateSpec(t)
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			current.TemplateData = req.TemplateData
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: templateSpecTestConfig(specFile, `name = "app"
+    replicas = "3"`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.%", "3"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.name.type", "str"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.name.description", "The name of the application"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.name.required", "true"),
+					resource.TestCheckNoResourceAttr("foxops_incarnation.test", "template_variables.name.default"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.replicas.default", "1"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.replicas.required", "false"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_variables.ports.default", "[80,443]"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_TemplateSpecFileShouldValidateTheTemplateData(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	specFile := writeTemplateSpec(t)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: templateSpecTestConfig(specFile, `name = "app"
+    replica = "3"`),
+				ExpectError: regexp.MustCompile(`(?s)Unknown template variable.*replica = "3".*does not declare the variable "replica"`),
+			},
+			{
+				Config:      templateSpecTestConfig(specFile, `replicas = "3"`),
+				ExpectError: regexp.MustCompile(`(?s)Missing template variable.*requires the variable "name"`),
+			},
+			{
+				Config: templateSpecTestConfig(specFile, `name = "app"
+    replicas = "three"`),
+				ExpectError: regexp.MustCompile(`(?s)Invalid template variable value.*replicas = "three".*expected an integer`),
+			},
+			{
+				Config: templateSpecTestConfig(specFile, `name = "app"
+    ports = "80"`),
+				ExpectError: regexp.MustCompile(`(?s)Invalid template variable value.*expected a JSON encoded list`),
+			},
+			{
+				Config:      templateSpecTestConfig(filepath.Join(t.TempDir(), "missing.yaml"), `name = "app"`),
+				ExpectError: regexp.MustCompile("Unable to read the template spec file"),
+			},
+		},
+	})
+}
+
+// newTemplateRepository creates a local git repository with the given tags and
+// returns its url along with a function adding more tags.
+func newTemplateRepository(t *testing.T, tags ...string) (string, func(tag string)) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		output, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(output))
+	}
+	git("init", "--quiet")
+	git("commit", "--quiet", "--allow-empty", "-m", "init")
+
+	addTag := func(tag string) {
+		git("tag", tag)
+		git("update-server-info")
+	}
+	for _, tag := range tags {
+		addTag(tag)
+	}
+	return serveGitRepository(t, filepath.Join(dir, ".git")), addTag
+}
+
+// serveGitRepository serves the git directory over the dumb HTTP protocol, as
+// only http(s) and ssh template repositories are listed.
+func serveGitRepository(t *testing.T, gitDir string) string {
+	output, err := exec.Command("git", "-C", gitDir, "update-server-info").CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	server := httptest.NewServer(http.StripPrefix("/template.git", http.FileServer(http.Dir(gitDir))))
+	t.Cleanup(server.Close)
+	return server.URL + "/template.git"
+}
+
+// newBareTemplateRepository creates a local bare git repository with the given
+// tags and returns its path.
+func newBareTemplateRepository(t *testing.T, tags ...string) string {
+	source, _ := newTemplateRepository(t, tags...)
+	bare := filepath.Join(t.TempDir(), "template.git")
+	output, err := exec.Command("git", "clone", "--quiet", "--bare", source, bare).CombinedOutput()
+	require.NoError(t, err, string(output))
+	return bare
+}
+
+func templateVersionTestConfig(templateRepository string, settings string) string {
+	return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository = "inc/repo"
+  target_directory       = "."
+  template_repository    = %q
+  %s
+}
+`, templateRepository, settings)
+}
+
+func TestAccIncarnationResource_VersionConstraintShouldResolveTheNewestMatchingTag(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+	templateRepository, addTag := newTemplateRepository(t, "v1.0.0", "v1.1.0", "v1.2.0", "v2.0.0", "latest")
+	current.TemplateRepository = templateRepository
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			assert.Equal(t, "v1.2.0", req.TemplateRepositoryVersion)
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), current.Id, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			assert.Equal(t, "v1.3.0", req.TemplateRepositoryVersion)
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := templateVersionTestConfig(templateRepository, `template_repository_version_constraint = "~> 1.1"`)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: config,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_repository_version", "v1.2.0"),
+				),
+			},
+			{
+				// A new matching version is released.
+				PreConfig: func() {
+					addTag("v1.3.0")
+				},
+				Config: config,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectKnownValue("foxops_incarnation.test", tfjsonpath.New("template_repository_version"), knownvalue.StringExact("v1.3.0")),
+					},
+				},
+			},
+			{
+				Config:      templateVersionTestConfig(templateRepository, `template_repository_version_constraint = "~> 3.0"`),
+				ExpectError: regexp.MustCompile(`(?s)Unable to resolve the template repository version.*matches the version constraint "~> 3.0"`),
+			},
+			{
+				Config: templateVersionTestConfig(templateRepository, `
+  template_repository_version            = "v1.3.0"
+  template_repository_version_constraint = "~> 1.1"
+`),
+				ExpectError: regexp.MustCompile("Invalid Attribute Combination"),
+			},
+			{
+				Config: config,
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_VersionConstraintShouldResolveTagsOfLocalBareRepositories(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+	templateRepository := newBareTemplateRepository(t, "v1.0.0", "v1.1.0", "v2.0.0")
+	current.TemplateRepository = templateRepository
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			assert.Equal(t, "v1.1.0", req.TemplateRepositoryVersion)
+			current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      templateVersionTestConfig(templateRepository, `template_repository_version_constraint = "~> 1.0"`),
+				ExpectError: regexp.MustCompile(`(?s)Unable to resolve the template repository version.*allow_local_template_repositories`),
+			},
+			{
+				Config: localTemplateRepositoriesProviderConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository                 = "inc/repo"
+  target_directory                       = "."
+  template_repository                    = %q
+  template_repository_version_constraint = "~> 1.0"
+}
+`, templateRepository),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_repository_version", "v1.1.0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_PreventDowngradeShouldRejectOlderVersions(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+	templateRepository, _ := newTemplateRepository(t, "v1.0.0", "v1.2.0")
+	current.TemplateRepository = templateRepository
+	current.TemplateRepositoryVersion = "v1.2.0"
+
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		Return(*current, nil)
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		Return(*current, nil).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: templateVersionTestConfig(templateRepository, `
+  template_repository_version = "v1.2.0"
+  prevent_downgrade           = true
+`),
+			},
+			{
+				Config: templateVersionTestConfig(templateRepository, `
+  template_repository_version = "v1.0.0"
+  prevent_downgrade           = true
+`),
+				ExpectError: regexp.MustCompile(`(?s)Template repository version downgrade.*from the version v1.2.0 to the older\s+version\s+v1.0.0`),
+			},
+			{
+				Config: templateVersionTestConfig(templateRepository, `
+  template_repository_version_constraint = "< 1.2"
+  prevent_downgrade                      = true
+`),
+				ExpectError: regexp.MustCompile("Template repository version downgrade"),
+			},
+		},
+	})
+}
+
+func TestAccIncarnationResource_TemplateDataFilesAndJSONShouldKeepTheirTypes(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	setup.expectNoExistingIncarnations()
+	current := destroyTestIncarnation()
+
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	require.NoError(t, os.WriteFile(base, []byte(`
+region: eu
+replicas: 2
+debug: true
+tags: [a, b]
+scale: 1.0
+precision: 0.1
+unset: null
+`), 0o600))
+	prod := filepath.Join(dir, "prod.json")
+	require.NoError(t, os.WriteFile(prod, []byte(`{"region": "us", "ratio": 0.5}`), 0o600))
+
+	// The values of the files and of template_data_json keep their types, the
+	// later sources taking precedence. The floats that a float32 cannot hold
+	// are sent as their literal and the null values are ignored.
+	setup.client.EXPECT().
+		CreateIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+			assert.Equal(t, map[string]interface{}{
+				"region":    "ch",
+				"replicas":  3,
+				"debug":     "true",
+				"tags":      `["a","b"]`,
+				"ratio":     float32(0.5),
+				"owners":    `{"team":"a"}`,
+				"scale":     float32(1),
+				"precision": "0.1",
+			}, req.TemplateData)
+			current.TemplateData = req.TemplateData
+			return *current, nil
+		})
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), current.Id).
+		DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+			return *current, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		DeleteIncarnation(gomock.Any(), current.Id).
+		Return(nil)
+
+	config := func(templateDataJSON string) string {
+		return providerConfig + fmt.Sprintf(`
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = "v1.0.0"
+  template_data_files         = [%q, %q]
+  template_data_json          = %s
+  template_data = {
+    region = "ch"
+  }
+}
+`, base, prod, templateDataJSON)
+	}
+
+	valid := config(`jsonencode({ replicas = 3, owners = { team = "a" } })`)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      config(`jsonencode(["a"])`),
+				ExpectError: regexp.MustCompile(`(?s)template_data_json must hold a JSON\s+object`),
+			},
+			{
+				Config: valid,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_all.%", "8"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_all.precision", "0.1"),
+					resource.TestCheckNoResourceAttr("foxops_incarnation.test", "template_data_all.unset"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_all.replicas", "3"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_all.ratio", "0.5"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_sources.region", "template_data"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_sources.replicas", "template_data_json"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_sources.owners", "template_data_json"),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_sources.tags", base),
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "template_data_sources.ratio", prod),
+				),
+			},
+			{
+				Config: valid,
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectEmptyPlan(),
+					},
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,662 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/sync/errgroup"
+)
+
+type templateRolloutResource struct {
+	client FoxopsClient
+	locks  *incarnationLocks
+}
+
+var _ resource.ResourceWithConfigure = (*templateRolloutResource)(nil)
+var _ resource.ResourceWithModifyPlan = (*templateRolloutResource)(nil)
+
+func NewTemplateRolloutResource() resource.Resource {
+	return &templateRolloutResource{}
+}
+
+const (
+	defaultRolloutBatchSize    = 10
+	defaultRolloutMergeTimeout = 30 * time.Minute
+
+	// rolloutConcurrentReads is the maximum number of incarnations read
+	// concurrently.
+	rolloutConcurrentReads = 10
+
+	rolloutCompleted  = "completed"
+	rolloutIncomplete = "incomplete"
+
+	// The incarnation waits for its batch.
+	rolloutPending = "pending"
+	// The incarnation was updated and its merge request is not merged yet.
+	rolloutUpdating = "updating"
+	// The merge request of the update was merged.
+	rolloutMerged = "merged"
+	// The incarnation was updated without a merge request.
+	rolloutUpdated = "updated"
+	// The incarnation already used the target version.
+	rolloutUpToDate = "up_to_date"
+	// The incarnation uses a newer version than the target version.
+	rolloutSkipped = "skipped"
+	// The update failed or its merge request was closed or not merged in time.
+	rolloutFailed = "failed"
+)
+
+type templateRolloutResourceModel struct {
+	Id                 types.String          `tfsdk:"id"`
+	TemplateRepository normalizedStringValue `tfsdk:"template_repository"`
+	TargetVersion      types.String          `tfsdk:"target_version"`
+	BatchSize          types.Int64           `tfsdk:"batch_size"`
+	FailureThreshold   types.Int64           `tfsdk:"failure_threshold"`
+	MergeTimeout       types.String          `tfsdk:"merge_timeout"`
+	AutoMerge          types.Bool            `tfsdk:"auto_merge"`
+	Status             types.String          `tfsdk:"status"`
+	Incarnations       types.Map             `tfsdk:"incarnations"`
+}
+
+type rolloutIncarnationModel struct {
+	IncarnationRepository types.String `tfsdk:"incarnation_repository"`
+	TargetDirectory       types.String `tfsdk:"target_directory"`
+	PreviousVersion       types.String `tfsdk:"previous_version"`
+	Status                types.String `tfsdk:"status"`
+	MergeRequestUrl       types.String `tfsdk:"merge_request_url"`
+	Error                 types.String `tfsdk:"error"`
+}
+
+var rolloutIncarnationType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"incarnation_repository": types.StringType,
+		"target_directory":       types.StringType,
+		"previous_version":       types.StringType,
+		"status":                 types.StringType,
+		"merge_request_url":      types.StringType,
+		"error":                  types.StringType,
+	},
+}
+
+func (r *templateRolloutResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_template_rollout"
+}
+
+func (r *templateRolloutResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	if req.ProviderData == nil {
+		return
+	}
+
+	data, ok := req.ProviderData.(*resourceProviderData)
+	if !ok {
+		resp.Diagnostics.AddError(
+			"Unexpected Resource Configure Type",
+			fmt.Sprintf("Expected *provider.resourceProviderData, got: %T. Please report this issue to the provider developers.", req.ProviderData),
+		)
+		return
+	}
+
+	r.client = data.client
+	r.locks = data.locks
+}
+
+func (r *templateRolloutResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Use this resource to upgrade all the incarnations of a template in batches.",
+		MarkdownDescription: "Use this resource to upgrade all the incarnations of a template in batches. " +
+			"The incarnations of `template_repository` are updated to `target_version` `batch_size` at a time, " +
+			"and the merge requests of a batch must be merged before the next batch starts. " +
+			"The status of every incarnation is stored in the state, so that an interrupted or stopped rollout resumes " +
+			"where it stopped on the next apply; a rollout stopped while being created is only reported as a warning. " +
+			"On refresh, the incarnations of the rollout still listed by Foxops are read again: an incarnation moved away from `target_version` " +
+			"makes the rollout incomplete again, and the new incarnations of the template are included the next time the rollout runs. " +
+			"Incarnations also managed by a `foxops_incarnation` resource will show " +
+			"the new version as a change of that resource. Destroying the resource does not revert the incarnations.",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The `id` of the rollout.",
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"template_repository": schema.StringAttribute{
+				CustomType: templateRepositoryType,
+				MarkdownDescription: "The repository of the template whose incarnations are upgraded. " +
+					"The incarnations are selected through the list of all the incarnations known to Foxops.",
+				Required: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"target_version": schema.StringAttribute{
+				MarkdownDescription: "The version of the template the incarnations are upgraded to. " +
+					"Changing it starts a new rollout. Incarnations using a newer semantic version are skipped.",
+				Required: true,
+			},
+			"batch_size": schema.Int64Attribute{
+				MarkdownDescription: "The number of incarnations updated at a time. Default: `10`.",
+				Optional:            true,
+				Validators: []validator.Int64{
+					int64validator.AtLeast(1),
+				},
+			},
+			"failure_threshold": schema.Int64Attribute{
+				MarkdownDescription: "The number of failed incarnations tolerated before the rollout stops. " +
+					"The failed incarnations are retried by the next apply. Default: `0`.",
+				Optional: true,
+				Validators: []validator.Int64{
+					int64validator.AtLeast(0),
+				},
+			},
+			"merge_timeout": schema.StringAttribute{
+				MarkdownDescription: "The amount of time to wait for the merge requests of a batch to be merged. " +
+					"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). " +
+					"Example: `1m30s`. Default: `30m`.",
+				Optional: true,
+				Validators: []validator.String{
+					durationValidator,
+				},
+			},
+			"auto_merge": schema.BoolAttribute{
+				MarkdownDescription: "Whether the merge requests of the updates are merged automatically. " +
+					"Without it, the merge requests must be merged by someone else within `merge_timeout`. Default: `true`.",
+				Optional: true,
+			},
+			"status": schema.StringAttribute{
+				MarkdownDescription: "`completed` when every incarnation uses the target version, `incomplete` otherwise.",
+				Computed:            true,
+			},
+			"incarnations": schema.MapNestedAttribute{
+				MarkdownDescription: "The incarnations of the template, by `id`.",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"incarnation_repository": schema.StringAttribute{
+							MarkdownDescription: "The repository of the incarnation.",
+							Computed:            true,
+						},
+						"target_directory": schema.StringAttribute{
+							MarkdownDescription: "The folder of the incarnation.",
+							Computed:            true,
+						},
+						"previous_version": schema.StringAttribute{
+							MarkdownDescription: "The version of the template used by the incarnation before the rollout.",
+							Computed:            true,
+						},
+						"status": schema.StringAttribute{
+							MarkdownDescription: "The status of the incarnation in the rollout. One of `pending`, `updating`, `merged`, " +
+								"`updated` (without a merge request), `up_to_date`, `skipped` or `failed`.",
+							Computed: true,
+						},
+						"merge_request_url": schema.StringAttribute{
+							MarkdownDescription: "The url of the merge request of the update.",
+							Computed:            true,
+						},
+						"error": schema.StringAttribute{
+							MarkdownDescription: "Why the incarnation failed.",
+							Computed:            true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Read reads the incarnations of the rollout again, so that the changes made
+// outside of the rollout show: an incarnation moved away from the target
+// version is pending again and makes the rollout incomplete, which the next
+// apply resumes. Only the incarnations recorded in the state and still listed
+// by Foxops are read, the others are dropped.
+func (r *templateRolloutResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_template_rollout.Read")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data templateRolloutResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	prior := map[string]rolloutIncarnationModel{}
+	resp.Diagnostics.Append(data.Incarnations.ElementsAs(ctx, &prior, false)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	listed, err := r.client.ListIncarnations(ctx, ListIncarnationsRequest{})
+	if err != nil {
+		resp.Diagnostics.AddError("failed to list incarnations", err.Error())
+		return
+	}
+	ids := []IncarnationId{}
+	for _, basic := range listed {
+		if _, ok := prior[string(basic.Id)]; ok {
+			ids = append(ids, basic.Id)
+		}
+	}
+
+	fetched, err := r.fetchIncarnations(ctx, ids)
+	if err != nil {
+		resp.Diagnostics.AddError("failed to retrieve incarnation", err.Error())
+		return
+	}
+
+	incarnations := map[string]rolloutIncarnationModel{}
+	for _, inc := range fetched {
+		// An incarnation moved to another template is no longer part of the
+		// rollout.
+		if !data.TemplateRepository.semanticallyEqual(newTemplateRepositoryValue(inc.TemplateRepository)) {
+			continue
+		}
+		id := string(inc.Id)
+		entry := rolloutEntry(prior[id], *inc, data.TargetVersion.ValueString())
+		// The failures are only cleared by the next apply, which retries them.
+		if prior[id].Status.ValueString() == rolloutFailed && entry.Status.ValueString() == rolloutPending {
+			entry = prior[id]
+		}
+		incarnations[id] = entry
+	}
+
+	var diags diag.Diagnostics
+	data.Status = rolloutStatus(incarnations)
+	data.Incarnations, diags = types.MapValueFrom(ctx, rolloutIncarnationType, incarnations)
+	resp.Diagnostics.Append(diags...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+}
+
+func (r *templateRolloutResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() || req.State.Raw.IsNull() {
+		return
+	}
+
+	var plan, state templateRolloutResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// A completed rollout is only run again for a new version.
+	if state.Status.ValueString() == rolloutCompleted && plan.TargetVersion.Equal(state.TargetVersion) {
+		plan.Status = state.Status
+		plan.Incarnations = state.Incarnations
+	} else {
+		plan.Status = types.StringUnknown()
+		plan.Incarnations = types.MapUnknown(rolloutIncarnationType)
+	}
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, plan)...)
+}
+
+func (r *templateRolloutResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_template_rollout.Create")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data templateRolloutResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.Id = types.StringValue(uuid.NewString())
+
+	// Terraform replaces a resource whose creation failed, which would start
+	// the rollout over and update the incarnations whose merge requests are
+	// still open again: a stopped rollout is stored and resumed instead.
+	resp.Diagnostics.Append(r.rollout(ctx, &data, map[string]rolloutIncarnationModel{}, diag.SeverityWarning)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+}
+
+func (r *templateRolloutResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	ctx, span := tracing.Start(ctx, "foxops_template_rollout.Update")
+	defer func() { tracing.EndWithDiagnostics(span, resp.Diagnostics) }()
+
+	var data, state templateRolloutResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	if !data.Status.IsUnknown() {
+		// Only the settings changed, see ModifyPlan.
+		resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+		return
+	}
+
+	prior := map[string]rolloutIncarnationModel{}
+	if data.TargetVersion.Equal(state.TargetVersion) {
+		resp.Diagnostics.Append(state.Incarnations.ElementsAs(ctx, &prior, false)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	resp.Diagnostics.Append(r.rollout(ctx, &data, prior, diag.SeverityError)...)
+	resp.Diagnostics.Append(resp.State.Set(ctx, data)...)
+}
+
+func (r *templateRolloutResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	// The incarnations keep the version they were upgraded to.
+}
+
+// rollout updates the incarnations of the template in batches. The statuses of
+// the incarnations are stored in data even when the rollout stops, so that the
+// next apply resumes it. An interrupted or stopped rollout is reported with
+// the given severity, the other failures are errors.
+func (r *templateRolloutResource) rollout(
+	ctx context.Context,
+	data *templateRolloutResourceModel,
+	prior map[string]rolloutIncarnationModel,
+	stopSeverity diag.Severity,
+) (diags diag.Diagnostics) {
+	batchSize := int64(defaultRolloutBatchSize)
+	if !data.BatchSize.IsNull() {
+		batchSize = data.BatchSize.ValueInt64()
+	}
+	mergeTimeout := defaultRolloutMergeTimeout
+	if !data.MergeTimeout.IsNull() {
+		var err error
+		mergeTimeout, err = time.ParseDuration(data.MergeTimeout.ValueString())
+		if err != nil {
+			diags.AddAttributeError(path.Root("merge_timeout"), "invalid timeout", err.Error())
+			return
+		}
+	}
+	autoMerge := true
+	if !data.AutoMerge.IsNull() {
+		autoMerge = data.AutoMerge.ValueBool()
+	}
+	target := data.TargetVersion.ValueString()
+
+	incarnations, d := r.selectIncarnations(ctx, data.TemplateRepository, target, prior)
+	diags.Append(d...)
+
+	defer func() {
+		data.Status = rolloutStatus(incarnations)
+		var d diag.Diagnostics
+		data.Incarnations, d = types.MapValueFrom(ctx, rolloutIncarnationType, incarnations)
+		diags.Append(d...)
+	}()
+	if diags.HasError() {
+		return
+	}
+
+	ids := []string{}
+	for id, inc := range incarnations {
+		if inc.Status.ValueString() == rolloutPending || inc.Status.ValueString() == rolloutUpdating {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	failures := int64(0)
+	for start := 0; start < len(ids); start += int(batchSize) {
+		batch := ids[start:min(start+int(batchSize), len(ids))]
+		tflog.Info(ctx, "rolling out the template version", map[string]interface{}{
+			"version":      target,
+			"incarnations": batch,
+		})
+
+		for _, id := range batch {
+			inc := incarnations[id]
+			if inc.Status.ValueString() != rolloutPending {
+				continue
+			}
+			incarnations[id] = r.updateIncarnation(ctx, IncarnationId(id), inc, target, autoMerge)
+		}
+
+		batchCtx, cancel := context.WithTimeout(ctx, mergeTimeout)
+		for _, id := range batch {
+			if incarnations[id].Status.ValueString() != rolloutUpdating {
+				continue
+			}
+			incarnations[id] = r.waitForMerge(batchCtx, ctx, IncarnationId(id), incarnations[id], mergeTimeout)
+		}
+		cancel()
+		for _, id := range batch {
+			diags.Append(notificationWarnings(r.client, IncarnationId(id))...)
+		}
+
+		if ctx.Err() != nil {
+			diags.Append(rolloutStopped(
+				stopSeverity,
+				"Rollout interrupted",
+				"The rollout was interrupted, it resumes where it stopped on the next apply.",
+			))
+			return
+		}
+
+		for _, id := range batch {
+			if incarnations[id].Status.ValueString() == rolloutFailed {
+				failures += 1
+			}
+		}
+		if failures > data.FailureThreshold.ValueInt64() {
+			diags.Append(rolloutStopped(
+				stopSeverity,
+				"Rollout stopped",
+				fmt.Sprintf(
+					"%d incarnations failed to be upgraded to %s, more than the failure threshold of %d. "+
+						"The failed incarnations are listed in the incarnations attribute of the rollout and are retried by the next apply.",
+					failures,
+					target,
+					data.FailureThreshold.ValueInt64(),
+				),
+			))
+			return
+		}
+	}
+
+	if failures > 0 {
+		diags.AddWarning(
+			"Rollout completed with failures",
+			fmt.Sprintf(
+				"%d incarnations failed to be upgraded to %s. They are retried by the next apply.",
+				failures,
+				target,
+			),
+		)
+	}
+	return
+}
+
+// rolloutStopped reports a rollout which stops before its last batch.
+func rolloutStopped(severity diag.Severity, summary string, detail string) diag.Diagnostic {
+	if severity == diag.SeverityWarning {
+		return diag.NewWarningDiagnostic(summary, detail)
+	}
+	return diag.NewErrorDiagnostic(summary, detail)
+}
+
+// rolloutStatus returns whether every incarnation is done with the rollout.
+func rolloutStatus(incarnations map[string]rolloutIncarnationModel) types.String {
+	for _, inc := range incarnations {
+		switch inc.Status.ValueString() {
+		case rolloutMerged, rolloutUpdated, rolloutUpToDate, rolloutSkipped:
+		default:
+			return types.StringValue(rolloutIncomplete)
+		}
+	}
+	return types.StringValue(rolloutCompleted)
+}
+
+// selectIncarnations returns the incarnations of the template repository along
+// with their status in the rollout. Foxops only lists the incarnations without
+// their template, so each of them is read.
+func (r *templateRolloutResource) selectIncarnations(
+	ctx context.Context,
+	templateRepository normalizedStringValue,
+	target string,
+	prior map[string]rolloutIncarnationModel,
+) (map[string]rolloutIncarnationModel, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	incarnations := map[string]rolloutIncarnationModel{}
+
+	incs, err := r.client.ListIncarnations(ctx, ListIncarnationsRequest{})
+	if err != nil {
+		diags.AddError("failed to list incarnations", err.Error())
+		return incarnations, diags
+	}
+	ids := make([]IncarnationId, len(incs))
+	for i, basic := range incs {
+		ids[i] = basic.Id
+	}
+
+	fetched, err := r.fetchIncarnations(ctx, ids)
+	if err != nil {
+		diags.AddError("failed to retrieve incarnation", err.Error())
+		return incarnations, diags
+	}
+
+	for _, inc := range fetched {
+		if !templateRepository.semanticallyEqual(newTemplateRepositoryValue(inc.TemplateRepository)) {
+			continue
+		}
+
+		id := string(inc.Id)
+		entry, known := prior[id]
+		if !known {
+			entry = rolloutIncarnationModel{
+				PreviousVersion: types.StringValue(inc.TemplateRepositoryVersion),
+				MergeRequestUrl: types.StringNull(),
+			}
+		}
+		incarnations[id] = rolloutEntry(entry, *inc, target)
+	}
+
+	return incarnations, diags
+}
+
+// fetchIncarnations reads the incarnations, rolloutConcurrentReads at a time.
+// The incarnations deleted in the meantime are left out.
+func (r *templateRolloutResource) fetchIncarnations(ctx context.Context, ids []IncarnationId) ([]*Incarnation, error) {
+	fetched := make([]*Incarnation, len(ids))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(rolloutConcurrentReads)
+	for i, id := range ids {
+		group.Go(func() error {
+			inc, err := r.client.GetIncarnation(groupCtx, id)
+			if errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			fetched[i] = &inc
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(fetched, func(inc *Incarnation) bool { return inc == nil }), nil
+}
+
+// rolloutEntry returns the status in the rollout of the incarnation, given its
+// entry of the previous run.
+func rolloutEntry(entry rolloutIncarnationModel, inc Incarnation, target string) rolloutIncarnationModel {
+	entry.IncarnationRepository = types.StringValue(inc.IncarnationRepository)
+	entry.TargetDirectory = types.StringValue(inc.TargetDirectory)
+	entry.Error = types.StringNull()
+
+	switch {
+	case entry.Status.ValueString() == rolloutUpdating:
+		// The update was sent before the rollout was interrupted.
+	case inc.TemplateRepositoryVersion == target:
+		if entry.Status.ValueString() != rolloutMerged && entry.Status.ValueString() != rolloutUpdated {
+			entry.Status = types.StringValue(rolloutUpToDate)
+		}
+	case isDowngrade(inc.TemplateRepositoryVersion, target):
+		entry.Status = types.StringValue(rolloutSkipped)
+	default:
+		entry.Status = types.StringValue(rolloutPending)
+		entry.MergeRequestUrl = types.StringNull()
+	}
+	return entry
+}
+
+func (r *templateRolloutResource) updateIncarnation(
+	ctx context.Context,
+	id IncarnationId,
+	entry rolloutIncarnationModel,
+	target string,
+	autoMerge bool,
+) rolloutIncarnationModel {
+	unlock := r.locks.lock(id)
+	defer unlock()
+
+	current, err := getFreshIncarnation(ctx, r.client, id)
+	if err == nil {
+		_, err = r.client.UpdateIncarnation(withPreviousIncarnation(ctx, current), id, UpdateIncarnationRequest{
+			AutoMerge:                 autoMerge,
+			TemplateData:              current.TemplateData,
+			TemplateRepositoryVersion: target,
+		})
+	}
+	if err != nil {
+		entry.Status = types.StringValue(rolloutFailed)
+		entry.Error = types.StringValue(err.Error())
+		return entry
+	}
+	entry.Status = types.StringValue(rolloutUpdating)
+	return entry
+}
+
+// waitForMerge waits for the merge request of an updated incarnation until
+// the batch context is done. The incarnation stays updating when the rollout
+// itself is interrupted.
+func (r *templateRolloutResource) waitForMerge(
+	batchCtx context.Context,
+	ctx context.Context,
+	id IncarnationId,
+	entry rolloutIncarnationModel,
+	timeout time.Duration,
+) rolloutIncarnationModel {
+	waitDiags := waitForOpenMergeRequest(batchCtx, r.client, id, timeout)
+	if ctx.Err() != nil {
+		return entry
+	}
+
+	inc, err := getFreshIncarnation(ctx, r.client, id)
+	if err != nil {
+		entry.Status = types.StringValue(rolloutFailed)
+		entry.Error = types.StringValue(err.Error())
+		return entry
+	}
+	if inc.MergeRequestUrl != nil {
+		entry.MergeRequestUrl = types.StringValue(*inc.MergeRequestUrl)
+	}
+
+	switch {
+	case waitDiags.HasError():
+		entry.Status = types.StringValue(rolloutFailed)
+		entry.Error = types.StringValue(fmt.Sprintf("the merge request %s was not merged within %s", mergeRequestReference(inc), timeout))
+	case inc.MergeRequestId == nil:
+		entry.Status = types.StringValue(rolloutUpdated)
+	case inc.MergeRequestStatus != nil && *inc.MergeRequestStatus == "merged":
+		entry.Status = types.StringValue(rolloutMerged)
+	default:
+		entry.Status = types.StringValue(rolloutFailed)
+		entry.Error = types.StringValue(fmt.Sprintf("the merge request %s was not merged", mergeRequestReference(inc)))
+	}
+	return entry
+}
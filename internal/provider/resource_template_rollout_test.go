@@ -0,0 +1,325 @@
+package provider_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
+	"github.com/hashicorp/terraform-plugin-testing/terraform"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+// rolloutTestFleet is a fake Foxops inventory whose updates are merged right
+// away, unless the incarnation is listed in closed.
+type rolloutTestFleet struct {
+	mu           sync.Mutex
+	incarnations map[provider.IncarnationId]*provider.Incarnation
+	closed       map[provider.IncarnationId]bool
+	updates      []provider.IncarnationId
+	reads        []provider.IncarnationId
+}
+
+func newRolloutTestFleet(versions map[string]string) *rolloutTestFleet {
+	fleet := &rolloutTestFleet{
+		incarnations: map[provider.IncarnationId]*provider.Incarnation{},
+		closed:       map[provider.IncarnationId]bool{},
+	}
+	for id, version := range versions {
+		fleet.incarnations[provider.IncarnationId(id)] = &provider.Incarnation{
+			Id:                        provider.IncarnationId(id),
+			IncarnationRepository:     "inc/repo-" + id,
+			TargetDirectory:           ".",
+			TemplateRepository:        "template/repo",
+			TemplateRepositoryVersion: version,
+			TemplateData:              map[string]interface{}{"name": id},
+		}
+	}
+	return fleet
+}
+
+func (f *rolloutTestFleet) expect(t *testing.T, setup testProviderSetup) {
+	setup.client.EXPECT().
+		ListIncarnations(gomock.Any(), provider.ListIncarnationsRequest{}).
+		DoAndReturn(func(context.Context, provider.ListIncarnationsRequest) ([]provider.IncarnationBasic, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			result := []provider.IncarnationBasic{}
+			for _, inc := range f.incarnations {
+				result = append(result, provider.IncarnationBasic{
+					Id:                    inc.Id,
+					IncarnationRepository: inc.IncarnationRepository,
+					TargetDirectory:       inc.TargetDirectory,
+				})
+			}
+			return result, nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		GetIncarnation(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id provider.IncarnationId) (provider.Incarnation, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.reads = append(f.reads, id)
+			return *f.incarnations[id], nil
+		}).
+		AnyTimes()
+
+	setup.client.EXPECT().
+		UpdateIncarnation(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, id provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			inc := f.incarnations[id]
+			assert.Equal(t, inc.TemplateData, req.TemplateData)
+			f.updates = append(f.updates, id)
+			inc.MergeRequestId = helpers.Addr(fmt.Sprintf("%d", len(f.updates)))
+			inc.MergeRequestUrl = helpers.Addr(fmt.Sprintf("%s/mr!%d", inc.IncarnationRepository, len(f.updates)))
+			if f.closed[id] {
+				inc.MergeRequestStatus = helpers.Addr("closed")
+			} else {
+				inc.MergeRequestStatus = helpers.Addr("merged")
+				inc.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+			}
+			return *inc, nil
+		}).
+		AnyTimes()
+}
+
+func rolloutTestConfig(version string) string {
+	return providerConfig + fmt.Sprintf(`
+resource "foxops_template_rollout" "test" {
+  template_repository = "template/repo.git"
+  target_version      = %q
+  batch_size          = 2
+}
+`, version)
+}
+
+func TestAccTemplateRolloutResource_ShouldUpgradeTheIncarnationsOfTheTemplate(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	fleet := newRolloutTestFleet(map[string]string{
+		"1": "v1.0.0",
+		"2": "v1.0.0",
+		"3": "v1.0.0",
+		"4": "v2.0.0",
+		"5": "v3.0.0",
+	})
+	fleet.incarnations["6"] = &provider.Incarnation{
+		Id:                        "6",
+		IncarnationRepository:     "inc/repo-6",
+		TemplateRepository:        "template/other-repo",
+		TemplateRepositoryVersion: "v1.0.0",
+	}
+	fleet.expect(t, setup)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: rolloutTestConfig("v2.0.0"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.%", "5"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.1.status", "merged"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.1.previous_version", "v1.0.0"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.1.merge_request_url", "inc/repo-1/mr!1"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.3.status", "merged"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.4.status", "up_to_date"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.5.status", "skipped"),
+					func(*terraform.State) error {
+						assert.Equal(t, []provider.IncarnationId{"1", "2", "3"}, fleet.updates)
+						return nil
+					},
+				),
+			},
+			{
+				// A completed rollout is not run again.
+				Config: rolloutTestConfig("v2.0.0"),
+				Check: func(*terraform.State) error {
+					assert.Len(t, fleet.updates, 3)
+					return nil
+				},
+			},
+		},
+	})
+}
+
+func TestAccTemplateRolloutResource_ShouldStopOnFailuresAndResume(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	fleet := newRolloutTestFleet(map[string]string{
+		"1": "v1.0.0",
+		"2": "v1.0.0",
+		"3": "v1.0.0",
+		"4": "v1.0.0",
+		"5": "v1.0.0",
+	})
+	fleet.expect(t, setup)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: rolloutTestConfig("v1.0.0"),
+				Check:  resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+			},
+			{
+				// The merge request of the second batch is closed.
+				PreConfig: func() {
+					fleet.closed["3"] = true
+				},
+				Config:      rolloutTestConfig("v2.0.0"),
+				ExpectError: regexp.MustCompile(`(?s)Rollout stopped.*1 incarnations failed to be upgraded to v2.0.0`),
+			},
+			{
+				PreConfig: func() {
+					assert.Equal(t, []provider.IncarnationId{"1", "2", "3", "4"}, fleet.updates)
+					fleet.closed["3"] = false
+				},
+				Config: rolloutTestConfig("v2.0.0"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.1.status", "merged"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.3.status", "merged"),
+					resource.TestCheckNoResourceAttr("foxops_template_rollout.test", "incarnations.3.error"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.5.status", "merged"),
+					func(*terraform.State) error {
+						// Only the failed and the pending incarnations are updated again.
+						assert.Equal(t, []provider.IncarnationId{"1", "2", "3", "4", "3", "5"}, fleet.updates)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestAccTemplateRolloutResource_ShouldResumeARolloutStoppedWhenCreated(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	fleet := newRolloutTestFleet(map[string]string{
+		"1": "v1.0.0",
+		"2": "v1.0.0",
+		"3": "v1.0.0",
+	})
+	fleet.closed["1"] = true
+	fleet.expect(t, setup)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				// The rollout is stored instead of being tainted by an error.
+				Config:             rolloutTestConfig("v2.0.0"),
+				ExpectNonEmptyPlan: true,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "incomplete"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.1.status", "failed"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.2.status", "merged"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.3.status", "pending"),
+				),
+			},
+			{
+				PreConfig: func() {
+					fleet.closed["1"] = false
+				},
+				Config: rolloutTestConfig("v2.0.0"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+					func(*terraform.State) error {
+						assert.Equal(t, []provider.IncarnationId{"1", "2", "1", "3"}, fleet.updates)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
+
+func TestAccTemplateRolloutResource_RefreshShouldDetectChangedIncarnations(t *testing.T) {
+	setup := newTestProviderSetup(t)
+	fleet := newRolloutTestFleet(map[string]string{
+		"1": "v1.0.0",
+		"2": "v1.0.0",
+	})
+	fleet.expect(t, setup)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: rolloutTestConfig("v2.0.0"),
+				Check:  resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+			},
+			{
+				// Only the incarnations of the rollout are read on refresh.
+				PreConfig: func() {
+					fleet.mu.Lock()
+					defer fleet.mu.Unlock()
+					fleet.incarnations["3"] = &provider.Incarnation{
+						Id:                        "3",
+						IncarnationRepository:     "inc/repo-3",
+						TargetDirectory:           ".",
+						TemplateRepository:        "other/template",
+						TemplateRepositoryVersion: "v1.0.0",
+					}
+					fleet.reads = nil
+				},
+				RefreshState: true,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+					func(*terraform.State) error {
+						fleet.mu.Lock()
+						defer fleet.mu.Unlock()
+						assert.ElementsMatch(t, []provider.IncarnationId{"1", "2"}, fleet.reads)
+						return nil
+					},
+				),
+			},
+			{
+				// An incarnation was reverted, another one was deleted and a
+				// new one was added since the rollout completed.
+				PreConfig: func() {
+					fleet.mu.Lock()
+					defer fleet.mu.Unlock()
+					fleet.incarnations["1"].TemplateRepositoryVersion = "v1.0.0"
+					delete(fleet.incarnations, "2")
+					fleet.incarnations["4"] = &provider.Incarnation{
+						Id:                        "4",
+						IncarnationRepository:     "inc/repo-4",
+						TargetDirectory:           ".",
+						TemplateRepository:        "template/repo",
+						TemplateRepositoryVersion: "v1.0.0",
+						TemplateData:              map[string]interface{}{"name": "4"},
+					}
+				},
+				Config: rolloutTestConfig("v2.0.0"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("foxops_template_rollout.test", plancheck.ResourceActionUpdate),
+					},
+				},
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "status", "completed"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.%", "2"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.1.status", "merged"),
+					resource.TestCheckResourceAttr("foxops_template_rollout.test", "incarnations.4.status", "merged"),
+					func(*terraform.State) error {
+						assert.Equal(t, []provider.IncarnationId{"1", "2", "1", "4"}, fleet.updates)
+						return nil
+					},
+				),
+			},
+		},
+	})
+}
@@ -0,0 +1,183 @@
+package provider
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// stringMapElements returns the elements of a map of strings. The second
+// result is false when the map or one of its elements is unknown.
+func stringMapElements(m types.Map) (map[string]string, bool) {
+	result := map[string]string{}
+	if m.IsUnknown() {
+		return result, false
+	}
+	for key, value := range m.Elements() {
+		value, ok := value.(types.String)
+		if !ok {
+			continue
+		}
+		if value.IsUnknown() {
+			return result, false
+		}
+		if !value.IsNull() {
+			result[key] = value.ValueString()
+		}
+	}
+	return result, true
+}
+
+// templateDatumString converts a template data value returned by Foxops to
+// its string representation.
+func templateDatumString(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case int:
+		return fmt.Sprintf("%d", value), true
+	case float32:
+		return strconv.FormatFloat(float64(value), 'f', -1, 32), true
+	case float64:
+		return fmt.Sprintf("%f", value), true
+	}
+	return "", false
+}
+
+// requestTemplateData merges the template data and the sensitive template
+// data into the map sent to Foxops.
+func requestTemplateData(templateData types.Map, sensitive map[string]string) map[string]interface{} {
+	result := map[string]interface{}{}
+	values, _ := stringMapElements(templateData)
+	for key, value := range values {
+		result[key] = value
+	}
+	for key, value := range sensitive {
+		result[key] = value
+	}
+	return result
+}
+
+// sensitiveTemplateData merges the sensitive and the write-only template data.
+func sensitiveTemplateData(sensitive types.Map, writeOnly types.Map) (map[string]string, bool) {
+	result, known := stringMapElements(sensitive)
+	writeOnlyValues, writeOnlyKnown := stringMapElements(writeOnly)
+	for key, value := range writeOnlyValues {
+		result[key] = value
+	}
+	return result, known && writeOnlyKnown
+}
+
+// sensitiveHashIterations is the number of PBKDF2 iterations of the hashes of
+// the sensitive values, which makes guessing them from the state slow.
+const sensitiveHashIterations = 100_000
+
+const sensitiveHashScheme = "pbkdf2-sha256"
+
+// hashTemplateDatum hashes a sensitive value with a random salt. The hash is
+// stored as `pbkdf2-sha256$<iterations>$<salt>$<hash>`.
+func hashTemplateDatum(value string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return encodeTemplateDatumHash(value, salt, sensitiveHashIterations)
+}
+
+func encodeTemplateDatumHash(value string, salt []byte, iterations int) (string, error) {
+	key, err := pbkdf2.Key(sha256.New, value, salt, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		sensitiveHashScheme,
+		strconv.Itoa(iterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// templateDatumHashMatches tells whether hash is the hash of value. The
+// unsalted hashes of earlier versions never match, so they are replaced.
+func templateDatumHashMatches(hash string, value string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != sensitiveHashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := encodeTemplateDatumHash(value, salt, iterations)
+	return err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
+
+// sensitiveTemplateDataHashes returns the hashes stored in the state in place
+// of the sensitive values, so that drifts can be detected without exposing
+// them. The prior hashes of unchanged values are kept, the others are salted
+// anew.
+func sensitiveTemplateDataHashes(ctx context.Context, sensitive map[string]string, prior types.Map) (types.Map, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if len(sensitive) == 0 {
+		return types.MapNull(types.StringType), diags
+	}
+	priorHashes := prior.Elements()
+	hashes := make(map[string]string, len(sensitive))
+	for key, value := range sensitive {
+		// The prior hashes of a plan are unknown for the changed values.
+		if hash, ok := priorHashes[key].(types.String); ok && templateDatumHashMatches(hash.ValueString(), value) {
+			hashes[key] = hash.ValueString()
+			continue
+		}
+		hash, err := hashTemplateDatum(value)
+		if err != nil {
+			diags.AddError("failed to hash sensitive template data", err.Error())
+			return types.MapNull(types.StringType), diags
+		}
+		hashes[key] = hash
+	}
+	return types.MapValueFrom(ctx, types.StringType, hashes)
+}
+
+// plannedSensitiveTemplateDataHashes returns the hashes of the plan: the
+// hashes of the state for unchanged values, and unknown values for the others
+// as their salt is only drawn when they are applied.
+func plannedSensitiveTemplateDataHashes(sensitive map[string]string, state types.Map) types.Map {
+	if len(sensitive) == 0 {
+		return types.MapNull(types.StringType)
+	}
+	stateHashes, _ := stringMapElements(state)
+	hashes := make(map[string]attr.Value, len(sensitive))
+	for key, value := range sensitive {
+		if hash, ok := stateHashes[key]; ok && templateDatumHashMatches(hash, value) {
+			hashes[key] = types.StringValue(hash)
+		} else {
+			hashes[key] = types.StringUnknown()
+		}
+	}
+	return types.MapValueMust(types.StringType, hashes)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
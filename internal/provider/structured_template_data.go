@@ -0,0 +1,274 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"gopkg.in/yaml.v3"
+)
+
+// The sources of the template data shown in template_data_sources, besides
+// the paths of template_data_files.
+const (
+	templateDataSourceConfig   = "template_data"
+	templateDataSourceJSON     = "template_data_json"
+	templateDataSourceDefaults = "defaults"
+)
+
+// structuredTemplateData is the template data loaded from
+// template_data_files and template_data_json. The values keep the type they
+// are sent to Foxops with, see structuredTemplateDatum.
+type structuredTemplateData struct {
+	values  map[string]interface{}
+	strings map[string]string
+	sources map[string]string
+}
+
+// loadStructuredTemplateData merges the template data files in order and then
+// template_data_json. The second result is false when one of them is unknown.
+func loadStructuredTemplateData(
+	ctx context.Context,
+	files types.List,
+	encoded types.String,
+) (structuredTemplateData, bool, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	data := structuredTemplateData{
+		values:  map[string]interface{}{},
+		strings: map[string]string{},
+		sources: map[string]string{},
+	}
+	if files.IsUnknown() || encoded.IsUnknown() {
+		return data, false, diags
+	}
+
+	var paths []types.String
+	diags.Append(files.ElementsAs(ctx, &paths, true)...)
+	if diags.HasError() {
+		return data, false, diags
+	}
+
+	for i, file := range paths {
+		if file.IsUnknown() {
+			return data, false, diags
+		}
+		attribute := path.Root("template_data_files").AtListIndex(i)
+		content, err := os.ReadFile(file.ValueString())
+		if err != nil {
+			diags.AddAttributeError(attribute, "Unable to read the template data file", err.Error())
+			continue
+		}
+		var nodes map[string]yaml.Node
+		if err := yaml.Unmarshal(content, &nodes); err != nil {
+			diags.AddAttributeError(
+				attribute,
+				"Invalid template data file",
+				fmt.Sprintf("%s must hold a YAML or JSON object: %s", file.ValueString(), err.Error()),
+			)
+			continue
+		}
+		values, err := yamlTemplateData(nodes)
+		if err != nil {
+			diags.AddAttributeError(
+				attribute,
+				"Invalid template data file",
+				fmt.Sprintf("%s must hold a YAML or JSON object: %s", file.ValueString(), err.Error()),
+			)
+			continue
+		}
+		diags.Append(data.merge(attribute, file.ValueString(), values)...)
+	}
+
+	if !encoded.IsNull() {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(encoded.ValueString())))
+		decoder.UseNumber()
+		var values map[string]interface{}
+		if err := decoder.Decode(&values); err != nil {
+			diags.AddAttributeError(
+				path.Root("template_data_json"),
+				"Invalid template data",
+				"template_data_json must hold a JSON object: "+err.Error(),
+			)
+		} else {
+			diags.Append(data.merge(path.Root("template_data_json"), templateDataSourceJSON, values)...)
+		}
+	}
+
+	return data, !diags.HasError(), diags
+}
+
+// yamlTemplateData decodes the values of a template data file, keeping the
+// literal of the numbers written as floats so that `1.0` is not taken for an
+// integer and no precision is lost before structuredTemplateDatum.
+func yamlTemplateData(nodes map[string]yaml.Node) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(nodes))
+	for key, node := range nodes {
+		if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!float" {
+			if _, err := strconv.ParseFloat(node.Value, 64); err == nil {
+				values[key] = json.Number(node.Value)
+				continue
+			}
+		}
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, fmt.Errorf("%q: %w", key, err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (d structuredTemplateData) merge(attribute path.Path, source string, values map[string]interface{}) (diags diag.Diagnostics) {
+	for key, value := range values {
+		if value == nil {
+			diags.AddAttributeWarning(
+				attribute,
+				"Ignored template data",
+				fmt.Sprintf("The value of %q in %s is null and is not sent to Foxops: remove it or give it a value.", key, source),
+			)
+			continue
+		}
+		datum, err := structuredTemplateDatum(value)
+		if err != nil {
+			diags.AddAttributeError(
+				attribute,
+				"Invalid template data",
+				fmt.Sprintf("The value of %q in %s cannot be sent to Foxops: %s", key, source, err.Error()),
+			)
+			continue
+		}
+		d.values[key] = datum
+		d.strings[key], _ = templateDatumString(datum)
+		d.sources[key] = source
+	}
+	return
+}
+
+// structuredTemplateDatum converts a decoded value to one of the types of the
+// template data of Foxops: strings and integers are sent as such, numbers as
+// floats when Foxops stores them exactly and as strings of their literal
+// otherwise, booleans as `true` or `false` and lists and objects encoded in
+// JSON, like the defaults of the `fengine.yaml` file.
+func structuredTemplateDatum(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case int:
+		return value, nil
+	case float64:
+		return floatTemplateDatum(value, strconv.FormatFloat(value, 'g', -1, 64))
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return int(i), nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return floatTemplateDatum(f, value.String())
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// floatTemplateDatum returns a number written as a float as the float32 of
+// the template data of Foxops, or as its literal when a float32 cannot hold
+// it exactly.
+func floatTemplateDatum(value float64, literal string) (interface{}, error) {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return nil, fmt.Errorf("%s is not a finite number", literal)
+	}
+	if float64(float32(value)) != value {
+		return literal, nil
+	}
+	return float32(value), nil
+}
+
+// mergedTemplateData merges the template data of the configuration in the
+// order of precedence, lowest first.
+func mergedTemplateData(structured types.Map, configured types.Map) types.Map {
+	if structured.IsUnknown() || configured.IsUnknown() {
+		return types.MapUnknown(types.StringType)
+	}
+	if len(structured.Elements()) == 0 {
+		return configured
+	}
+	elements := map[string]attr.Value{}
+	for key, value := range structured.Elements() {
+		elements[key] = value
+	}
+	for key, value := range configured.Elements() {
+		elements[key] = value
+	}
+	return types.MapValueMust(types.StringType, elements)
+}
+
+// templateDataSources returns the source of every key of template_data_all.
+func templateDataSources(templateDataAll types.Map, configured types.Map, structured structuredTemplateData) types.Map {
+	if templateDataAll.IsUnknown() || configured.IsUnknown() {
+		return types.MapUnknown(types.StringType)
+	}
+	elements := map[string]attr.Value{}
+	for key := range templateDataAll.Elements() {
+		source := templateDataSourceDefaults
+		if _, ok := configured.Elements()[key]; ok {
+			source = templateDataSourceConfig
+		} else if s, ok := structured.sources[key]; ok {
+			source = s
+		}
+		elements[key] = types.StringValue(source)
+	}
+	return types.MapValueMust(types.StringType, elements)
+}
+
+// typedTemplateData returns the template data sent to Foxops, where the
+// values loaded from template_data_files and template_data_json keep their
+// types. A value changed since the plan is sent as planned.
+func typedTemplateData(ctx context.Context, data incarnationResourceModel, sensitive map[string]string) (map[string]interface{}, diag.Diagnostics) {
+	result := requestTemplateData(data.TemplateDataAll, sensitive)
+	if data.TemplateDataFiles.IsNull() && data.TemplateDataJSON.IsNull() {
+		return result, nil
+	}
+
+	// The warnings were already reported by the plan.
+	structured, _, diags := loadStructuredTemplateData(ctx, data.TemplateDataFiles, data.TemplateDataJSON)
+	if diags.HasError() {
+		return nil, diags
+	}
+	sources := data.TemplateDataSources.Elements()
+	for key, value := range structured.values {
+		if _, ok := sensitive[key]; ok {
+			continue
+		}
+		source, ok := sources[key].(types.String)
+		if !ok || source.ValueString() != structured.sources[key] {
+			continue
+		}
+		if planned, ok := result[key]; ok && planned == structured.strings[key] {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func stringMapValues(m map[string]string) map[string]attr.Value {
+	elements := make(map[string]attr.Value, len(m))
+	for key, value := range m {
+		elements[key] = types.StringValue(value)
+	}
+	return elements
+}
@@ -0,0 +1,241 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"gopkg.in/yaml.v3"
+)
+
+// templateSpec is the part of the `fengine.yaml` file of a template used to
+// validate the template data of an incarnation.
+type templateSpec struct {
+	Variables map[string]templateVariable `yaml:"variables"`
+}
+
+type templateVariable struct {
+	Type        string    `yaml:"type"`
+	Description string    `yaml:"description"`
+	Default     yaml.Node `yaml:"default"`
+}
+
+// required tells whether the variable must be set, which is the case of the
+// variables without a default value.
+func (v templateVariable) required() bool {
+	return v.Default.IsZero()
+}
+
+// defaultString returns the default value of the variable as it would be set
+// in template_data: scalars as is and collections encoded in JSON.
+func (v templateVariable) defaultString() (types.String, error) {
+	if v.Default.IsZero() || v.Default.Tag == "!!null" {
+		return types.StringNull(), nil
+	}
+	if v.Default.Kind == yaml.ScalarNode {
+		return types.StringValue(v.Default.Value), nil
+	}
+	var value interface{}
+	if err := v.Default.Decode(&value); err != nil {
+		return types.StringNull(), err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return types.StringNull(), err
+	}
+	return types.StringValue(string(encoded)), nil
+}
+
+// checkValue returns an error when a template data value cannot be converted
+// to the type of the variable.
+func (v templateVariable) checkValue(value string) error {
+	switch v.Type {
+	case "int":
+		if _, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+	case "float":
+		if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+			return fmt.Errorf("expected a number")
+		}
+	case "bool":
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true", "false", "yes", "no", "on", "off", "1", "0":
+		default:
+			return fmt.Errorf("expected a boolean")
+		}
+	case "list":
+		var list []interface{}
+		if err := json.Unmarshal([]byte(value), &list); err != nil {
+			return fmt.Errorf("expected a JSON encoded list")
+		}
+	case "object":
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &object); err != nil {
+			return fmt.Errorf("expected a JSON encoded object")
+		}
+	}
+	return nil
+}
+
+var templateVariableType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"type":        types.StringType,
+		"description": types.StringType,
+		"default":     types.StringType,
+		"required":    types.BoolType,
+	},
+}
+
+func readTemplateSpec(file string) (templateSpec, error) {
+	var spec templateSpec
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return spec, err
+	}
+	if err := yaml.Unmarshal(content, &spec); err != nil {
+		return spec, fmt.Errorf("invalid template spec %s: %w", file, err)
+	}
+	return spec, nil
+}
+
+// templateVariables returns the variables declared by the spec, as stored in
+// template_variables.
+func (s templateSpec) templateVariables() (types.Map, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	variables := map[string]attr.Value{}
+	for name, variable := range s.Variables {
+		defaultValue, err := variable.defaultString()
+		if err != nil {
+			diags.AddError(
+				"Invalid template variable default",
+				fmt.Sprintf("The default value of the variable %q could not be read: %s", name, err.Error()),
+			)
+			continue
+		}
+		value, d := types.ObjectValue(templateVariableType.AttrTypes, map[string]attr.Value{
+			"type":        types.StringValue(variable.Type),
+			"description": types.StringValue(variable.Description),
+			"default":     defaultValue,
+			"required":    types.BoolValue(variable.required()),
+		})
+		diags.Append(d...)
+		variables[name] = value
+	}
+	if diags.HasError() {
+		return types.MapNull(templateVariableType), diags
+	}
+
+	result, d := types.MapValue(templateVariableType, variables)
+	diags.Append(d...)
+	return result, diags
+}
+
+// templateDataSource is a map of template data validated against a spec,
+// along with the attribute it was configured in.
+type templateDataSource struct {
+	attribute path.Path
+	values    types.Map
+	sensitive bool
+}
+
+// validateTemplateData validates the template data against the variables
+// declared by the spec. The diagnostics point at the key of the map in which
+// the offending value is set.
+func (s templateSpec) validateTemplateData(sources []templateDataSource) (diags diag.Diagnostics) {
+	set := map[string]bool{}
+
+	for _, source := range sources {
+		if source.values.IsUnknown() {
+			// The missing variables cannot be told yet.
+			return
+		}
+
+		for key, element := range source.values.Elements() {
+			set[key] = true
+
+			variable, ok := s.Variables[key]
+			if !ok {
+				diags.AddAttributeError(
+					source.attribute.AtMapKey(key),
+					"Unknown template variable",
+					fmt.Sprintf("The template does not declare the variable %q. Declared variables: %s.", key, s.variableNames()),
+				)
+				continue
+			}
+
+			value, ok := element.(types.String)
+			if !ok || value.IsNull() || value.IsUnknown() {
+				continue
+			}
+			if err := variable.checkValue(value.ValueString()); err != nil {
+				detail := fmt.Sprintf("The variable %q is of type %s: %s, got %q.", key, variable.Type, err.Error(), value.ValueString())
+				if source.sensitive {
+					detail = fmt.Sprintf("The variable %q is of type %s: %s.", key, variable.Type, err.Error())
+				}
+				diags.AddAttributeError(source.attribute.AtMapKey(key), "Invalid template variable value", detail)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(s.Variables) {
+		if s.Variables[name].required() && !set[name] {
+			diags.AddAttributeError(
+				path.Root("template_data"),
+				"Missing template variable",
+				fmt.Sprintf("The template requires the variable %q, which has no default value.", name),
+			)
+		}
+	}
+
+	return
+}
+
+func (s templateSpec) variableNames() string {
+	names := sortedKeys(s.Variables)
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+// planTemplateVariables reads the template spec file, validates the template
+// data against it and returns the declared variables.
+func planTemplateVariables(
+	_ context.Context,
+	specFile types.String,
+	sources []templateDataSource,
+) (types.Map, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	if specFile.IsNull() {
+		return types.MapNull(templateVariableType), diags
+	}
+	if specFile.IsUnknown() {
+		return types.MapUnknown(templateVariableType), diags
+	}
+
+	spec, err := readTemplateSpec(specFile.ValueString())
+	if err != nil {
+		diags.AddAttributeError(
+			path.Root("template_spec_file"),
+			"Unable to read the template spec file",
+			err.Error(),
+		)
+		return types.MapNull(templateVariableType), diags
+	}
+
+	diags.Append(spec.validateTemplateData(sources)...)
+	variables, d := spec.templateVariables()
+	diags.Append(d...)
+	return variables, diags
+}
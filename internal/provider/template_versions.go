@@ -0,0 +1,245 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+)
+
+// templateVersion is a tag of a template repository named after a semantic
+// version, like `v1.2.3`.
+type templateVersion struct {
+	tag     string
+	version *version.Version
+}
+
+// templateVersions lists the versions of template repositories through git.
+// The tags of a repository are only listed once per Terraform operation, as
+// many incarnations usually share a template repository.
+type templateVersions struct {
+	mu           sync.Mutex
+	repositories map[string]*repositoryVersions
+	// allowLocal allows listing local paths and file:// urls, which are
+	// otherwise rejected.
+	allowLocal bool
+}
+
+// repositoryVersions holds the versions of a single repository. Its lock is
+// held while listing them, so that the concurrent lookups of a repository
+// wait for a single git ls-remote without blocking the other repositories.
+type repositoryVersions struct {
+	mu       sync.Mutex
+	listed   bool
+	refs     templateRefs
+	versions []templateVersion
+}
+
+func newTemplateVersions(allowLocal bool) *templateVersions {
+	return &templateVersions{repositories: map[string]*repositoryVersions{}, allowLocal: allowLocal}
+}
+
+// list returns the versions of the repository sorted from the oldest to the
+// newest. The tags which are not semantic versions are left out.
+func (t *templateVersions) list(ctx context.Context, repository string) ([]templateVersion, error) {
+	entry, err := t.get(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+	return entry.versions, nil
+}
+
+// refs returns the tags and branches of the repository.
+func (t *templateVersions) refs(ctx context.Context, repository string) (templateRefs, error) {
+	entry, err := t.get(ctx, repository)
+	if err != nil {
+		return templateRefs{}, err
+	}
+	return entry.refs, nil
+}
+
+func (t *templateVersions) get(ctx context.Context, repository string) (*repositoryVersions, error) {
+	t.mu.Lock()
+	entry, ok := t.repositories[repository]
+	if !ok {
+		entry = &repositoryVersions{}
+		t.repositories[repository] = entry
+	}
+	t.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	if entry.listed {
+		return entry, nil
+	}
+
+	refs, err := listTemplateRefs(ctx, repository, t.allowLocal)
+	if err != nil {
+		return nil, err
+	}
+	entry.refs = refs
+	entry.versions = refs.versions()
+	entry.listed = true
+	return entry, nil
+}
+
+// resolve returns the tag of the newest version of the repository matching
+// the constraint.
+func (t *templateVersions) resolve(ctx context.Context, repository string, constraint string) (string, error) {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint %q: %w", constraint, err)
+	}
+
+	versions, err := t.list(ctx, repository)
+	if err != nil {
+		return "", err
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if constraints.Check(versions[i].version) {
+			return versions[i].tag, nil
+		}
+	}
+	return "", fmt.Errorf("no tag of %s matches the version constraint %q", repository, constraint)
+}
+
+// templateRefs are the tags and branches of a template repository.
+type templateRefs struct {
+	tags     []string
+	branches []string
+}
+
+// versions returns the tags which are semantic versions, sorted from the
+// oldest to the newest.
+func (r templateRefs) versions() []templateVersion {
+	versions := []templateVersion{}
+	for _, tag := range r.tags {
+		v, err := version.NewVersion(tag)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, templateVersion{tag: tag, version: v})
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].version.LessThan(versions[j].version)
+	})
+	return versions
+}
+
+// scpLikeRepository matches the scp-like syntax of ssh repositories, like
+// `git@example.com:group/template.git`. Neither the user nor the host may start
+// with a dash, which ssh would take for an option, and single letters are
+// Windows drives.
+var scpLikeRepository = regexp.MustCompile(`^([A-Za-z0-9_][A-Za-z0-9._~-]*@)?[A-Za-z0-9][A-Za-z0-9.-]+:[^:]`)
+
+// checkTemplateRepository rejects the repositories which are not http(s), ssh
+// or scp-like urls, like values git would take for options such as
+// `--upload-pack=<command>` or other transports such as `ext::<command>`.
+// Local paths and file:// urls are only accepted with allowLocal.
+func checkTemplateRepository(repository string, allowLocal bool) error {
+	if u, err := url.Parse(repository); err == nil && u.Host != "" {
+		switch u.Scheme {
+		case "http", "https", "ssh":
+			if !strings.HasPrefix(u.Host, "-") && !strings.HasPrefix(u.User.Username(), "-") {
+				return nil
+			}
+		}
+	}
+	if !strings.Contains(repository, "://") && scpLikeRepository.MatchString(repository) {
+		return nil
+	}
+	if allowLocal && isLocalRepository(repository) {
+		return nil
+	}
+	if allowLocal {
+		return fmt.Errorf(
+			"unsupported template repository %q: only http(s), ssh and scp-like urls, like git@example.com:group/template.git, absolute paths and file:// urls can be listed",
+			repository,
+		)
+	}
+	return fmt.Errorf(
+		"unsupported template repository %q: only http(s), ssh and scp-like urls, like git@example.com:group/template.git, can be listed, "+
+			"set allow_local_template_repositories in the provider configuration to list local repositories",
+		repository,
+	)
+}
+
+// isLocalRepository tells whether the repository is an absolute path or a
+// file:// url, like `/srv/git/template.git` or `file:///srv/git/template.git`.
+func isLocalRepository(repository string) bool {
+	if rest, ok := strings.CutPrefix(repository, "file://"); ok {
+		return strings.HasPrefix(rest, "/")
+	}
+	return filepath.IsAbs(repository)
+}
+
+func listTemplateRefs(ctx context.Context, repository string, allowLocal bool) (templateRefs, error) {
+	refs := templateRefs{tags: []string{}, branches: []string{}}
+	if err := checkTemplateRepository(repository, allowLocal); err != nil {
+		return refs, err
+	}
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--tags", "--heads", "--refs", "--", repository)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Fail instead of waiting for credentials nobody can type in, and do not
+	// follow redirections to other transports, like ext:: or, unless local
+	// repositories are allowed, file://.
+	protocols := "http:https:ssh"
+	if allowLocal {
+		protocols += ":file"
+	}
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ALLOW_PROTOCOL="+protocols)
+
+	if err := cmd.Run(); err != nil {
+		return refs, fmt.Errorf("unable to list the tags and branches of %s: %w: %s", repository, err, strings.TrimSpace(stderr.String()))
+	}
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if tag, ok := strings.CutPrefix(fields[1], "refs/tags/"); ok {
+			refs.tags = append(refs.tags, tag)
+		} else if branch, ok := strings.CutPrefix(fields[1], "refs/heads/"); ok {
+			refs.branches = append(refs.branches, branch)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return refs, err
+	}
+
+	sort.Strings(refs.tags)
+	sort.Strings(refs.branches)
+	return refs, nil
+}
+
+// isDowngrade tells whether moving from one version to the other is a
+// downgrade. Versions which are not semantic versions, like branches or
+// commits, cannot be compared and are never considered a downgrade.
+func isDowngrade(from string, to string) bool {
+	fromVersion, err := version.NewVersion(from)
+	if err != nil {
+		return false
+	}
+	toVersion, err := version.NewVersion(to)
+	if err != nil {
+		return false
+	}
+	return toVersion.LessThan(fromVersion)
+}
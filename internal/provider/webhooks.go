@@ -0,0 +1,496 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+const defaultWebhookFallbackPollInterval = 5 * time.Minute
+
+// webhookRelayRetryInterval is the delay before reconnecting to a relay.
+var webhookRelayRetryInterval = 5 * time.Second
+
+// webhookRelayIdleTimeout is how long the connection to a relay is kept open
+// once nothing waits for its events, so that the successive waits of an
+// operation share it.
+var webhookRelayIdleTimeout = time.Minute
+
+// mergeRequestWebhooksModel holds the values of the provider
+// `merge_request_webhooks` block.
+type mergeRequestWebhooksModel struct {
+	ListenAddress        types.String `tfsdk:"listen_address"`
+	RelayURL             types.String `tfsdk:"relay_url"`
+	Secret               types.String `tfsdk:"secret"`
+	FallbackPollInterval types.String `tfsdk:"fallback_poll_interval"`
+}
+
+var mergeRequestWebhooksSchema = schema.SingleNestedAttribute{
+	MarkdownDescription: "Receive the merge request webhooks of GitLab or GitHub while waiting for a merge request status, " +
+		"instead of polling Foxops every second. The incarnation is read again when an event is received for its merge request, " +
+		"and at `fallback_poll_interval` in case an event is missed.",
+	Optional: true,
+	Attributes: map[string]schema.Attribute{
+		"listen_address": schema.StringAttribute{
+			MarkdownDescription: "The address on which the provider listens for webhooks, like `0.0.0.0:8080`. " +
+				"The forge must be able to reach it. Exactly one of `listen_address` and `relay_url` must be set.",
+			Optional: true,
+			Validators: []validator.String{
+				stringvalidator.ExactlyOneOf(path.MatchRelative().AtParent().AtName("relay_url")),
+			},
+		},
+		"relay_url": schema.StringAttribute{
+			MarkdownDescription: "The url of a relay forwarding the webhooks as server-sent events, " +
+				"for when the forge cannot reach the machine running Terraform. " +
+				"The data of each event is a JSON object with the `headers` and the raw `body` of a webhook delivery. " +
+				"The provider is connected to the relay while it waits for merge requests, and for a minute afterwards.",
+			Optional: true,
+		},
+		"secret": schema.StringAttribute{
+			MarkdownDescription: "The secret configured on the webhooks. " +
+				"It is compared to the `X-Gitlab-Token` header of GitLab events and verifies the `X-Hub-Signature-256` header of GitHub events.",
+			Required:  true,
+			Sensitive: true,
+		},
+		"fallback_poll_interval": schema.StringAttribute{
+			MarkdownDescription: "The delay between two reads of an incarnation when no event is received. " +
+				"It should be a sequence of numbers followed by a unit suffix (`s`, `m` or `h`). Example: `1m30s`. Default: `5m`.",
+			Optional:   true,
+			Validators: []validator.String{durationValidator},
+		},
+	},
+}
+
+// mergeRequestEvent is a merge request webhook event of a forge.
+type mergeRequestEvent struct {
+	// Id is the id of the merge request within its repository, as returned
+	// by Foxops in merge_request_id.
+	Id  string
+	Url string
+}
+
+var (
+	errUnauthorizedWebhook = errors.New("invalid webhook secret")
+	errIgnoredWebhook      = errors.New("not a merge request event")
+)
+
+// mergeRequestEvents dispatches the merge request webhook events to the
+// merge request waits.
+type mergeRequestEvents struct {
+	mu          sync.Mutex
+	secret      string
+	subscribers map[*mergeRequestSubscriber]bool
+
+	// connect, when set, receives the events until its context is
+	// cancelled. It runs while there are subscribers.
+	connect    func(ctx context.Context)
+	disconnect context.CancelFunc
+	idle       *time.Timer
+}
+
+type mergeRequestSubscriber struct {
+	id      string
+	url     string
+	updates chan struct{}
+}
+
+func newMergeRequestEvents() *mergeRequestEvents {
+	return &mergeRequestEvents{subscribers: map[*mergeRequestSubscriber]bool{}}
+}
+
+func (e *mergeRequestEvents) setSecret(secret string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.secret = secret
+}
+
+// subscribe returns a channel notified of the events of a merge request and
+// a function to cancel the subscription. Several events received before the
+// channel is read are notified once.
+func (e *mergeRequestEvents) subscribe(id string, url string) (<-chan struct{}, func()) {
+	subscriber := &mergeRequestSubscriber{id: id, url: url, updates: make(chan struct{}, 1)}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subscribers[subscriber] = true
+
+	if e.connect != nil {
+		if e.idle != nil {
+			e.idle.Stop()
+			e.idle = nil
+		}
+		if e.disconnect == nil {
+			var ctx context.Context
+			ctx, e.disconnect = context.WithCancel(context.Background())
+			go e.connect(ctx)
+		}
+	}
+
+	return subscriber.updates, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subscribers, subscriber)
+
+		if len(e.subscribers) == 0 && e.disconnect != nil {
+			e.idle = time.AfterFunc(webhookRelayIdleTimeout, e.disconnectIfIdle)
+		}
+	}
+}
+
+// disconnectIfIdle stops receiving the events when there are no subscribers.
+func (e *mergeRequestEvents) disconnectIfIdle() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.subscribers) == 0 && e.disconnect != nil {
+		e.disconnect()
+		e.disconnect = nil
+	}
+}
+
+func (e *mergeRequestEvents) publish(event mergeRequestEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for subscriber := range e.subscribers {
+		if subscriber.id != event.Id {
+			continue
+		}
+		// The ids of merge requests are only unique within a repository.
+		if subscriber.url != "" && event.Url != "" && subscriber.url != event.Url {
+			continue
+		}
+		select {
+		case subscriber.updates <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// parse verifies a webhook delivery with the shared secret and reads the
+// merge request it is about.
+func (e *mergeRequestEvents) parse(header http.Header, body []byte) (mergeRequestEvent, error) {
+	e.mu.Lock()
+	secret := e.secret
+	e.mu.Unlock()
+
+	switch {
+	case header.Get("X-Gitlab-Event") != "":
+		if subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), []byte(secret)) != 1 {
+			return mergeRequestEvent{}, errUnauthorizedWebhook
+		}
+		if header.Get("X-Gitlab-Event") != "Merge Request Hook" {
+			return mergeRequestEvent{}, errIgnoredWebhook
+		}
+		var payload struct {
+			ObjectAttributes struct {
+				Iid int64  `json:"iid"`
+				Url string `json:"url"`
+			} `json:"object_attributes"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return mergeRequestEvent{}, err
+		}
+		return mergeRequestEvent{
+			Id:  strconv.FormatInt(payload.ObjectAttributes.Iid, 10),
+			Url: payload.ObjectAttributes.Url,
+		}, nil
+
+	case header.Get("X-GitHub-Event") != "":
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(header.Get("X-Hub-Signature-256")), []byte(expected)) {
+			return mergeRequestEvent{}, errUnauthorizedWebhook
+		}
+		if header.Get("X-GitHub-Event") != "pull_request" {
+			return mergeRequestEvent{}, errIgnoredWebhook
+		}
+		var payload struct {
+			Number      int64 `json:"number"`
+			PullRequest struct {
+				HtmlUrl string `json:"html_url"`
+			} `json:"pull_request"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return mergeRequestEvent{}, err
+		}
+		return mergeRequestEvent{
+			Id:  strconv.FormatInt(payload.Number, 10),
+			Url: payload.PullRequest.HtmlUrl,
+		}, nil
+	}
+
+	return mergeRequestEvent{}, errIgnoredWebhook
+}
+
+// deliver verifies and publishes a webhook delivery.
+func (e *mergeRequestEvents) deliver(ctx context.Context, header http.Header, body []byte) error {
+	event, err := e.parse(header, body)
+	if err != nil {
+		return err
+	}
+	tflog.Debug(ctx, "received a merge request event", map[string]interface{}{
+		"merge_request_id":  event.Id,
+		"merge_request_url": event.Url,
+	})
+	e.publish(event)
+	return nil
+}
+
+func (e *mergeRequestEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch err := e.deliver(r.Context(), r.Header, body); {
+	case errors.Is(err, errUnauthorizedWebhook):
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, errIgnoredWebhook):
+		w.WriteHeader(http.StatusNoContent)
+	case err != nil:
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// The listeners and relay connections outlive a provider configuration, as
+// a provider process may be configured several times.
+var (
+	webhookSourcesMu sync.Mutex
+	webhookListeners = map[string]*mergeRequestEvents{}
+	webhookRelays    = map[string]*mergeRequestEvents{}
+)
+
+// listenForWebhooks starts an HTTP server receiving webhooks on the address,
+// unless one is already running.
+func listenForWebhooks(address string, secret string) (*mergeRequestEvents, error) {
+	webhookSourcesMu.Lock()
+	defer webhookSourcesMu.Unlock()
+
+	if events, ok := webhookListeners[address]; ok {
+		events.setSecret(secret)
+		return events, nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	events := newMergeRequestEvents()
+	events.setSecret(secret)
+	server := &http.Server{Handler: events, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener) //nolint:errcheck
+	webhookListeners[address] = events
+	return events, nil
+}
+
+// connectToWebhookRelay returns the events of a relay, to which the provider
+// connects while merge requests are waited for.
+func connectToWebhookRelay(relayURL string, secret string) *mergeRequestEvents {
+	webhookSourcesMu.Lock()
+	defer webhookSourcesMu.Unlock()
+
+	if events, ok := webhookRelays[relayURL]; ok {
+		events.setSecret(secret)
+		return events
+	}
+
+	events := newMergeRequestEvents()
+	events.setSecret(secret)
+	events.connect = func(ctx context.Context) {
+		for {
+			err := readWebhookRelay(ctx, relayURL, events)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				tflog.Warn(ctx, "lost the connection to the webhook relay", map[string]interface{}{"error": err.Error()})
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(webhookRelayRetryInterval):
+			}
+		}
+	}
+	webhookRelays[relayURL] = events
+	return events
+}
+
+// readWebhookRelay reads the server-sent events of a relay until the
+// connection is closed.
+func readWebhookRelay(ctx context.Context, relayURL string, events *mergeRequestEvents) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, relayURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from the webhook relay", resp.StatusCode)
+	}
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		var delivery struct {
+			Headers map[string]string `json:"headers"`
+			Body    string            `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(data.String()), &delivery); err == nil {
+			header := http.Header{}
+			for key, value := range delivery.Headers {
+				header.Set(key, value)
+			}
+			if err := events.deliver(ctx, header, []byte(delivery.Body)); errors.Is(err, errUnauthorizedWebhook) {
+				tflog.Warn(ctx, "ignored a relayed webhook with an invalid secret")
+			}
+		}
+		data.Reset()
+	}
+	return scanner.Err()
+}
+
+// webhookClient is a FoxopsClient waiting for merge request statuses on
+// webhook events instead of polling.
+type webhookClient struct {
+	FoxopsClient
+
+	events               *mergeRequestEvents
+	fallbackPollInterval time.Duration
+}
+
+var _ FoxopsClient = (*webhookClient)(nil)
+
+// newWebhookClient wraps client so that its merge request waits are woken up
+// by the webhooks received from the listener or relay of the settings.
+func newWebhookClient(client FoxopsClient, settings *mergeRequestWebhooksModel) (FoxopsClient, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	attribute := path.Root("merge_request_webhooks")
+
+	fallbackPollInterval := defaultWebhookFallbackPollInterval
+	if !settings.FallbackPollInterval.IsNull() {
+		var err error
+		fallbackPollInterval, err = time.ParseDuration(settings.FallbackPollInterval.ValueString())
+		if err != nil {
+			diags.AddAttributeError(attribute.AtName("fallback_poll_interval"), "Invalid fallback poll interval", err.Error())
+			return client, diags
+		}
+	}
+
+	var events *mergeRequestEvents
+	if !settings.ListenAddress.IsNull() {
+		var err error
+		events, err = listenForWebhooks(settings.ListenAddress.ValueString(), settings.Secret.ValueString())
+		if err != nil {
+			diags.AddAttributeError(attribute.AtName("listen_address"), "Unable to listen for webhooks", err.Error())
+			return client, diags
+		}
+	} else {
+		events = connectToWebhookRelay(settings.RelayURL.ValueString(), settings.Secret.ValueString())
+	}
+
+	return &webhookClient{
+		FoxopsClient:         client,
+		events:               events,
+		fallbackPollInterval: fallbackPollInterval,
+	}, diags
+}
+
+func (c *webhookClient) unwrap() FoxopsClient {
+	return c.FoxopsClient
+}
+
+func (c *webhookClient) GetIncarnationWithMergeRequestStatus(
+	ctx context.Context,
+	id IncarnationId,
+	status string,
+) (Incarnation, error) {
+	var updates <-chan struct{}
+
+	for {
+		inc, err := getFreshIncarnation(ctx, c.FoxopsClient, id)
+		if err != nil {
+			return inc, err
+		}
+		if inc.MergeRequestId == nil {
+			return inc, nil
+		}
+		if inc.MergeRequestSettled(status) {
+			return inc, nil
+		}
+
+		if updates == nil {
+			url := ""
+			if inc.MergeRequestUrl != nil {
+				url = *inc.MergeRequestUrl
+			}
+			var cancel func()
+			updates, cancel = c.events.subscribe(*inc.MergeRequestId, url)
+			defer cancel()
+			// An event may have been received while subscribing.
+			continue
+		}
+
+		tflog.Info(ctx, "waiting for a merge request event", map[string]interface{}{
+			"id":            id,
+			"merge_request": mergeRequestReference(inc),
+			"status":        status,
+		})
+
+		select {
+		case <-ctx.Done():
+			return inc, ctx.Err()
+		case <-updates:
+		case <-time.After(c.fallbackPollInterval):
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package provider_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Roche/terraform-provider-foxops/internal/helpers"
+	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+const webhookMergeRequestUrl = "https://gitlab.example.com/inc/repo/-/merge_requests/2"
+
+func gitlabMergeRequestWebhook(secret string) (http.Header, []byte) {
+	header := http.Header{}
+	header.Set("X-Gitlab-Event", "Merge Request Hook")
+	header.Set("X-Gitlab-Token", secret)
+	return header, []byte(fmt.Sprintf(`{"object_kind": "merge_request", "object_attributes": {"iid": 2, "url": %q, "state": "merged"}}`, webhookMergeRequestUrl))
+}
+
+func githubPullRequestWebhook(secret string) (http.Header, []byte) {
+	body := []byte(fmt.Sprintf(`{"action": "closed", "number": 2, "pull_request": {"html_url": %q, "merged": true}}`, webhookMergeRequestUrl))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "pull_request")
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return header, body
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// postWebhook delivers a webhook to the listener of the provider, retrying
+// until the listener is started.
+func postWebhook(t *testing.T, address string, header http.Header, body []byte) int {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, "http://"+address+"/", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil && attempt < 50 {
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+}
+
+func TestAccIncarnationResource_MergeRequestWebhooksShouldEndTheWait(t *testing.T) {
+	tests := map[string]struct {
+		webhook func(secret string) (http.Header, []byte)
+		relay   bool
+		// closed closes the merge request instead of merging it, which
+		// ends the wait as well.
+		closed bool
+	}{
+		"gitlab":        {webhook: gitlabMergeRequestWebhook},
+		"github":        {webhook: githubPullRequestWebhook},
+		"gitlab relay":  {webhook: gitlabMergeRequestWebhook, relay: true},
+		"gitlab closed": {webhook: gitlabMergeRequestWebhook, closed: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			address := freeAddress(t)
+
+			deliveries := make(chan []byte, 1)
+			relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				for {
+					select {
+					case <-r.Context().Done():
+						return
+					case delivery := <-deliveries:
+						fmt.Fprintf(w, "event: webhook\ndata: %s\n\n", delivery)
+						w.(http.Flusher).Flush()
+					}
+				}
+			}))
+			// The provider stays connected to the relay.
+			defer func() {
+				relay.CloseClientConnections()
+				relay.Close()
+			}()
+
+			deliver := func(secret string) int {
+				header, body := tt.webhook(secret)
+				if !tt.relay {
+					return postWebhook(t, address, header, body)
+				}
+				headers := map[string]string{}
+				for key := range header {
+					headers[key] = header.Get(key)
+				}
+				delivery, err := json.Marshal(map[string]interface{}{"headers": headers, "body": string(body)})
+				require.NoError(t, err)
+				deliveries <- delivery
+				return http.StatusNoContent
+			}
+
+			setup := newTestProviderSetup(t)
+			setup.expectNoExistingIncarnations()
+
+			var mu sync.Mutex
+			current := provider.Incarnation{
+				Id:                        provider.IncarnationId("1234"),
+				IncarnationRepository:     "inc/repo",
+				TemplateRepository:        "template/repo",
+				TemplateRepositoryVersion: "v1.0.0",
+				TargetDirectory:           ".",
+				CommitSha:                 "12345678",
+				CommitUrl:                 "template/repo/commit",
+				TemplateData:              map[string]interface{}{},
+			}
+			get := func() provider.Incarnation {
+				mu.Lock()
+				defer mu.Unlock()
+				return current
+			}
+
+			setup.client.EXPECT().
+				CreateIncarnation(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(context.Context, provider.CreateIncarnationRequest) (provider.Incarnation, error) {
+					return get(), nil
+				})
+
+			delivered := make(chan struct{})
+			setup.client.EXPECT().
+				UpdateIncarnation(gomock.Any(), current.Id, gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ provider.IncarnationId, req provider.UpdateIncarnationRequest) (provider.Incarnation, error) {
+					mu.Lock()
+					current.TemplateRepositoryVersion = req.TemplateRepositoryVersion
+					current.MergeRequestId = helpers.Addr("2")
+					current.MergeRequestStatus = helpers.Addr("open")
+					current.MergeRequestUrl = helpers.Addr(webhookMergeRequestUrl)
+					mu.Unlock()
+
+					go func() {
+						defer close(delivered)
+						time.Sleep(500 * time.Millisecond)
+						if !tt.relay {
+							assert.Equal(t, http.StatusUnauthorized, deliver("wrong-secret"))
+						}
+
+						mu.Lock()
+						if tt.closed {
+							current.MergeRequestStatus = helpers.Addr("closed")
+						} else {
+							current.MergeRequestStatus = helpers.Addr("merged")
+						}
+						mu.Unlock()
+						assert.Equal(t, http.StatusNoContent, deliver("webhook-secret"))
+					}()
+
+					return get(), nil
+				})
+
+			// Waits are served by webhooks, GetIncarnationWithMergeRequestStatus is
+			// never called.
+			setup.client.EXPECT().
+				GetIncarnation(gomock.Any(), current.Id).
+				DoAndReturn(func(context.Context, provider.IncarnationId) (provider.Incarnation, error) {
+					return get(), nil
+				}).
+				AnyTimes()
+
+			setup.client.EXPECT().
+				DeleteIncarnation(gomock.Any(), current.Id).
+				Return(nil)
+
+			source := fmt.Sprintf("listen_address = %q", address)
+			if tt.relay {
+				source = fmt.Sprintf("relay_url = %q", relay.URL)
+			}
+
+			config := func(version string) string {
+				return fmt.Sprintf(`
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  merge_request_webhooks = {
+    %s
+    secret                 = "webhook-secret"
+    fallback_poll_interval = "1h"
+  }
+}
+
+resource "foxops_incarnation" "test" {
+  incarnation_repository      = "inc/repo"
+  target_directory            = "."
+  template_repository         = "template/repo"
+  template_repository_version = %q
+
+  wait_for_mr_status_on_update = {
+    status  = "merged"
+    timeout = "30s"
+  }
+}
+`, source, version)
+			}
+
+			update := resource.TestStep{
+				Config: config("v2.0.0"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("foxops_incarnation.test", "merge_request_status", "merged"),
+				),
+			}
+			if tt.closed {
+				// The wait ends as soon as the merge request is closed instead of
+				// at its timeout.
+				update.Check = nil
+				update.ExpectError = regexp.MustCompile(`(?s)merge request settled before reaching the expected status.*is\s+closed\s+instead\s+of\s+merged`)
+			}
+
+			resource.Test(t, resource.TestCase{
+				IsUnitTest:               true,
+				ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+				Steps: []resource.TestStep{
+					{
+						Config: config("v1.0.0"),
+					},
+					update,
+				},
+			})
+
+			<-delivered
+		})
+	}
+}
+
+func TestAccProvider_MergeRequestWebhooksShouldRejectAnInvalidFallbackPollInterval(t *testing.T) {
+	setup := newTestProviderSetup(t)
+
+	resource.Test(t, resource.TestCase{
+		IsUnitTest:               true,
+		ProtoV6ProviderFactories: setup.testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: `
+provider "foxops" {
+  endpoint = "http://localhost:9876"
+  token    = "fake-token"
+
+  merge_request_webhooks = {
+    relay_url              = "http://localhost:9877/events"
+    secret                 = "webhook-secret"
+    fallback_poll_interval = "5 minutes"
+  }
+}
+
+data "foxops_incarnation" "test" {
+  id = "1234"
+}
+`,
+				ExpectError: regexp.MustCompile(`must be a sequence of numbers with a unit suffix`),
+			},
+		},
+	})
+}
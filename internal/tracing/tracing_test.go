@@ -0,0 +1,69 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		want bool
+	}{
+		{name: "WithoutEnvironment", env: map[string]string{}, want: false},
+		{name: "WithEndpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318"}, want: true},
+		{name: "WithTracesEndpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://localhost:4318"}, want: true},
+		{name: "WithOtlpExporter", env: map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}, want: true},
+		{
+			name: "WithNoneExporter",
+			env: map[string]string{
+				"OTEL_TRACES_EXPORTER":        "none",
+				"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318",
+			},
+			want: false,
+		},
+		{
+			name: "WithSdkDisabled",
+			env: map[string]string{
+				"OTEL_SDK_DISABLED":    "true",
+				"OTEL_TRACES_EXPORTER": "otlp",
+			},
+			want: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, key := range []string{
+				"OTEL_SDK_DISABLED",
+				"OTEL_TRACES_EXPORTER",
+				"OTEL_EXPORTER_OTLP_ENDPOINT",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+			} {
+				t.Setenv(key, tc.env[key])
+			}
+
+			require.Equal(t, tc.want, tracing.Enabled())
+		})
+	}
+}
+
+func TestSetup_ShouldBeANoopWhenDisabled(t *testing.T) {
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+
+	shutdown, err := tracing.Setup(context.Background(), "test")
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+}
+
+func TestSetup_ShouldExportWhenEnabled(t *testing.T) {
+	t.Setenv("OTEL_SDK_DISABLED", "")
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+
+	shutdown, err := tracing.Setup(context.Background(), "test")
+	require.NoError(t, err)
+	require.NoError(t, shutdown(context.Background()))
+}
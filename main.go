@@ -7,7 +7,11 @@ import (
 
 	"github.com/Roche/terraform-provider-foxops/internal/client"
 	"github.com/Roche/terraform-provider-foxops/internal/provider"
+	"github.com/Roche/terraform-provider-foxops/internal/tracing"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/list"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 )
@@ -30,8 +34,22 @@ func main() {
 		Debug:   debug,
 	}
 
-	err := providerserver.Serve(
-		context.Background(),
+	ctx := context.Background()
+
+	// A broken tracing configuration must not prevent the provider from
+	// running: it only runs without tracing then.
+	shutdownTracing, err := tracing.Setup(ctx, version)
+	if err != nil {
+		log.Printf("[WARN] tracing disabled: %s", err)
+	}
+	defer func() {
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("failed to flush traces: %s", err)
+		}
+	}()
+
+	err = providerserver.Serve(
+		ctx,
 		provider.New(
 			provider.Version(version),
 			provider.ClientConstructor(
@@ -39,15 +57,37 @@ func main() {
 					ce provider.ClientEndpoint,
 					ct provider.ClientToken,
 					v provider.Version,
+					cc provider.ClientConfig,
 				) provider.FoxopsClient {
-					return client.New(ce, ct, v)
+					return client.New(
+						ce,
+						ct,
+						v,
+						client.ClientHeaders(cc.Headers),
+						client.ClientSensitiveTemplateDataKeys(cc.SensitiveTemplateDataKeys),
+					)
 				},
 			),
 			[]func() datasource.DataSource{
 				provider.NewIncarnationDataSource,
+				provider.NewTemplateVersionsDataSource,
 			},
 			[]func() resource.Resource{
 				provider.NewIncarnationResource,
+				provider.NewIncarnationTemplateDataResource,
+				provider.NewTemplateRolloutResource,
+			},
+			[]func() function.Function{
+				provider.NewParseMergeRequestUrlFunction,
+				provider.NewIncarnationImportIdFunction,
+				provider.NewTemplateVersionCompareFunction,
+				provider.NewMergeTemplateDataFunction,
+			},
+			[]func() ephemeral.EphemeralResource{
+				provider.NewTokenEphemeralResource,
+			},
+			[]func() list.ListResource{
+				provider.NewIncarnationListResource,
 			},
 		),
 		opts,